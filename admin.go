@@ -0,0 +1,93 @@
+package wave
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/outofforest/wave/wire"
+)
+
+// PeerRole tells if peer is a client or a server.
+type PeerRole string
+
+const (
+	// RoleClient is the role of the client.
+	RoleClient PeerRole = "client"
+
+	// RoleServer is the role of the server.
+	RoleServer PeerRole = "server"
+)
+
+// Peer describes peer connected to the server.
+type Peer struct {
+	ID            wire.PeerID
+	RemoteAddress string
+	Role          PeerRole
+
+	// Requests are the messages requested by the client.
+	Requests []wire.MessageDescriptor
+}
+
+// PeerQueue describes the queue of revisions waiting to be sent to the peer.
+type PeerQueue struct {
+	PeerID        wire.PeerID
+	RemoteAddress string
+	Depth         int
+	Capacity      int
+}
+
+// StoredRevision describes the latest revision of the message stored by the server.
+type StoredRevision struct {
+	Namespace wire.Namespace
+	MessageID wire.MessageID
+	Sender    wire.PeerID
+	Index     wire.Revision
+	Size      int
+
+	// Fingerprint identifies the layout of the message type used by the sender.
+	Fingerprint uint64
+}
+
+// Peers returns peers connected to the server.
+func (s *Server) Peers() []Peer {
+	peers, _ := s.conns.Peers()
+	return peers
+}
+
+// Queues returns queues of revisions waiting to be sent to peers.
+func (s *Server) Queues() []PeerQueue {
+	_, queues := s.conns.Peers()
+	return queues
+}
+
+// Revisions returns revisions stored by the server.
+func (s *Server) Revisions() []StoredRevision {
+	return s.conns.Revisions()
+}
+
+// AdminHandler returns read-only HTTP handler serving the state of the server as JSON.
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /peers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.Peers())
+	})
+	mux.HandleFunc("GET /revisions", func(w http.ResponseWriter, r *http.Request) {
+		revs := map[wire.Namespace][]StoredRevision{}
+		for _, rev := range s.Revisions() {
+			revs[rev.Namespace] = append(revs[rev.Namespace], rev)
+		}
+		writeJSON(w, revs)
+	})
+	mux.HandleFunc("GET /queues", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.Queues())
+	})
+	mux.HandleFunc("GET /members", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.Members())
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
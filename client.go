@@ -2,6 +2,9 @@ package wave
 
 import (
 	"context"
+	"net/http"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 
@@ -15,69 +18,159 @@ import (
 	"github.com/outofforest/wave/wire"
 )
 
+// seedFailureLimit is the number of consecutive failures after which seed is dropped if other servers are known.
+const seedFailureLimit = 3
+
+// ErrClientStopped is returned when client is used after it has stopped.
+var ErrClientStopped = errors.New("client is stopped")
+
 type msgToSend struct {
-	Header     *wire.Header
-	Marshaller proton.Marshaller
-	Message    any
+	Header *wire.Header
+
+	// Content is the frame of the encoded message.
+	Content []byte
+
+	// Subscription is sent instead of the message if set.
+	Subscription *wire.Subscription
 }
 
 type clientConns struct {
-	clientID wire.PeerID
-	recvCh   chan<- any
+	clientID       wire.PeerID
+	envelopes      bool
+	maxMessageSize uint64
+	metrics        *metrics
+
+	// Messages are queued for the receiver when revisions are applied, so they are received in the same order
+	// and nothing waits for slow receiver.
+	receiver *deliveryQueue
 
 	mu           sync.RWMutex
-	conns        map[<-chan msgToSend]chan<- msgToSend
+	conns        map[*sendQueue]struct{}
 	sentMsgs     map[wire.MessageDescriptor]msgToSend
-	receivedMsgs map[revDescriptor]wire.Revision
+	receivedMsgs *revisionStore
+	latest       map[revDescriptor]delivery
+	stopped      bool
+
+	// Subscribers are replaced on each change, so delivery uses the ones registered when revision is applied.
+	subscribers []*subscriber
+
+	// Requests are replaced on each change, so the slice passed to connections is never modified.
+	requests        []wire.NamespaceRequest
+	reqs            map[wire.MessageDescriptor]struct{}
+	sentRequests    []wire.NamespaceRequest
+	requestsVersion uint64
+	marshallers     map[wire.Namespace]proton.Marshaller
+	fingerprints    map[wire.Namespace]map[wire.MessageID]uint64
 }
 
-func newClientConns(clientID wire.PeerID, recvCh chan<- any) *clientConns {
-	return &clientConns{
-		clientID:     clientID,
-		recvCh:       recvCh,
-		conns:        map[<-chan msgToSend]chan<- msgToSend{},
-		sentMsgs:     map[wire.MessageDescriptor]msgToSend{},
-		receivedMsgs: map[revDescriptor]wire.Revision{},
+type delivery struct {
+	Header  *wire.Header
+	Message any
+}
+
+func newClientConns(
+	clientID wire.PeerID,
+	recvCh chan<- any,
+	envelopes bool,
+	maxMessageSize uint64,
+	metrics *metrics,
+) *clientConns {
+	c := &clientConns{
+		clientID:       clientID,
+		envelopes:      envelopes,
+		maxMessageSize: maxMessageSize,
+		metrics:        metrics,
+		receiver:       newDeliveryQueue(),
+		conns:          map[*sendQueue]struct{}{},
+		sentMsgs:       map[wire.MessageDescriptor]msgToSend{},
+		receivedMsgs:   newRevisionStore(),
+		latest:         map[revDescriptor]delivery{},
+		reqs:           map[wire.MessageDescriptor]struct{}{},
+		marshallers:    map[wire.Namespace]proton.Marshaller{},
+		fingerprints:   map[wire.Namespace]map[wire.MessageID]uint64{},
 	}
+	go c.receiver.Run(recvCh)
+	return c
+}
+
+// Requests returns requests sent to servers in hello, together with their version.
+func (c *clientConns) Requests() ([]wire.NamespaceRequest, uint64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.sentRequests, c.requestsVersion
 }
 
-func (c *clientConns) Add() <-chan msgToSend {
-	ch := make(chan msgToSend, 10)
+// Add registers connection and returns snapshot of the sent messages together with indexes of all
+// the revisions known at the moment of registration. If requests have changed since the version sent in hello,
+// subscription is sent first.
+func (c *clientConns) Add(
+	requestsVersion uint64,
+) (*sendQueue, map[revDescriptor]msgToSend, map[revDescriptor]wire.Revision) {
+	queue := newSendQueue()
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.conns[ch] = ch
+	c.conns[queue] = struct{}{}
+	if requestsVersion != c.requestsVersion {
+		queue.Push(msgToSend{
+			Subscription: &wire.Subscription{Requests: c.sentRequests},
+		})
+	}
 
-	for _, m := range c.sentMsgs {
-		ch <- m
+	sent := make(map[revDescriptor]msgToSend, len(c.sentMsgs))
+	sentIndexes := make(map[revDescriptor]wire.Revision, len(c.sentMsgs))
+	for msgDesc, m := range c.sentMsgs {
+		revDesc := revDescriptor{
+			MessageDescriptor: msgDesc,
+			Sender:            c.clientID,
+		}
+		sent[revDesc] = m
+		sentIndexes[revDesc] = m.Header.Revision.Index
 	}
 
-	return ch
+	revIndexes := c.receivedMsgs.Indexes()
+	mergeIndexes(revIndexes, sentIndexes)
+
+	return queue, sent, revIndexes
 }
 
-func (c *clientConns) Remove(ch <-chan msgToSend) {
+func (c *clientConns) Remove(queue *sendQueue) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if ch2, exists := c.conns[ch]; exists {
-		delete(c.conns, ch)
-		close(ch2)
-	}
+	delete(c.conns, queue)
+	queue.Close()
 }
 
-func (c *clientConns) Broadcast(msg any, marshaller proton.Marshaller) error {
+// Broadcast queues message on all the connections. Message is encoded first, so errors are reported
+// before anything is sent. It never waits for the connections.
+func (c *clientConns) Broadcast(
+	msg any,
+	marshaller proton.Marshaller,
+	trace wire.TraceContext,
+) (*wire.Header, error) {
+	msgID, err := marshaller.ID(msg)
+	if err != nil {
+		return nil, err
+	}
+	content, err := encodeFrame(msg, marshaller, c.maxMessageSize)
+	if err != nil {
+		return nil, err
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	msgID, err := marshaller.ID(msg)
-	if err != nil {
-		return err
+	if c.stopped {
+		return nil, errors.WithStack(ErrClientStopped)
 	}
 
 	msgDescriptor := wire.MessageDescriptor{
-		Namespace: marshallerToNamespace(marshaller),
-		MessageID: wire.MessageID(msgID),
+		Namespace:   marshallerToNamespace(marshaller),
+		MessageID:   wire.MessageID(msgID),
+		Fingerprint: SchemaFingerprint(msg),
 	}
 
 	var revIndex wire.Revision
@@ -92,67 +185,278 @@ func (c *clientConns) Broadcast(msg any, marshaller proton.Marshaller) error {
 				Message: msgDescriptor,
 				Index:   revIndex,
 			},
+			Trace: trace,
 		},
-		Marshaller: marshaller,
-		Message:    msg,
+		Content: content,
 	}
 
 	c.sentMsgs[msgDescriptor] = send
 
-	for _, ch := range c.conns {
-		ch <- send
+	start := time.Now()
+	for queue := range c.conns {
+		queue.Push(send)
 	}
+	c.metrics.Broadcasted(time.Since(start))
 
-	return nil
+	return send.Header, nil
+}
+
+// Subscribe adds requests of the client and sends them to the connected servers. Marshaller decodes messages
+// of the requested namespaces unless other one has been registered before. Known revisions of the messages
+// not requested by the client before are replayed, servers send only the ones not received yet.
+func (c *clientConns) Subscribe(
+	requests []wire.NamespaceRequest,
+	marshaller proton.Marshaller,
+	fingerprints map[wire.MessageID]uint64,
+) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.registerMarshaller(requests, marshaller, fingerprints)
+
+	prevReqs := c.reqs
+	c.setRequests(append(slices.Clip(c.requests), requests...))
+
+	var replayed []revDescriptor
+	for revDesc := range c.latest {
+		if requested(c.reqs, revDesc.MessageDescriptor) && !requested(prevReqs, revDesc.MessageDescriptor) {
+			replayed = append(replayed, revDesc)
+		}
+	}
+	sortRevDescriptors(replayed)
+	for _, revDesc := range replayed {
+		c.receiver.Push(revDesc, c.message(c.latest[revDesc]))
+	}
+}
+
+// Unsubscribe removes requests of the client and sends the remaining ones to the connected servers. Request without
+// message IDs removes all the requests of the namespace. Marshallers are kept, so messages being already
+// on the way are decoded.
+func (c *clientConns) Unsubscribe(requests []wire.NamespaceRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	remaining := make([]wire.NamespaceRequest, 0, len(c.requests))
+	for _, r := range c.requests {
+		if r, exists := withoutMessages(r, requests); exists {
+			remaining = append(remaining, r)
+		}
+	}
+
+	c.setRequests(remaining)
+	c.pruneLatest()
+}
+
+// pruneLatest forgets revisions no longer requested by the client and the subscribers. They are removed
+// from the store too, so revisions sent by servers once messages are requested again are delivered.
+func (c *clientConns) pruneLatest() {
+	for revDesc := range c.latest {
+		if requested(c.reqs, revDesc.MessageDescriptor) {
+			continue
+		}
+		if !slices.ContainsFunc(c.subscribers, func(s *subscriber) bool {
+			return requested(s.Reqs, revDesc.MessageDescriptor)
+		}) {
+			delete(c.latest, revDesc)
+			c.receivedMsgs.Remove(revDesc)
+		}
+	}
 }
 
-func (c *clientConns) Deliver(ctx context.Context, header *wire.Header, msg any) error {
+func (c *clientConns) registerMarshaller(
+	requests []wire.NamespaceRequest,
+	marshaller proton.Marshaller,
+	fingerprints map[wire.MessageID]uint64,
+) {
+	for _, r := range requests {
+		if _, exists := c.marshallers[r.Namespace]; !exists {
+			c.marshallers[r.Namespace] = marshaller
+			c.fingerprints[r.Namespace] = fingerprints
+		}
+	}
+}
+
+func (c *clientConns) setRequests(requests []wire.NamespaceRequest) {
+	c.requests = requests
+	c.reqs, _ = requestedDescriptors(requests)
+	c.sendRequests()
+}
+
+// sendRequests sends requests of the client and all the subscribers to the connected servers.
+func (c *clientConns) sendRequests() {
+	requests := slices.Clone(c.requests)
+	for _, s := range c.subscribers {
+		requests = append(requests, s.Requests...)
+	}
+	c.sentRequests = requests
+	c.requestsVersion++
+
+	for queue := range c.conns {
+		queue.Push(msgToSend{
+			Subscription: &wire.Subscription{Requests: requests},
+		})
+	}
+}
+
+// Decoder returns marshaller decoding messages of the namespace and fingerprints of the message types
+// known to it. Messages of namespaces not requested explicitly are decoded by the marshaller requested
+// for AnyNamespace. Nil fingerprints mean that marshaller doesn't list its messages, so any ID is decoded.
+func (c *clientConns) Decoder(namespace wire.Namespace) (proton.Marshaller, map[wire.MessageID]uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, exists := c.marshallers[namespace]; !exists {
+		namespace = wire.AnyNamespace
+	}
+	m, exists := c.marshallers[namespace]
+	return m, c.fingerprints[namespace], exists
+}
+
+// Deliver stores the revision and queues the message for the receiver and the subscribers which requested it.
+// Nothing waits for them. False is returned if revision has been already delivered. Revisions applied earlier
+// are replayed to the subscribers registered later.
+func (c *clientConns) Deliver(header *wire.Header, msg any) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// Content is not kept in the store, it stores only the headers to drop stale revisions.
+	if !c.receivedMsgs.Apply(revision{Header: header}) {
+		c.metrics.DedupDropped()
+		return false
+	}
+
+	// Decoded message is kept to be replayed to new subscribers and requests.
+	d := delivery{
+		Header:  header,
+		Message: msg,
+	}
 	revDesc := revDescriptor{
 		MessageDescriptor: header.Revision.Message,
 		Sender:            header.Sender,
 	}
+	c.latest[revDesc] = d
 
-	if existingRevision, exists := c.receivedMsgs[revDesc]; exists &&
-		existingRevision >= header.Revision.Index {
-		return nil
+	if requested(c.reqs, header.Revision.Message) {
+		c.receiver.Push(revDesc, c.message(d))
+	}
+	for _, s := range c.subscribers {
+		if requested(s.Reqs, header.Revision.Message) {
+			s.Deliver(revDesc, c.message(d))
+		}
 	}
 
-	c.receivedMsgs[revDesc] = header.Revision.Index
+	return true
+}
 
-	select {
-	case <-ctx.Done():
-		return errors.WithStack(ctx.Err())
-	case c.recvCh <- msg:
+// message returns the message passed to the receiver.
+func (c *clientConns) message(d delivery) any {
+	if !c.envelopes {
+		return d.Message
 	}
+	return &Envelope{
+		Header:  *d.Header,
+		Message: d.Message,
+	}
+}
 
-	return nil
+// Close closes channels of the receiver and the subscribers.
+func (c *clientConns) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stopped = true
+	c.receiver.Close()
+	for _, s := range c.subscribers {
+		s.Close()
+	}
+	c.subscribers = nil
+}
+
+// Flush waits until the messages delivered so far are passed to the receiver.
+func (c *clientConns) Flush(ctx context.Context) error {
+	return c.receiver.Flush(ctx)
+}
+
+// RevisionCounts returns number of received revisions per namespace.
+func (c *clientConns) RevisionCounts() map[wire.Namespace]int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.receivedMsgs.Counts()
 }
 
 // ClientConfig is the config of client.
 type ClientConfig struct {
+	// Servers are the seed addresses of servers. Other servers are discovered from the cluster, then seed failing
+	// repeatedly is dropped. Besides host:port, addresses like tcp://host:port, unix:///path/to/socket
+	// and ws://host:port are accepted.
 	Servers        []string
 	MaxMessageSize uint64
 	Requests       []RequestConfig
+
+	// DisableDiscovery causes client to connect only to the servers listed in Servers. They are never dropped.
+	DisableDiscovery bool
+
+	// Connections is the number of servers client keeps connections to. If zero, client connects to all of them.
+	Connections int
+
+	// Selection defines how servers are chosen if client connects to a subset of them.
+	Selection ServerSelection
+
+	// Reconnect defines delays between attempts to connect to servers.
+	Reconnect ReconnectPolicy
+
+	// MetricsAddress is the address of the HTTP listener serving metrics in Prometheus format.
+	// If empty, metrics are not exposed.
+	MetricsAddress string
+
+	// Tracer records spans of sent and received revisions. If set, every sent revision is traced.
+	Tracer SpanRecorder
+
+	// Envelopes causes received messages to be delivered as *Envelope, exposing their headers.
+	Envelopes bool
+
+	// Dialer connects to servers. If nil, Dial is used.
+	Dialer Dialer
 }
 
 // RequestConfig defines message types to receive on client.
 type RequestConfig struct {
 	Marshaller proton.Marshaller
-	Messages   []any
+
+	// Messages are the message types to receive. If empty, all messages of the namespace are received,
+	// including the types unknown to the marshaller, which are skipped.
+	Messages []any
+
+	// Namespace is the namespace of the messages. If empty, the namespace of the marshaller is used.
+	// If it is AnyNamespace, messages of all namespaces are received, those not requested by other configs
+	// are decoded by the marshaller.
+	Namespace wire.Namespace
+
+	// Aliases are other names of the namespace. Messages sent to them are received too, so during migration
+	// clients receive messages sent under both the old and the new name. Send with the old name until all
+	// the clients define the new one as an alias, then switch.
+	Aliases []wire.Namespace
 }
 
 // Client receives and sends requested messages from/to servers.
 type Client struct {
-	config      ClientConfig
-	requests    []wire.NamespaceRequest
-	marshallers map[wire.Namespace]proton.Marshaller
-	conns       *clientConns
+	config    ClientConfig
+	conns     *clientConns
+	selector  *serverSelector
+	links     *reconnector
+	events    *clientEvents
+	metrics   *metrics
+	serversCh chan struct{}
+
+	mu           sync.Mutex
+	advertised   []string
+	servers      map[wire.PeerID]struct{}
+	seedFailures map[string]int
 }
 
-// NewClient creates new client.
+// NewClient creates new client. Receiver not keeping up with the returned channel receives only the latest
+// revisions of the messages.
 func NewClient(config ClientConfig) (*Client, <-chan any, error) {
 	if len(config.Servers) == 0 {
 		return nil, nil, errors.New("no servers specified")
@@ -163,88 +467,417 @@ func NewClient(config ClientConfig) (*Client, <-chan any, error) {
 		return nil, nil, err
 	}
 
-	marshallers := map[wire.Namespace]proton.Marshaller{}
-	requests := make([]wire.NamespaceRequest, 0, len(config.Requests))
+	recvCh := make(chan any, 10)
+	m := newMetrics()
+	conns := newClientConns(clientID, recvCh, config.Envelopes, config.MaxMessageSize, m)
+	m.revisions = conns.RevisionCounts
+
 	for _, r := range config.Requests {
-		namespace := marshallerToNamespace(r.Marshaller)
-		if _, exists := marshallers[namespace]; !exists {
-			marshallers[namespace] = r.Marshaller
+		requests, fps, err := namespaceRequests(r)
+		if err != nil {
+			return nil, nil, err
 		}
+		conns.Subscribe(requests, r.Marshaller, fps)
+	}
 
-		req := wire.NamespaceRequest{
-			Namespace:  namespace,
-			MessageIDs: make([]wire.MessageID, 0, len(r.Messages)),
-		}
-		for _, m := range r.Messages {
-			msgID, err := r.Marshaller.ID(m)
-			if err != nil {
-				return nil, nil, err
-			}
-			req.MessageIDs = append(req.MessageIDs, wire.MessageID(msgID))
+	return &Client{
+		config:       config,
+		conns:        conns,
+		selector:     newServerSelector(clientID, config.Connections, config.Selection),
+		links:        newReconnector(config.Reconnect, m),
+		events:       newClientEvents(),
+		metrics:      m,
+		serversCh:    make(chan struct{}, 1),
+		servers:      map[wire.PeerID]struct{}{},
+		seedFailures: map[string]int{},
+	}, recvCh, nil
+}
+
+// namespaceRequests returns requests sent to servers for the config, one for the namespace and each alias,
+// together with the fingerprints of the message types known to the marshaller.
+func namespaceRequests(r RequestConfig) ([]wire.NamespaceRequest, map[wire.MessageID]uint64, error) {
+	namespace := r.Namespace
+	if namespace == "" {
+		namespace = marshallerToNamespace(r.Marshaller)
+	}
+
+	msgIDs := make([]wire.MessageID, 0, len(r.Messages))
+	for _, m := range r.Messages {
+		msgID, err := r.Marshaller.ID(m)
+		if err != nil {
+			return nil, nil, err
 		}
+		msgIDs = append(msgIDs, wire.MessageID(msgID))
+	}
 
-		requests = append(requests, req)
+	fps, err := messageFingerprints(r.Marshaller)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	recvCh := make(chan any, 10)
-	return &Client{
-		config:      config,
-		requests:    requests,
-		marshallers: marshallers,
-		conns:       newClientConns(clientID, recvCh),
-	}, recvCh, nil
+	namespaces := append([]wire.Namespace{namespace}, r.Aliases...)
+	requests := make([]wire.NamespaceRequest, 0, len(namespaces))
+	for _, ns := range namespaces {
+		requests = append(requests, wire.NamespaceRequest{
+			Namespace:  ns,
+			MessageIDs: msgIDs,
+		})
+	}
+	return requests, fps, nil
+}
+
+// withoutMessages returns request without the messages of the unsubscribed ones. False is returned if nothing
+// is left. Wildcard request is removed only by unsubscribing the whole namespace.
+func withoutMessages(r wire.NamespaceRequest, unsubscribed []wire.NamespaceRequest) (wire.NamespaceRequest, bool) {
+	for _, u := range unsubscribed {
+		switch {
+		case u.Namespace != r.Namespace:
+		case len(u.MessageIDs) == 0:
+			return r, false
+		case len(r.MessageIDs) > 0:
+			r.MessageIDs = slices.DeleteFunc(slices.Clone(r.MessageIDs), func(id wire.MessageID) bool {
+				return slices.Contains(u.MessageIDs, id)
+			})
+			if len(r.MessageIDs) == 0 {
+				return r, false
+			}
+		}
+	}
+	return r, true
 }
 
 // Run runs client.
 func (client *Client) Run(ctx context.Context) error {
-	defer close(client.conns.recvCh)
+	defer client.conns.Close()
+	defer close(client.events.ch)
 
-	connConfig := resonance.Config{
-		MaxMessageSize: client.config.MaxMessageSize,
+	connConfig := transportConfig{
+		Connection: resonance.Config{
+			MaxMessageSize: client.config.MaxMessageSize,
+		},
+		Metrics: client.metrics,
+		Dialer:  client.config.Dialer,
 	}
 
 	return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
-		for _, server := range client.config.Servers {
-			spawn("conn", parallel.Fail, func(ctx context.Context) error {
-				log := logger.Get(ctx)
-
-				for {
-					err := resonance.RunClient(ctx, server, connConfig,
-						func(ctx context.Context, c *resonance.Connection) error {
-							return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
-								return client.runConn(ctx, c)
-							})
-						})
-
-					if ctx.Err() != nil {
-						return errors.WithStack(ctx.Err())
+		if client.config.MetricsAddress != "" {
+			spawn("metrics", parallel.Fail, func(ctx context.Context) error {
+				return serveHTTP(ctx, client.config.MetricsAddress, client.MetricsHandler())
+			})
+		}
+		spawn("servers", parallel.Fail, func(ctx context.Context) error {
+			rebalanceTicker := time.NewTicker(rebalanceInterval)
+			defer rebalanceTicker.Stop()
+
+			running := map[string]context.CancelFunc{}
+			for {
+				addresses := client.addresses()
+				if err := client.probe(ctx, client.selector.Unprobed(addresses, time.Now()), connConfig); err != nil {
+					return err
+				}
+
+				addresses = client.selector.Select(addresses, time.Now())
+				for address := range addresses {
+					if _, exists := running[address]; exists {
+						continue
 					}
 
-					log.Error("Wave connection failed", zap.String("server", server), zap.Error(err))
-					select {
-					case <-ctx.Done():
-						return errors.WithStack(ctx.Err())
-					case <-time.After(time.Second):
+					connCtx, cancel := context.WithCancel(ctx)
+					running[address] = cancel
+					spawn("conn", parallel.Continue, func(ctx context.Context) error {
+						err := client.runServer(connCtx, address, connConfig)
+						if ctx.Err() == nil && connCtx.Err() != nil {
+							// Server is no longer a member of the cluster or other one has been chosen.
+							return nil
+						}
+						return err
+					})
+				}
+				for address, cancel := range running {
+					if _, exists := addresses[address]; !exists {
+						cancel()
+						delete(running, address)
 					}
 				}
-			})
+
+				select {
+				case <-ctx.Done():
+					return errors.WithStack(ctx.Err())
+				case <-client.serversCh:
+				case <-rebalanceTicker.C:
+					client.selector.ResetLatencies()
+				}
+			}
+		})
+
+		return nil
+	})
+}
+
+// ID returns ID of the client.
+func (client *Client) ID() wire.PeerID {
+	return client.conns.clientID
+}
+
+// Links returns states of the connections to servers.
+func (client *Client) Links() []LinkState {
+	return client.links.Links()
+}
+
+// Events returns channel delivering changes of connection states. Channel is closed when client stops.
+// Events are dropped if they are not read quickly enough, Status is always up to date.
+func (client *Client) Events() <-chan Event {
+	return client.events.ch
+}
+
+// Status returns the state of connections to servers.
+func (client *Client) Status() Status {
+	return client.events.Status()
+}
+
+// MetricsHandler returns HTTP handler serving metrics in Prometheus text format.
+func (client *Client) MetricsHandler() http.Handler {
+	return client.metrics.Handler()
+}
+
+// Send sends new message to servers. Use NamespacedMarshaller to send it under explicit namespace.
+// It doesn't wait for the connections, message is queued and only its latest revision is sent
+// to the server not keeping up. Encoding errors are returned immediately.
+func (client *Client) Send(ctx context.Context, message any, marhsaller proton.Marshaller) error {
+	if client.config.Tracer == nil {
+		if err := ctx.Err(); err != nil {
+			return errors.WithStack(err)
+		}
+		_, err := client.conns.Broadcast(message, marhsaller, wire.TraceContext{})
+		return err
+	}
+	return client.SendTraced(ctx, message, marhsaller, wire.TraceContext{})
+}
+
+// SendTraced sends new message to servers as the part of the trace. If parent is zero, new trace is started.
+func (client *Client) SendTraced(
+	ctx context.Context,
+	message any,
+	marhsaller proton.Marshaller,
+	parent wire.TraceContext,
+) error {
+	if err := ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	trace, err := childTrace(parent)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	header, err := client.conns.Broadcast(message, marhsaller, trace)
+	if err != nil {
+		return err
+	}
+
+	if client.config.Tracer != nil {
+		client.config.Tracer.RecordSpan(Span{
+			Name:     SpanSend,
+			Trace:    trace,
+			ParentID: parent.SpanID,
+			PeerID:   client.conns.clientID,
+			Sender:   header.Sender,
+			Revision: header.Revision,
+			Start:    start,
+			End:      time.Now(),
+		})
+	}
+	return nil
+}
+
+// Subscribe starts receiving messages defined by the config. Current values of the newly requested messages
+// known to the client are delivered first, the other ones are sent by connected servers.
+func (client *Client) Subscribe(config RequestConfig) error {
+	requests, fps, err := namespaceRequests(config)
+	if err != nil {
+		return err
+	}
+	client.conns.Subscribe(requests, config.Marshaller, fps)
+	return nil
+}
+
+// Unsubscribe stops receiving messages defined by the config. If it lists no messages, all the requests
+// of the namespace are removed. Messages already on the way might still be received.
+func (client *Client) Unsubscribe(config RequestConfig) error {
+	requests, _, err := namespaceRequests(config)
+	if err != nil {
+		return err
+	}
+	client.conns.Unsubscribe(requests)
+	return nil
+}
+
+// addresses returns seed addresses together with the ones advertised by the cluster.
+func (client *Client) addresses() map[string]struct{} {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	addresses := map[string]struct{}{}
+	for _, address := range client.advertised {
+		addresses[address] = struct{}{}
+	}
+	for _, address := range client.config.Servers {
+		// Seed might point to the server which has been removed from the cluster, so once other servers
+		// are known, it is dropped if it keeps failing.
+		if len(client.advertised) > 0 && client.seedFailures[address] >= seedFailureLimit {
+			continue
 		}
+		addresses[address] = struct{}{}
+	}
+	return addresses
+}
+
+// seedFailed records consecutive failure of the seed.
+func (client *Client) seedFailed(address string) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if slices.Contains(client.config.Servers, address) {
+		client.seedFailures[address]++
+	}
+}
+
+func (client *Client) advertise(members []wire.Member) {
+	addresses := make([]string, 0, len(members))
+	for _, m := range members {
+		addresses = append(addresses, m.Address)
+		client.selector.Identified(m.Address, m.PeerID)
+	}
+	if client.config.DisableDiscovery {
+		return
+	}
+	sort.Strings(addresses)
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if slices.Equal(client.advertised, addresses) {
+		return
+	}
+	client.advertised = addresses
+	client.notifyServers()
+}
+
+func (client *Client) notifyServers() {
+	select {
+	case client.serversCh <- struct{}{}:
+	default:
+	}
+}
 
+// probe measures latency of the servers by exchanging hello messages.
+func (client *Client) probe(ctx context.Context, addresses []string, connConfig transportConfig) error {
+	if len(addresses) == 0 {
+		return nil
+	}
+
+	return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
+		for _, address := range addresses {
+			spawn("probe", parallel.Continue, func(ctx context.Context) error {
+				probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+				defer cancel()
+
+				start := time.Now()
+				err := dial(probeCtx, address, connConfig,
+					func(ctx context.Context, c *connection) error {
+						m := wire.NewBoundedMarshaller()
+						if _, err := c.SendProton(&wire.Hello{
+							PeerID: client.conns.clientID,
+							Probe:  true,
+						}, m); err != nil {
+							return err
+						}
+						msg, _, err := c.ReceiveProton(m)
+						if err != nil {
+							return err
+						}
+						if _, ok := msg.(*wire.Hello); !ok {
+							return errors.New("hello message expected")
+						}
+						return nil
+					})
+
+				switch {
+				case ctx.Err() != nil:
+					return errors.WithStack(ctx.Err())
+				case err != nil:
+					client.selector.Failed(address, time.Now())
+					client.selector.Probed(address, probeTimeout)
+				default:
+					client.selector.Probed(address, time.Since(start))
+				}
+				return nil
+			})
+		}
 		return nil
 	})
 }
 
-// Send sends new message to servers.
-func (client *Client) Send(message any, marhsaller proton.Marshaller) error {
-	return client.conns.Broadcast(message, marhsaller)
+func (client *Client) runServer(ctx context.Context, address string, connConfig transportConfig) error {
+	defer client.links.Remove(address)
+	defer client.events.Remove(address)
+
+	log := logger.Get(ctx)
+
+	for {
+		client.links.Attempt(address)
+
+		var connected bool
+		err := dial(ctx, address, connConfig,
+			func(ctx context.Context, c *connection) error {
+				connected = true
+				client.events.Emit(Event{
+					Type:    EventConnected,
+					Address: address,
+					Time:    time.Now(),
+				})
+
+				return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
+					return client.runConn(ctx, c, address)
+				})
+			})
+
+		if connected {
+			client.events.Emit(Event{
+				Type:    EventDisconnected,
+				Address: address,
+				Time:    time.Now(),
+				Err:     err,
+			})
+		}
+
+		if ctx.Err() != nil {
+			return errors.WithStack(ctx.Err())
+		}
+
+		if !errors.Is(err, errDuplicateConn) {
+			log.Error("Wave connection failed", zap.String("server", address), zap.Error(err))
+
+			// Other server might be chosen in place of the failed one.
+			client.selector.Failed(address, time.Now())
+			client.seedFailed(address)
+		}
+		client.notifyServers()
+
+		if err := client.links.Wait(ctx, address, err); err != nil {
+			return err
+		}
+	}
 }
 
-func (client *Client) runConn(ctx context.Context, c *resonance.Connection) error {
-	m := wire.NewMarshaller()
+func (client *Client) runConn(ctx context.Context, c *connection, address string) error {
+	m := wire.NewBoundedMarshaller()
 
+	requests, requestsVersion := client.conns.Requests()
 	if _, err := c.SendProton(&wire.Hello{
 		PeerID:   client.conns.clientID,
-		Requests: client.requests,
+		Requests: requests,
 	}, m); err != nil {
 		return err
 	}
@@ -254,16 +887,52 @@ func (client *Client) runConn(ctx context.Context, c *resonance.Connection) erro
 		return err
 	}
 
-	_, ok := msg.(*wire.Hello)
+	helloMsg, ok := msg.(*wire.Hello)
 	if !ok {
 		return errors.New("hello message expected")
 	}
 
-	sendCh := client.conns.Add()
+	// The same server might be reachable under many addresses, only one connection to it is maintained.
+	client.mu.Lock()
+	_, exists := client.servers[helloMsg.PeerID]
+	if !exists {
+		client.servers[helloMsg.PeerID] = struct{}{}
+		delete(client.seedFailures, address)
+	}
+	client.mu.Unlock()
+
+	if exists {
+		// Address is the alias of the server connected through other address, so it is no longer selected.
+		client.selector.Identified(address, helloMsg.PeerID)
+		return errors.WithStack(errDuplicateConn)
+	}
+	defer func() {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+
+		delete(client.servers, helloMsg.PeerID)
+	}()
+
+	client.selector.Connected(address, helloMsg.PeerID)
+	defer client.selector.Disconnected(address)
+	client.links.Connected(address)
+	defer client.metrics.Connected(RoleServer)()
+
+	client.events.Emit(Event{
+		Type:     EventHandshakeCompleted,
+		Address:  address,
+		Time:     time.Now(),
+		ServerID: helloMsg.PeerID,
+	})
+
+	session := newClientSession(client.conns, requestsVersion)
+
+	// Server sends one digest and one set of entries, so the channel never blocks.
+	syncCh := make(chan any, 2)
 
 	return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
 		spawn("receiver", parallel.Fail, func(ctx context.Context) error {
-			defer client.conns.Remove(sendCh)
+			defer session.Close()
 
 			for {
 				msg, _, err := c.ReceiveProton(m)
@@ -271,43 +940,131 @@ func (client *Client) runConn(ctx context.Context, c *resonance.Connection) erro
 					return err
 				}
 
-				headerMsg, ok := msg.(*wire.Header)
-				if !ok {
-					return errors.New("header message expected")
-				}
+				switch msg := msg.(type) {
+				case *wire.Header:
+					start := time.Now()
 
-				msgM, exists := client.marshallers[headerMsg.Revision.Message.Namespace]
-				if !exists {
-					return errors.Errorf("no marshaller for namespace %q", headerMsg.Revision.Message.Namespace)
-				}
+					msgDesc := msg.Revision.Message
+					msgM, fps, exists := client.conns.Decoder(msgDesc.Namespace)
+					if !exists {
+						return errors.Errorf("no marshaller for namespace %q", msgDesc.Namespace)
+					}
 
-				msg, _, err = c.ReceiveProton(msgM)
-				if err != nil {
-					return err
-				}
+					// Wildcard requests receive message types added to the namespace later, they are skipped.
+					fp, known := fps[msgDesc.MessageID]
+					if fps != nil && !known {
+						if _, _, err := c.ReceiveRawBytes(); err != nil {
+							return err
+						}
+						logger.Get(ctx).Debug("Wave message of unknown type skipped",
+							zap.String("namespace", string(msgDesc.Namespace)),
+							zap.Uint64("messageID", uint64(msgDesc.MessageID)),
+							zap.Stringer("sender", msg.Sender))
+						continue
+					}
 
-				if err := client.conns.Deliver(ctx, headerMsg, msg); err != nil {
-					return err
+					// Content defined differently on the sender would be decoded into garbage, so it is skipped.
+					// Zero fingerprint on either side means layout is not known, so it is not verified.
+					if fp != 0 && msgDesc.Fingerprint != 0 && fp != msgDesc.Fingerprint {
+						if _, _, err := c.ReceiveRawBytes(); err != nil {
+							return err
+						}
+						client.metrics.SchemaMismatched()
+						logger.Get(ctx).Warn("Wave message schema mismatch",
+							zap.String("namespace", string(msgDesc.Namespace)),
+							zap.Uint64("messageID", uint64(msgDesc.MessageID)),
+							zap.Stringer("sender", msg.Sender),
+							zap.Uint64("fingerprint", msgDesc.Fingerprint),
+							zap.Uint64("expectedFingerprint", fp))
+						continue
+					}
+
+					content, _, err := c.ReceiveProton(msgM)
+					if err != nil {
+						return err
+					}
+
+					if client.conns.Deliver(msg, content) {
+						recordSpan(client.config.Tracer, SpanClientReceive, client.conns.clientID, msg, start)
+					}
+				case *wire.Digest, *wire.DigestEntry, *wire.DigestEnd:
+					item, err := session.Receive(msg)
+					if err != nil {
+						return err
+					}
+					if item != nil {
+						syncCh <- item
+					}
+				case *wire.SyncEnd:
+					if _, err := session.Receive(msg); err != nil {
+						return err
+					}
+
+					// Revisions are delivered in order, so all the missing ones have been received already.
+					// They are passed to the receiver before the event is emitted.
+					if err := client.conns.Flush(ctx); err != nil {
+						return err
+					}
+					client.events.Emit(Event{
+						Type:     EventSyncCompleted,
+						Address:  address,
+						Time:     time.Now(),
+						ServerID: helloMsg.PeerID,
+					})
+				case *wire.Members:
+					client.advertise(msg.Members)
+				default:
+					return errors.New("header message expected")
 				}
 			}
 		})
 		spawn("sender", parallel.Fail, func(ctx context.Context) error {
-			defer func() {
-				for range sendCh {
-				}
-			}()
 			defer c.Close()
 
-			for toSend := range sendCh {
-				if _, err := c.SendProton(toSend.Header, m); err != nil {
-					return err
-				}
-				if _, err := c.SendProton(toSend.Message, toSend.Marshaller); err != nil {
-					return err
+			send := func(items []any) error {
+				for _, item := range items {
+					toSend, ok := item.(msgToSend)
+					switch {
+					case !ok:
+						if _, err := c.SendProton(item, m); err != nil {
+							return err
+						}
+					case toSend.Subscription != nil:
+						if _, err := c.SendProton(toSend.Subscription, m); err != nil {
+							return err
+						}
+					default:
+						if _, err := c.SendProton(toSend.Header, m); err != nil {
+							return err
+						}
+						if _, err := c.SendRawBytes(toSend.Content); err != nil {
+							return err
+						}
+					}
 				}
+				return nil
 			}
 
-			return nil
+			if _, err := c.SendProton(session.Digest(), m); err != nil {
+				return err
+			}
+
+			for {
+				select {
+				case <-session.Ready():
+					items, ok := session.Pop()
+					if !ok {
+						return nil
+					}
+					if err := send(items); err != nil {
+						return err
+					}
+				case item := <-syncCh:
+					if err := send(session.Respond(item)); err != nil {
+						return err
+					}
+				}
+			}
 		})
 
 		return nil
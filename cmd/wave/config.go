@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+
+	"github.com/outofforest/logger"
+)
+
+const (
+	envPrefix = "WAVE_"
+
+	defaultListenAddress  = ":8080"
+	defaultMaxMessageSize = 1024 * 1024
+	defaultStatusInterval = 30 * time.Second
+)
+
+// Config is the config of the wave server.
+type Config struct {
+	ListenAddresses  []string      `yaml:"listenAddresses"`
+	AdvertiseAddress string        `yaml:"advertiseAddress"`
+	Servers          []string      `yaml:"servers"`
+	MaxMessageSize   uint64        `yaml:"maxMessageSize"`
+	AdminAddress     string        `yaml:"adminAddress"`
+	MetricsAddress   string        `yaml:"metricsAddress"`
+	WebSocketAddress string        `yaml:"webSocketAddress"`
+	GatewayAddress   string        `yaml:"gatewayAddress"`
+	StatusInterval   time.Duration `yaml:"statusInterval"`
+	Log              LogConfig     `yaml:"log"`
+}
+
+// LogConfig is the config of logging.
+type LogConfig struct {
+	Format  logger.Format `yaml:"format"`
+	Verbose bool          `yaml:"verbose"`
+}
+
+// readConfig reads config from the file, environment and flags. Values set by flags override the ones
+// from environment, which override the ones from the file. Environment variable is named after the flag,
+// like WAVE_MAX_MESSAGE_SIZE. JSON is a subset of YAML, so both formats are accepted.
+func readConfig(args []string) (Config, error) {
+	config := Config{
+		ListenAddresses: []string{defaultListenAddress},
+		MaxMessageSize:  defaultMaxMessageSize,
+		StatusInterval:  defaultStatusInterval,
+		Log: LogConfig{
+			Format:  logger.DefaultConfig.Format,
+			Verbose: logger.DefaultConfig.Verbose,
+		},
+	}
+
+	var configFile, logFormat string
+	var flagConfig Config
+
+	flags := pflag.NewFlagSet("wave", pflag.ContinueOnError)
+	flags.StringVar(&configFile, "config", "", "YAML or JSON file to read configuration from")
+	flags.StringSliceVar(&flagConfig.ListenAddresses, "listen", config.ListenAddresses,
+		"Addresses to accept connections on, like host:port, tcp://host:port or unix:///path/to/socket")
+	flags.StringVar(&flagConfig.AdvertiseAddress, "advertise-address", "",
+		"Address other servers use to connect to this one")
+	flags.StringSliceVar(&flagConfig.Servers, "servers", nil, "Addresses of servers used to join the cluster")
+	flags.Uint64Var(&flagConfig.MaxMessageSize, "max-message-size", config.MaxMessageSize,
+		"Maximum size of the message")
+	flags.StringVar(&flagConfig.AdminAddress, "admin-address", "", "Address of the admin API")
+	flags.StringVar(&flagConfig.MetricsAddress, "metrics-address", "", "Address of the metrics endpoint")
+	flags.StringVar(&flagConfig.WebSocketAddress, "websocket-address", "",
+		"Address to accept WebSocket connections on")
+	flags.StringVar(&flagConfig.GatewayAddress, "gateway-address", "",
+		"Address of the HTTP gateway publishing and streaming messages as JSON")
+	flags.DurationVar(&flagConfig.StatusInterval, "status-interval", config.StatusInterval,
+		"Interval between status reports")
+	flags.StringVar(&logFormat, "log-format", string(config.Log.Format), "Format of log output: console | json | yaml")
+	flags.BoolVarP(&flagConfig.Log.Verbose, "verbose", "v", config.Log.Verbose, "Turns on verbose logging")
+
+	if err := flags.Parse(args); err != nil {
+		return Config{}, errors.WithStack(err)
+	}
+
+	// Environment is applied to the flags not set on the command line, so they are treated as set.
+	var envErr error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if envErr != nil || f.Changed {
+			return
+		}
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, exists := os.LookupEnv(name); exists {
+			if err := flags.Set(f.Name, value); err != nil {
+				envErr = errors.Wrapf(err, "parsing environment variable %s", name)
+			}
+		}
+	})
+	if envErr != nil {
+		return Config{}, envErr
+	}
+	flagConfig.Log.Format = logger.Format(logFormat)
+
+	if configFile != "" {
+		content, err := os.ReadFile(configFile)
+		if err != nil {
+			return Config{}, errors.WithStack(err)
+		}
+		if err := yaml.Unmarshal(content, &config); err != nil {
+			return Config{}, errors.Wrapf(err, "parsing config file %s", configFile)
+		}
+	}
+
+	for flag, apply := range map[string]func(){
+		"listen":            func() { config.ListenAddresses = flagConfig.ListenAddresses },
+		"advertise-address": func() { config.AdvertiseAddress = flagConfig.AdvertiseAddress },
+		"servers":           func() { config.Servers = flagConfig.Servers },
+		"max-message-size":  func() { config.MaxMessageSize = flagConfig.MaxMessageSize },
+		"admin-address":     func() { config.AdminAddress = flagConfig.AdminAddress },
+		"metrics-address":   func() { config.MetricsAddress = flagConfig.MetricsAddress },
+		"websocket-address": func() { config.WebSocketAddress = flagConfig.WebSocketAddress },
+		"gateway-address":   func() { config.GatewayAddress = flagConfig.GatewayAddress },
+		"status-interval":   func() { config.StatusInterval = flagConfig.StatusInterval },
+		"log-format":        func() { config.Log.Format = flagConfig.Log.Format },
+		"verbose":           func() { config.Log.Verbose = flagConfig.Log.Verbose },
+	} {
+		if flags.Changed(flag) {
+			apply()
+		}
+	}
+
+	switch config.Log.Format {
+	case logger.FormatConsole, logger.FormatJSON, logger.FormatYAML:
+	default:
+		return Config{}, errors.Errorf("invalid log format %q", config.Log.Format)
+	}
+	if config.MaxMessageSize == 0 {
+		return Config{}, errors.New("max message size must be positive")
+	}
+	if config.StatusInterval <= 0 {
+		return Config{}, errors.New("status interval must be positive")
+	}
+
+	return config, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/logger"
+)
+
+func TestReadConfigDefaults(t *testing.T) {
+	requireT := require.New(t)
+
+	config, err := readConfig(nil)
+	requireT.NoError(err)
+	requireT.Equal(Config{
+		ListenAddresses: []string{defaultListenAddress},
+		MaxMessageSize:  defaultMaxMessageSize,
+		StatusInterval:  defaultStatusInterval,
+		Log: LogConfig{
+			Format:  logger.DefaultConfig.Format,
+			Verbose: logger.DefaultConfig.Verbose,
+		},
+	}, config)
+}
+
+func TestReadConfigPrecedence(t *testing.T) {
+	requireT := require.New(t)
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	requireT.NoError(os.WriteFile(configFile, []byte(`
+listenAddresses: ["file:1"]
+servers: ["file:2"]
+maxMessageSize: 100
+adminAddress: file:3
+gatewayAddress: file:4
+statusInterval: 1m
+log:
+  format: json
+`), 0o600))
+
+	t.Setenv("WAVE_CONFIG", configFile)
+	t.Setenv("WAVE_SERVERS", "env:1,env:2")
+	t.Setenv("WAVE_MAX_MESSAGE_SIZE", "200")
+	t.Setenv("WAVE_ADMIN_ADDRESS", "env:3")
+	t.Setenv("WAVE_GATEWAY_ADDRESS", "env:4")
+
+	config, err := readConfig([]string{"--max-message-size", "300", "--gateway-address", "flag:1"})
+	requireT.NoError(err)
+	requireT.Equal(Config{
+		// File overrides default.
+		ListenAddresses: []string{"file:1"},
+		// Environment overrides file.
+		Servers:      []string{"env:1", "env:2"},
+		AdminAddress: "env:3",
+		// Flag overrides environment.
+		MaxMessageSize: 300,
+		GatewayAddress: "flag:1",
+		StatusInterval: time.Minute,
+		Log: LogConfig{
+			Format:  logger.FormatJSON,
+			Verbose: logger.DefaultConfig.Verbose,
+		},
+	}, config)
+}
+
+func TestReadConfigInvalidEnvironment(t *testing.T) {
+	t.Setenv("WAVE_MAX_MESSAGE_SIZE", "invalid")
+
+	_, err := readConfig(nil)
+	require.ErrorContains(t, err, "WAVE_MAX_MESSAGE_SIZE")
+}
+
+func TestReadConfigHelp(t *testing.T) {
+	_, err := readConfig([]string{"--help"})
+	require.ErrorIs(t, err, pflag.ErrHelp)
+}
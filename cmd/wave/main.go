@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	"github.com/outofforest/logger"
+	"github.com/outofforest/parallel"
+	"github.com/outofforest/run"
+	"github.com/outofforest/wave"
+)
+
+func main() {
+	config, err := readConfig(os.Args[1:])
+	if err != nil {
+		// Usage has been printed already.
+		if errors.Is(err, pflag.ErrHelp) {
+			os.Exit(0)
+		}
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	log := logger.New(logger.Config{
+		Format:  config.Log.Format,
+		Verbose: config.Log.Verbose,
+	})
+
+	// Environment cancels the context on SIGINT and SIGTERM, so server is shut down gracefully.
+	run.New().Run(logger.WithLogger(context.Background(), log), "wave", func(ctx context.Context) error {
+		return runServer(ctx, config)
+	})
+}
+
+func runServer(ctx context.Context, config Config) error {
+	log := logger.Get(ctx)
+
+	if len(config.ListenAddresses) == 0 {
+		return errors.New("no listen addresses specified")
+	}
+
+	listeners := make([]net.Listener, 0, len(config.ListenAddresses))
+	defer func() {
+		for _, ls := range listeners {
+			_ = ls.Close()
+		}
+	}()
+	for _, address := range config.ListenAddresses {
+		ls, err := wave.Listen(address)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, ls)
+	}
+
+	server, err := wave.NewServer(wave.ServerConfig{
+		Servers:          config.Servers,
+		MaxMessageSize:   config.MaxMessageSize,
+		AdvertiseAddress: config.AdvertiseAddress,
+		AdminAddress:     config.AdminAddress,
+		MetricsAddress:   config.MetricsAddress,
+		WebSocketAddress: config.WebSocketAddress,
+	})
+	if err != nil {
+		return err
+	}
+
+	var gateway *wave.Gateway
+	if config.GatewayAddress != "" {
+		// Marshallers are not compiled in, so content of messages is exposed as is.
+		gateway, err = wave.NewGateway(wave.GatewayConfig{
+			Servers:        config.ListenAddresses[:1],
+			MaxMessageSize: config.MaxMessageSize,
+			Address:        config.GatewayAddress,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info("Wave server started",
+		zap.Stringer("id", server.ID()),
+		zap.Strings("listen", config.ListenAddresses),
+		zap.Strings("servers", config.Servers))
+
+	return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
+		spawn("server", parallel.Fail, func(ctx context.Context) error {
+			return server.Run(ctx, listeners...)
+		})
+		spawn("status", parallel.Fail, func(ctx context.Context) error {
+			return reportStatus(ctx, server, config.StatusInterval)
+		})
+		if gateway != nil {
+			spawn("gateway", parallel.Fail, gateway.Run)
+		}
+		return nil
+	})
+}
+
+// reportStatus periodically logs the state of peers and cluster members.
+func reportStatus(ctx context.Context, server *wave.Server, interval time.Duration) error {
+	log := logger.Get(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case <-ticker.C:
+		}
+
+		var clients, servers int
+		for _, p := range server.Peers() {
+			if p.Role == wave.RoleServer {
+				servers++
+			} else {
+				clients++
+			}
+		}
+
+		var suspected []string
+		members := server.Members()
+		for _, m := range members {
+			if m.State == wave.MemberSuspected {
+				suspected = append(suspected, m.Address)
+			}
+		}
+
+		log.Info("Wave server status",
+			zap.Int("clients", clients),
+			zap.Int("servers", servers),
+			zap.Int("members", len(members)),
+			zap.Strings("suspected", suspected))
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/outofforest/run"
+	"github.com/outofforest/wave/wavectl"
+)
+
+// Teams wanting to decode content of their messages build their own copy of this command, passing their
+// marshallers to wavectl.Run.
+func main() {
+	run.New().Run(context.Background(), "wavectl", func(ctx context.Context) error {
+		return wavectl.Run(ctx, os.Args[1:], os.Stdout)
+	})
+}
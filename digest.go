@@ -0,0 +1,99 @@
+package wave
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+
+	"github.com/outofforest/wave/wire"
+)
+
+// digestBucket returns the bucket revision descriptor belongs to.
+func digestBucket(revDesc revDescriptor) uint64 {
+	h := fnv.New64a()
+	writeRevDescriptor(h, revDesc)
+	return h.Sum64() % wire.DigestBuckets
+}
+
+func revisionHash(revDesc revDescriptor, index wire.Revision) uint64 {
+	h := fnv.New64a()
+	writeRevDescriptor(h, revDesc)
+
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(index))
+	_, _ = h.Write(b[:])
+
+	return h.Sum64()
+}
+
+func writeRevDescriptor(h io.Writer, revDesc revDescriptor) {
+	var b [8]byte
+	_, _ = h.Write([]byte(revDesc.Namespace))
+	binary.LittleEndian.PutUint64(b[:], uint64(revDesc.MessageID))
+	_, _ = h.Write(b[:])
+	binary.LittleEndian.PutUint64(b[:], revDesc.Fingerprint)
+	_, _ = h.Write(b[:])
+	_, _ = h.Write(revDesc.Sender[:])
+}
+
+// newDigest computes digest of the revisions. Hashes are XORed, so the order of revisions doesn't matter.
+func newDigest(revs map[revDescriptor]wire.Revision) *wire.Digest {
+	d := &wire.Digest{}
+	for revDesc, index := range revs {
+		d.Buckets[digestBucket(revDesc)] ^= revisionHash(revDesc, index)
+	}
+	return d
+}
+
+// digestEntries returns entries for revisions belonging to the buckets which differ between digests.
+// Entries are sorted, so the same revisions always produce the same sequence of messages.
+func digestEntries(
+	revs map[revDescriptor]wire.Revision,
+	local, remote *wire.Digest,
+) ([wire.DigestBuckets]bool, []*wire.DigestEntry) {
+	var mismatched [wire.DigestBuckets]bool
+	for i := range local.Buckets {
+		mismatched[i] = local.Buckets[i] != remote.Buckets[i]
+	}
+
+	revDescs := []revDescriptor{}
+	for revDesc := range revs {
+		if mismatched[digestBucket(revDesc)] {
+			revDescs = append(revDescs, revDesc)
+		}
+	}
+	sortRevDescriptors(revDescs)
+
+	entries := make([]*wire.DigestEntry, 0, len(revDescs))
+	for _, revDesc := range revDescs {
+		entries = append(entries, &wire.DigestEntry{
+			Sender: revDesc.Sender,
+			Revision: wire.RevisionDescriptor{
+				Message: revDesc.MessageDescriptor,
+				Index:   revs[revDesc],
+			},
+		})
+	}
+
+	return mismatched, entries
+}
+
+// missingRevisions returns revisions from mismatched buckets which are missing or stale on the peer.
+func missingRevisions(
+	revs map[revDescriptor]wire.Revision,
+	mismatched [wire.DigestBuckets]bool,
+	peerRevs map[revDescriptor]wire.Revision,
+) []revDescriptor {
+	missing := []revDescriptor{}
+	for revDesc, index := range revs {
+		if !mismatched[digestBucket(revDesc)] {
+			continue
+		}
+		if peerIndex, exists := peerRevs[revDesc]; exists && peerIndex >= index {
+			continue
+		}
+		missing = append(missing, revDesc)
+	}
+	sortRevDescriptors(missing)
+	return missing
+}
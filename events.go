@@ -0,0 +1,157 @@
+package wave
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/outofforest/wave/wire"
+)
+
+const eventsBufferSize = 100
+
+// EventType is the type of the connection event.
+type EventType int
+
+const (
+	// EventConnected is emitted when connection to the server is established.
+	EventConnected EventType = iota
+
+	// EventHandshakeCompleted is emitted when hello messages have been exchanged with the server.
+	EventHandshakeCompleted
+
+	// EventSyncCompleted is emitted when all the revisions missing on the client have been received from the server.
+	EventSyncCompleted
+
+	// EventDisconnected is emitted when connection to the server is closed.
+	EventDisconnected
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventConnected:
+		return "connected"
+	case EventHandshakeCompleted:
+		return "handshakeCompleted"
+	case EventSyncCompleted:
+		return "syncCompleted"
+	case EventDisconnected:
+		return "disconnected"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes change of the connection state.
+type Event struct {
+	Type    EventType
+	Address string
+	Time    time.Time
+
+	// ServerID is set once handshake is completed.
+	ServerID wire.PeerID
+
+	// Err is the reason of disconnection.
+	Err error
+}
+
+// ServerStatus is the state of the connection to the server.
+type ServerStatus struct {
+	Address   string
+	ServerID  wire.PeerID
+	Connected bool
+	Synced    bool
+	Since     time.Time
+	LastError string
+}
+
+// Status is the snapshot of client connections.
+type Status struct {
+	Servers []ServerStatus
+
+	// Connected is the number of servers handshake has been completed with.
+	Connected int
+
+	// Synced is the number of connected servers client has caught up with.
+	Synced int
+}
+
+type clientEvents struct {
+	ch chan Event
+
+	mu      sync.Mutex
+	servers map[string]*ServerStatus
+}
+
+func newClientEvents() *clientEvents {
+	return &clientEvents{
+		ch:      make(chan Event, eventsBufferSize),
+		servers: map[string]*ServerStatus{},
+	}
+}
+
+// Emit updates status of the server and publishes the event.
+// Events are dropped if nobody reads them, so connections are never blocked.
+func (e *clientEvents) Emit(event Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	status, exists := e.servers[event.Address]
+	if !exists {
+		status = &ServerStatus{Address: event.Address}
+		e.servers[event.Address] = status
+	}
+
+	switch event.Type {
+	case EventHandshakeCompleted:
+		status.ServerID = event.ServerID
+		status.Connected = true
+		status.Since = event.Time
+	case EventSyncCompleted:
+		status.Synced = true
+	case EventDisconnected:
+		status.Connected = false
+		status.Synced = false
+		status.Since = event.Time
+		if event.Err != nil {
+			status.LastError = event.Err.Error()
+		}
+	}
+
+	select {
+	case e.ch <- event:
+	default:
+	}
+}
+
+// Remove forgets the server.
+func (e *clientEvents) Remove(address string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.servers, address)
+}
+
+// Status returns the snapshot of connection states.
+func (e *clientEvents) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	status := Status{
+		Servers: make([]ServerStatus, 0, len(e.servers)),
+	}
+	for _, s := range e.servers {
+		status.Servers = append(status.Servers, *s)
+		if s.Connected {
+			status.Connected++
+		}
+		if s.Synced {
+			status.Synced++
+		}
+	}
+	sort.Slice(status.Servers, func(i, j int) bool {
+		return status.Servers[i].Address < status.Servers[j].Address
+	})
+
+	return status
+}
@@ -0,0 +1,223 @@
+package wave
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/parallel"
+	"github.com/outofforest/qa"
+	"github.com/outofforest/resonance"
+	"github.com/outofforest/wave/wire"
+)
+
+const (
+	fuzzMaxMessageSize = 64 * 1024
+	fuzzTimeout        = 5 * time.Second
+)
+
+var (
+	fuzzPeerID = wire.PeerID{0x01}
+
+	fuzzHeader = &wire.Header{
+		Sender: fuzzPeerID,
+		Revision: wire.RevisionDescriptor{
+			Message: wire.MessageDescriptor{
+				Namespace: "namespace",
+				MessageID: 1,
+			},
+			Index: 2,
+		},
+		Trace: wire.TraceContext{
+			TraceID: wire.TraceID{0x01},
+			SpanID:  wire.SpanID{0x02},
+			Flags:   1,
+		},
+	}
+
+	fuzzMessages = []any{
+		&wire.Hello{
+			PeerID: fuzzPeerID,
+			Requests: []wire.NamespaceRequest{
+				{
+					Namespace:  "namespace",
+					MessageIDs: []wire.MessageID{1, 2, 1000},
+				},
+			},
+		},
+		&wire.Hello{
+			PeerID:   fuzzPeerID,
+			IsServer: true,
+		},
+		&wire.Hello{
+			PeerID: fuzzPeerID,
+			Probe:  true,
+		},
+		fuzzHeader,
+		&wire.Digest{
+			Buckets: [wire.DigestBuckets]uint64{1, 2, 3},
+		},
+		&wire.DigestEntry{
+			Sender:   fuzzPeerID,
+			Revision: fuzzHeader.Revision,
+		},
+		&wire.DigestEnd{},
+		&wire.SyncEnd{},
+		&wire.Members{
+			Members: []wire.Member{
+				{
+					PeerID:    wire.PeerID{0x02},
+					Address:   "localhost:1234",
+					Heartbeat: 10,
+				},
+			},
+		},
+		&wire.Subscription{
+			Requests: []wire.NamespaceRequest{
+				{
+					Namespace:  "namespace",
+					MessageIDs: []wire.MessageID{1, 3},
+				},
+				{
+					Namespace: wire.AnyNamespace,
+				},
+			},
+		},
+	}
+)
+
+func FuzzWireUnmarshal(f *testing.F) {
+	m := wire.NewBoundedMarshaller()
+	for _, msg := range fuzzMessages {
+		size, err := m.Size(msg)
+		require.NoError(f, err)
+		buf := make([]byte, size)
+		id, _, err := m.Marshal(msg, buf)
+		require.NoError(f, err)
+		f.Add(id, buf)
+	}
+
+	f.Fuzz(func(t *testing.T, id uint64, data []byte) {
+		requireT := require.New(t)
+
+		// Each element of the decoded slice takes at least one byte, so allocation is bounded by the input.
+		// Constant part tolerates allocations made by the fuzzing engine in the background.
+		limit := uint64(16*1024*1024 + 64*len(data))
+		var msg any
+		var err error
+		requireT.LessOrEqual(allocatedBytes(limit, func() {
+			msg, _, err = m.Unmarshal(id, data)
+		}), limit)
+
+		if err != nil {
+			return
+		}
+
+		// Decoded message is encoded and decoded again to the same value.
+		size, err := m.Size(msg)
+		requireT.NoError(err)
+		buf := make([]byte, size)
+		id2, _, err := m.Marshal(msg, buf)
+		requireT.NoError(err)
+		requireT.Equal(id, id2)
+		msg2, _, err := m.Unmarshal(id2, buf)
+		requireT.NoError(err)
+		requireT.Equal(msg, msg2)
+	})
+}
+
+func FuzzServerConn(f *testing.F) {
+	content := &wire.Digest{}
+	f.Add([]byte{})
+	f.Add(fuzzStream(f, fuzzMessages[2]))
+	f.Add(fuzzStream(f, fuzzMessages[0], &wire.Digest{}, &wire.DigestEnd{}, fuzzHeader, content, &wire.SyncEnd{}))
+	f.Add(fuzzStream(f, fuzzMessages[1], &wire.Digest{}, fuzzMessages[5], &wire.DigestEnd{}, fuzzMessages[8],
+		fuzzHeader, content, &wire.SyncEnd{}))
+	f.Add(fuzzStream(f, fuzzMessages[0], fuzzMessages[8]))
+	f.Add(fuzzStream(f, fuzzMessages[0], &wire.Digest{}, fuzzMessages[9], fuzzHeader, content))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s, err := NewServer(ServerConfig{MaxMessageSize: fuzzMaxMessageSize})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(qa.NewContext(t), fuzzTimeout)
+		defer cancel()
+
+		serverConn, peerConn := net.Pipe()
+		go func() {
+			_, _ = io.Copy(io.Discard, peerConn)
+		}()
+		go func() {
+			_, _ = peerConn.Write(data)
+			_ = peerConn.Close()
+		}()
+
+		joinedCh := make(chan wire.PeerID)
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-joinedCh:
+				}
+			}
+		}()
+
+		err = runConnection(ctx, serverConn, transportConfig{
+			Connection: resonance.Config{MaxMessageSize: fuzzMaxMessageSize},
+			Metrics:    s.metrics,
+		}, func(ctx context.Context, c *connection) error {
+			_, err := s.runConn(ctx, c, "", "fuzz", joinedCh)
+			return err
+		})
+
+		// Connection must end once the stream is consumed, without waiting for missed pings.
+		require.NoError(t, ctx.Err(), "connection hangs")
+		require.False(t, errors.As(err, &parallel.PanicError{}), "connection panicked: %v", err)
+	})
+}
+
+// allocatedBytes returns the number of bytes allocated by the function. Fuzzing engine allocates in the background,
+// so measurement is repeated while it exceeds the limit.
+func allocatedBytes(limit uint64, fn func()) uint64 {
+	var allocated uint64
+	for range 3 {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		fn()
+		runtime.ReadMemStats(&after)
+
+		allocated = after.TotalAlloc - before.TotalAlloc
+		if allocated <= limit {
+			break
+		}
+	}
+	return allocated
+}
+
+type bufferPeer struct {
+	bytes.Buffer
+}
+
+func (p *bufferPeer) Close() error {
+	return nil
+}
+
+// fuzzStream encodes messages the way peer sends them. Message following the header is sent as its content.
+func fuzzStream(f *testing.F, msgs ...any) []byte {
+	peer := &bufferPeer{}
+	c := resonance.NewConnection(peer, resonance.Config{MaxMessageSize: fuzzMaxMessageSize})
+	m := wire.NewMarshaller()
+	for _, msg := range msgs {
+		_, err := c.SendProton(msg, m)
+		require.NoError(f, err)
+	}
+	return peer.Bytes()
+}
@@ -0,0 +1,461 @@
+package wave
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/parallel"
+	"github.com/outofforest/proton"
+	"github.com/outofforest/wave/wire"
+)
+
+const (
+	gatewaySubscriberBuffer  = 100
+	gatewayKeepAliveInterval = 15 * time.Second
+
+	// gatewayBodySizeFactor limits the size of the published JSON relative to the maximum message size.
+	// JSON is larger than the encoded message, so the limit is loose and the size of the message is checked
+	// after decoding.
+	gatewayBodySizeFactor = 8
+)
+
+// GatewayConfig is the config of gateway.
+type GatewayConfig struct {
+	// Servers are the seed addresses of servers.
+	Servers        []string
+	MaxMessageSize uint64
+
+	// Address is the address of the HTTP listener. If empty, gateway is served only by the handler returned
+	// from Handler.
+	Address string
+
+	// Marshallers define namespaces whose messages are exposed as JSON of their types. Messages of other
+	// namespaces are exposed as RawMessage, with content encoded in base64.
+	Marshallers []proton.Marshaller
+}
+
+// GatewayRevision is the revision of the message delivered by the gateway as JSON.
+type GatewayRevision struct {
+	Namespace wire.Namespace
+	MessageID wire.MessageID
+
+	// Type is the name of the message type, empty if marshaller of the namespace is not registered.
+	Type string
+
+	Sender wire.PeerID
+	Index  wire.Revision
+
+	// TraceParent is the trace context of the revision in W3C format, empty if revision is not traced.
+	TraceParent string `json:",omitempty"`
+
+	Message any
+}
+
+type gatewayNamespace struct {
+	Marshaller proton.Marshaller
+	Types      map[string]reflect.Type
+	IDs        map[string]wire.MessageID
+	Names      map[wire.MessageID]string
+}
+
+type gatewayEvent struct {
+	Seq       uint64
+	Namespace wire.Namespace
+	MessageID wire.MessageID
+	Data      []byte
+}
+
+type gatewaySubscriber struct {
+	Namespace  wire.Namespace
+	MessageIDs map[wire.MessageID]struct{}
+	Ch         chan gatewayEvent
+}
+
+func (s *gatewaySubscriber) Matches(e gatewayEvent) bool {
+	if s.Namespace != wire.AnyNamespace && s.Namespace != e.Namespace {
+		return false
+	}
+	if s.MessageIDs == nil {
+		return true
+	}
+	_, exists := s.MessageIDs[e.MessageID]
+	return exists
+}
+
+// Gateway exposes messages to HTTP clients. Messages are published by POST requests and delivered
+// as Server-Sent Events.
+type Gateway struct {
+	config     GatewayConfig
+	client     *Client
+	recvCh     <-chan any
+	namespaces map[wire.Namespace]gatewayNamespace
+
+	mu          sync.Mutex
+	closed      bool
+	seq         uint64
+	revisions   map[revDescriptor]gatewayEvent
+	subscribers map[*gatewaySubscriber]struct{}
+}
+
+// NewGateway creates new gateway.
+func NewGateway(config GatewayConfig) (*Gateway, error) {
+	namespaces := map[wire.Namespace]gatewayNamespace{}
+	requests := make([]RequestConfig, 0, len(config.Marshallers)+1)
+	for _, m := range config.Marshallers {
+		ns := gatewayNamespace{
+			Marshaller: m,
+			Types:      map[string]reflect.Type{},
+			IDs:        map[string]wire.MessageID{},
+			Names:      map[wire.MessageID]string{},
+		}
+		req := RequestConfig{
+			Marshaller: m,
+		}
+		for _, msgType := range m.Messages() {
+			t := reflect.TypeOf(msgType)
+			msg := reflect.New(t).Interface()
+			id, err := m.ID(msg)
+			if err != nil {
+				return nil, err
+			}
+			ns.Types[t.Name()] = t
+			ns.IDs[t.Name()] = wire.MessageID(id)
+			ns.Names[wire.MessageID(id)] = t.Name()
+			req.Messages = append(req.Messages, msg)
+		}
+		namespaces[marshallerToNamespace(m)] = ns
+		requests = append(requests, req)
+	}
+	requests = append(requests, RequestConfig{Marshaller: RawMarshaller{Namespace: wire.AnyNamespace}})
+
+	client, recvCh, err := NewClient(ClientConfig{
+		Servers:        config.Servers,
+		MaxMessageSize: config.MaxMessageSize,
+		Requests:       requests,
+		Envelopes:      true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Gateway{
+		config:      config,
+		client:      client,
+		recvCh:      recvCh,
+		namespaces:  namespaces,
+		revisions:   map[revDescriptor]gatewayEvent{},
+		subscribers: map[*gatewaySubscriber]struct{}{},
+	}, nil
+}
+
+// Run runs gateway.
+func (g *Gateway) Run(ctx context.Context) error {
+	return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
+		spawn("client", parallel.Fail, g.client.Run)
+		spawn("receiver", parallel.Fail, func(ctx context.Context) error {
+			defer g.closeSubscribers()
+
+			for msg := range g.recvCh {
+				if err := g.store(msg.(*Envelope)); err != nil {
+					return err
+				}
+			}
+			return errors.WithStack(ctx.Err())
+		})
+		if g.config.Address != "" {
+			spawn("http", parallel.Fail, func(ctx context.Context) error {
+				return serveHTTP(ctx, g.config.Address, g.Handler())
+			})
+		}
+		return nil
+	})
+}
+
+// Client returns client used by the gateway to communicate with servers.
+func (g *Gateway) Client() *Client {
+	return g.client
+}
+
+// Handler returns HTTP handler of the gateway.
+//
+// POST /publish?namespace=<namespace>&type=<type> publishes message decoded from JSON body. Trace context
+// is taken from the traceparent header. In namespaces without registered marshaller type is not set
+// and body is the JSON of RawMessage.
+//
+// GET /subscribe?namespace=<namespace>&type=<type> streams revisions as Server-Sent Events. Both parameters
+// are optional, type may be repeated. Revisions of all namespaces are streamed if namespace is empty or "*". Latest revisions of all the matching messages are sent first, then
+// the new ones as they arrive. If Last-Event-ID is set, only the revisions received after that event
+// are sent first.
+func (g *Gateway) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /publish", g.publish)
+	mux.HandleFunc("GET /subscribe", g.subscribe)
+	return mux
+}
+
+func (g *Gateway) publish(w http.ResponseWriter, r *http.Request) {
+	namespace := wire.Namespace(r.URL.Query().Get("namespace"))
+	if namespace == "" || namespace == wire.AnyNamespace {
+		http.Error(w, "invalid namespace", http.StatusBadRequest)
+		return
+	}
+
+	var msg any
+	ns, exists := g.namespaces[namespace]
+	if exists {
+		t, exists := ns.Types[r.URL.Query().Get("type")]
+		if !exists {
+			http.Error(w, "unknown message type", http.StatusNotFound)
+			return
+		}
+		msg = reflect.New(t).Interface()
+	} else {
+		if r.URL.Query().Has("type") {
+			http.Error(w, "unknown message type", http.StatusNotFound)
+			return
+		}
+		ns.Marshaller = RawMarshaller{Namespace: namespace}
+		msg = &RawMessage{}
+	}
+
+	body := http.MaxBytesReader(w, r.Body, int64(gatewayBodySizeFactor*g.config.MaxMessageSize))
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(msg); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "message is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	size, err := ns.Marshaller.Size(msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if size > g.config.MaxMessageSize {
+		http.Error(w, "message is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var parent wire.TraceContext
+	if traceParent := r.Header.Get("traceparent"); traceParent != "" {
+		parent, err = ParseTraceParent(traceParent)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if parent.IsZero() {
+		err = g.client.Send(r.Context(), msg, ns.Marshaller)
+	} else {
+		err = g.client.SendTraced(r.Context(), msg, ns.Marshaller, parent)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (g *Gateway) subscribe(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := &gatewaySubscriber{
+		Namespace: wire.Namespace(r.URL.Query().Get("namespace")),
+		Ch:        make(chan gatewayEvent, gatewaySubscriberBuffer),
+	}
+	if sub.Namespace == "" {
+		sub.Namespace = wire.AnyNamespace
+	}
+	if sub.Namespace != wire.AnyNamespace {
+		// Namespace without registered marshaller has no known types.
+		ns := g.namespaces[sub.Namespace]
+		if types := r.URL.Query()["type"]; len(types) > 0 {
+			sub.MessageIDs = map[wire.MessageID]struct{}{}
+			for _, typeName := range types {
+				id, exists := ns.IDs[typeName]
+				if !exists {
+					http.Error(w, "unknown message type", http.StatusNotFound)
+					return
+				}
+				sub.MessageIDs[id] = struct{}{}
+			}
+		}
+	} else if r.URL.Query().Has("type") {
+		http.Error(w, "type requires namespace", http.StatusBadRequest)
+		return
+	}
+
+	replay := g.addSubscriber(sub, g.lastSeq(r.Header.Get("Last-Event-ID")))
+	defer g.removeSubscriber(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, e := range replay {
+		if err := g.writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(gatewayKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-sub.Ch:
+			if !ok {
+				// Subscriber is too slow or gateway is closed, HTTP client reconnects using Last-Event-ID.
+				return
+			}
+			if err := g.writeEvent(w, e); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func (g *Gateway) writeEvent(w http.ResponseWriter, e gatewayEvent) error {
+	_, err := fmt.Fprintf(w, "id: %s-%d\ndata: %s\n\n", g.client.ID(), e.Seq, e.Data)
+	return errors.WithStack(err)
+}
+
+// lastSeq returns sequence number of the event identified by Last-Event-ID. Zero is returned if event comes
+// from other instance of the gateway, so all the revisions are sent.
+func (g *Gateway) lastSeq(lastEventID string) uint64 {
+	pos := strings.LastIndex(lastEventID, "-")
+	if pos < 0 || lastEventID[:pos] != g.client.ID().String() {
+		return 0
+	}
+	seq, err := strconv.ParseUint(lastEventID[pos+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return seq
+}
+
+func (g *Gateway) store(envelope *Envelope) error {
+	header := envelope.Header
+	ns := g.namespaces[header.Revision.Message.Namespace]
+
+	rev := GatewayRevision{
+		Namespace: header.Revision.Message.Namespace,
+		MessageID: header.Revision.Message.MessageID,
+		Type:      ns.Names[header.Revision.Message.MessageID],
+		Sender:    header.Sender,
+		Index:     header.Revision.Index,
+		Message:   envelope.Message,
+	}
+	if !header.Trace.IsZero() {
+		rev.TraceParent = header.Trace.String()
+	}
+
+	data, err := json.Marshal(rev)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.seq++
+	e := gatewayEvent{
+		Seq:       g.seq,
+		Namespace: rev.Namespace,
+		MessageID: rev.MessageID,
+		Data:      data,
+	}
+
+	// Only the latest revision is kept because older ones are never synchronized again.
+	g.revisions[revDescriptor{
+		MessageDescriptor: header.Revision.Message,
+		Sender:            header.Sender,
+	}] = e
+
+	for sub := range g.subscribers {
+		if !sub.Matches(e) {
+			continue
+		}
+		select {
+		case sub.Ch <- e:
+		default:
+			delete(g.subscribers, sub)
+			close(sub.Ch)
+		}
+	}
+
+	return nil
+}
+
+// addSubscriber registers subscriber and returns stored revisions received after lastSeq.
+func (g *Gateway) addSubscriber(sub *gatewaySubscriber, lastSeq uint64) []gatewayEvent {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed {
+		close(sub.Ch)
+		return nil
+	}
+
+	replay := []gatewayEvent{}
+	for _, e := range g.revisions {
+		if e.Seq > lastSeq && sub.Matches(e) {
+			replay = append(replay, e)
+		}
+	}
+	sort.Slice(replay, func(i, j int) bool {
+		return replay[i].Seq < replay[j].Seq
+	})
+
+	g.subscribers[sub] = struct{}{}
+	return replay
+}
+
+func (g *Gateway) removeSubscriber(sub *gatewaySubscriber) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, exists := g.subscribers[sub]; exists {
+		delete(g.subscribers, sub)
+		close(sub.Ch)
+	}
+}
+
+func (g *Gateway) closeSubscribers() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.closed = true
+	for sub := range g.subscribers {
+		delete(g.subscribers, sub)
+		close(sub.Ch)
+	}
+}
@@ -8,23 +8,23 @@ require (
 	github.com/outofforest/proton v0.20.0
 	github.com/outofforest/qa v0.3.0
 	github.com/outofforest/resonance v0.26.0
+	github.com/outofforest/run v0.8.0
+	github.com/outofforest/varuint64 v0.1.1
 	github.com/pkg/errors v0.9.1
+	github.com/spf13/pflag v1.0.10
 	github.com/stretchr/testify v1.11.1
 	go.uber.org/zap v1.27.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/outofforest/ioc/v2 v2.5.2 // indirect
 	github.com/outofforest/mass v0.2.1 // indirect
-	github.com/outofforest/run v0.8.0 // indirect
 	github.com/outofforest/spin v0.3.1 // indirect
-	github.com/outofforest/varuint64 v0.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/samber/lo v1.52.0 // indirect
-	github.com/spf13/pflag v1.0.10 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	golang.org/x/mod v0.32.0 // indirect
 	golang.org/x/text v0.33.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -19,7 +19,41 @@ func peerID() (wire.PeerID, error) {
 	return id, nil
 }
 
+var _ proton.Marshaller = NamespacedMarshaller{}
+
+// NamespacedMarshaller assigns explicit namespace to the messages of the marshaller. Namespace derived from
+// the marshaller type changes whenever its package is moved or renamed, the explicit one stays stable.
+type NamespacedMarshaller struct {
+	proton.Marshaller
+
+	// Namespace is the namespace of the messages. If empty, it is derived from the marshaller type.
+	Namespace wire.Namespace
+}
+
+func (m NamespacedMarshaller) namespace() wire.Namespace {
+	if m.Namespace == "" {
+		return marshallerToNamespace(m.Marshaller)
+	}
+	return m.Namespace
+}
+
+// namespacer is implemented by marshallers declaring their namespace explicitly.
+type namespacer interface {
+	namespace() wire.Namespace
+}
+
+// NamespaceOf returns the namespace of messages handled by the marshaller.
+func NamespaceOf(m proton.Marshaller) wire.Namespace {
+	return marshallerToNamespace(m)
+}
+
+// marshallerToNamespace returns the namespace declared by the marshaller. If it is not declared, namespace is
+// derived from the package path and the name of the marshaller type.
 func marshallerToNamespace(m proton.Marshaller) wire.Namespace {
+	if nm, ok := m.(namespacer); ok {
+		return nm.namespace()
+	}
+
 	t := reflect.TypeOf(m)
 	return wire.Namespace(t.PkgPath() + "." + t.Name())
 }
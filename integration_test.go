@@ -1,44 +1,50 @@
 package wave_test
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/outofforest/parallel"
+	"github.com/outofforest/proton"
 	"github.com/outofforest/qa"
 	"github.com/outofforest/wave"
 	"github.com/outofforest/wave/test/wire1"
 	"github.com/outofforest/wave/test/wire2"
+	"github.com/outofforest/wave/wavectl"
+	"github.com/outofforest/wave/wavetest"
+	"github.com/outofforest/wave/wire"
 )
 
 const maxMsgSize = 1024
 
 func TestSingleServerAndClient(t *testing.T) {
 	requireT := require.New(t)
-
 	ctx := qa.NewContext(t)
-	group := qa.NewGroup(ctx, t)
-
-	defer func() {
-		group.Exit(nil)
-		requireT.NoError(group.Wait())
-	}()
-
-	ls, err := net.Listen("tcp", "localhost:0")
-	requireT.NoError(err)
 
-	servers := []string{
-		ls.Addr().String(),
-	}
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
 
 	m := wire1.NewMarshaller()
-	clientConfig := wave.ClientConfig{
-		Servers:        servers,
-		MaxMessageSize: maxMsgSize,
+	client := cluster.NewClient(wave.ClientConfig{
 		Requests: []wave.RequestConfig{
 			{
 				Marshaller: m,
@@ -47,58 +53,33 @@ func TestSingleServerAndClient(t *testing.T) {
 				},
 			},
 		},
-	}
-
-	client, recvCh, err := wave.NewClient(clientConfig)
-	requireT.NoError(err)
-
-	group.Spawn("client", parallel.Fail, client.Run)
-	group.Spawn("server", parallel.Fail, func(ctx context.Context) error {
-		return wave.RunServer(ctx, ls, wave.ServerConfig{
-			Servers:        servers,
-			MaxMessageSize: maxMsgSize,
-		})
 	})
 
-	requireT.NoError(client.Send(&wire1.Msg1{
+	requireT.NoError(client.Send(ctx, &wire1.Msg1{
 		Value: "test1",
 	}, m))
 
-	testMsgs(ctx, requireT, recvCh,
+	client.ExpectMessages(
 		&wire1.Msg1{Value: "test1"},
 	)
 
-	requireT.NoError(client.Send(&wire1.Msg1{
+	requireT.NoError(client.Send(ctx, &wire1.Msg1{
 		Value: "test2",
 	}, m))
 
-	testMsgs(ctx, requireT, recvCh,
+	client.ExpectMessages(
 		&wire1.Msg1{Value: "test2"},
 	)
 }
 
 func TestOnlyRequestedMessagesAreReceived(t *testing.T) {
 	requireT := require.New(t)
-
 	ctx := qa.NewContext(t)
-	group := qa.NewGroup(ctx, t)
-
-	defer func() {
-		group.Exit(nil)
-		requireT.NoError(group.Wait())
-	}()
 
-	ls, err := net.Listen("tcp", "localhost:0")
-	requireT.NoError(err)
-
-	servers := []string{
-		ls.Addr().String(),
-	}
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
 
 	m := wire1.NewMarshaller()
-	clientConfig := wave.ClientConfig{
-		Servers:        servers,
-		MaxMessageSize: maxMsgSize,
+	client := cluster.NewClient(wave.ClientConfig{
 		Requests: []wave.RequestConfig{
 			{
 				Marshaller: m,
@@ -107,54 +88,29 @@ func TestOnlyRequestedMessagesAreReceived(t *testing.T) {
 				},
 			},
 		},
-	}
-
-	client, recvCh, err := wave.NewClient(clientConfig)
-	requireT.NoError(err)
-
-	group.Spawn("client", parallel.Fail, client.Run)
-	group.Spawn("server", parallel.Fail, func(ctx context.Context) error {
-		return wave.RunServer(ctx, ls, wave.ServerConfig{
-			Servers:        servers,
-			MaxMessageSize: maxMsgSize,
-		})
 	})
 
-	requireT.NoError(client.Send(&wire1.Msg1{
+	requireT.NoError(client.Send(ctx, &wire1.Msg1{
 		Value: "test",
 	}, m))
-	requireT.NoError(client.Send(&wire1.Msg2{
+	requireT.NoError(client.Send(ctx, &wire1.Msg2{
 		Value: 2,
 	}, m))
 
-	testMsgs(ctx, requireT, recvCh,
+	client.ExpectMessages(
 		&wire1.Msg2{Value: 2},
 	)
 }
 
 func TestTwoNamespaces(t *testing.T) {
 	requireT := require.New(t)
-
 	ctx := qa.NewContext(t)
-	group := qa.NewGroup(ctx, t)
-
-	defer func() {
-		group.Exit(nil)
-		requireT.NoError(group.Wait())
-	}()
-
-	ls, err := net.Listen("tcp", "localhost:0")
-	requireT.NoError(err)
 
-	servers := []string{
-		ls.Addr().String(),
-	}
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
 
 	m1 := wire1.NewMarshaller()
 	m2 := wire2.NewMarshaller()
-	clientConfig := wave.ClientConfig{
-		Servers:        servers,
-		MaxMessageSize: maxMsgSize,
+	client := cluster.NewClient(wave.ClientConfig{
 		Requests: []wave.RequestConfig{
 			{
 				Marshaller: m1,
@@ -169,347 +125,2086 @@ func TestTwoNamespaces(t *testing.T) {
 				},
 			},
 		},
-	}
-
-	client, recvCh, err := wave.NewClient(clientConfig)
-	requireT.NoError(err)
-
-	group.Spawn("client", parallel.Fail, client.Run)
-	group.Spawn("server", parallel.Fail, func(ctx context.Context) error {
-		return wave.RunServer(ctx, ls, wave.ServerConfig{
-			Servers:        servers,
-			MaxMessageSize: maxMsgSize,
-		})
 	})
 
-	requireT.NoError(client.Send(&wire1.Msg1{
+	requireT.NoError(client.Send(ctx, &wire1.Msg1{
 		Value: "test1",
 	}, m1))
-	requireT.NoError(client.Send(&wire1.Msg2{
+	requireT.NoError(client.Send(ctx, &wire1.Msg2{
 		Value: 1,
 	}, m1))
-	requireT.NoError(client.Send(&wire2.Msg1{
+	requireT.NoError(client.Send(ctx, &wire2.Msg1{
 		Value: "test2",
 	}, m2))
-	requireT.NoError(client.Send(&wire2.Msg2{
+	requireT.NoError(client.Send(ctx, &wire2.Msg2{
 		Value: 2,
 	}, m2))
 
-	testMsgs(ctx, requireT, recvCh,
+	client.ExpectMessages(
 		&wire1.Msg2{Value: 1},
 		&wire2.Msg2{Value: 2},
 	)
 }
 
-func TestServerSendsMessagesToNewClient(t *testing.T) {
+func TestExplicitNamespace(t *testing.T) {
 	requireT := require.New(t)
-
 	ctx := qa.NewContext(t)
-	group := qa.NewGroup(ctx, t)
-
-	defer func() {
-		group.Exit(nil)
-		requireT.NoError(group.Wait())
-	}()
-
-	ls1, err := net.Listen("tcp", "localhost:0")
-	requireT.NoError(err)
 
-	servers := []string{
-		ls1.Addr().String(),
-	}
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
 
 	m := wire1.NewMarshaller()
-	clientConfig := wave.ClientConfig{
-		Servers:        servers,
-		MaxMessageSize: maxMsgSize,
+	client := cluster.NewClient(wave.ClientConfig{
 		Requests: []wave.RequestConfig{
 			{
+				Namespace:  "orders",
 				Marshaller: m,
-				Messages:   []any{&wire1.Msg1{}, &wire1.Msg2{}},
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+	})
+	reflectionClient := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}},
 			},
 		},
-	}
-
-	client1, recvCh1, err := wave.NewClient(clientConfig)
-	requireT.NoError(err)
-
-	client2, recvCh2, err := wave.NewClient(clientConfig)
-	requireT.NoError(err)
-
-	group.Spawn("client1", parallel.Fail, client1.Run)
-	group.Spawn("server1", parallel.Fail, func(ctx context.Context) error {
-		return wave.RunServer(ctx, ls1, wave.ServerConfig{
-			Servers:        servers,
-			MaxMessageSize: maxMsgSize,
-		})
 	})
 
-	requireT.NoError(client1.Send(&wire1.Msg1{
+	requireT.NoError(client.Send(ctx, &wire1.Msg1{
 		Value: "test1",
-	}, m))
-
-	testMsgs(ctx, requireT, recvCh1,
-		&wire1.Msg1{Value: "test1"},
-	)
-
-	group.Spawn("client2", parallel.Fail, client2.Run)
-
-	testMsgs(ctx, requireT, recvCh1)
-	testMsgs(ctx, requireT, recvCh2,
-		&wire1.Msg1{Value: "test1"},
-	)
-
-	requireT.NoError(client1.Send(&wire1.Msg1{
+	}, wave.NamespacedMarshaller{Marshaller: m, Namespace: "orders"}))
+	requireT.NoError(reflectionClient.Send(ctx, &wire1.Msg1{
 		Value: "test2",
 	}, m))
 
-	testMsgs(ctx, requireT, recvCh1,
-		&wire1.Msg1{Value: "test2"},
+	client.ExpectMessages(
+		&wire1.Msg1{Value: "test1"},
 	)
-	testMsgs(ctx, requireT, recvCh2,
+	reflectionClient.ExpectMessages(
 		&wire1.Msg1{Value: "test2"},
 	)
 }
 
-func TestServersExchangeMessages(t *testing.T) {
+func TestNamespaceAliases(t *testing.T) {
 	requireT := require.New(t)
-
 	ctx := qa.NewContext(t)
-	group := qa.NewGroup(ctx, t)
-
-	defer func() {
-		group.Exit(nil)
-		requireT.NoError(group.Wait())
-	}()
-
-	ls1, err := net.Listen("tcp", "localhost:0")
-	requireT.NoError(err)
-	ls2, err := net.Listen("tcp", "localhost:0")
-	requireT.NoError(err)
 
-	servers := []string{
-		ls1.Addr().String(),
-		ls2.Addr().String(),
-	}
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
 
 	m := wire1.NewMarshaller()
-	clientConfig1 := wave.ClientConfig{
-		Servers:        []string{ls1.Addr().String()},
-		MaxMessageSize: maxMsgSize,
+	oldName := wave.NamespaceOf(m)
+	oldClient := cluster.NewClient(wave.ClientConfig{
 		Requests: []wave.RequestConfig{
 			{
 				Marshaller: m,
-				Messages:   []any{&wire1.Msg2{}},
+				Messages:   []any{&wire1.Msg1{}},
 			},
 		},
-	}
-	clientConfig2 := wave.ClientConfig{
-		Servers:        []string{ls2.Addr().String()},
-		MaxMessageSize: maxMsgSize,
+	})
+	newClient := cluster.NewClient(wave.ClientConfig{
+		Envelopes: true,
 		Requests: []wave.RequestConfig{
 			{
+				Namespace:  "orders",
+				Aliases:    []wire.Namespace{oldName},
 				Marshaller: m,
 				Messages:   []any{&wire1.Msg1{}},
 			},
 		},
+	})
+
+	requireT.NoError(oldClient.Send(ctx, &wire1.Msg1{
+		Value: "old",
+	}, m))
+	requireT.NoError(newClient.Send(ctx, &wire1.Msg1{
+		Value: "new",
+	}, wave.NamespacedMarshaller{Marshaller: m, Namespace: "orders"}))
+
+	// Client using the new name receives messages sent under both names, envelope carries the one used by sender.
+	received := map[wire.Namespace]any{}
+	for range 2 {
+		select {
+		case <-time.After(5 * time.Second):
+			requireT.FailNow("timeout waiting for messages", "received: %v", received)
+		case msg := <-newClient.Messages:
+			envelope := msg.(*wave.Envelope)
+			received[envelope.Header.Revision.Message.Namespace] = envelope.Message
+		}
 	}
+	requireT.Equal(map[wire.Namespace]any{
+		oldName:  &wire1.Msg1{Value: "old"},
+		"orders": &wire1.Msg1{Value: "new"},
+	}, received)
 
-	client1, recvCh1, err := wave.NewClient(clientConfig1)
-	requireT.NoError(err)
+	oldClient.ExpectMessages(
+		&wire1.Msg1{Value: "old"},
+	)
+}
 
-	client2, recvCh2, err := wave.NewClient(clientConfig2)
-	requireT.NoError(err)
+func TestSchemaFingerprints(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
 
-	group.Spawn("client1", parallel.Fail, client1.Run)
-	group.Spawn("client2", parallel.Fail, client2.Run)
-	group.Spawn("server1", parallel.Fail, func(ctx context.Context) error {
-		return wave.RunServer(ctx, ls1, wave.ServerConfig{
-			Servers:        servers,
-			MaxMessageSize: maxMsgSize,
-		})
-	})
-	group.Spawn("server2", parallel.Fail, func(ctx context.Context) error {
-		return wave.RunServer(ctx, ls2, wave.ServerConfig{
-			Servers:        servers,
-			MaxMessageSize: maxMsgSize,
-		})
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
+
+	metricsAddress := freeAddress(requireT)
+	m := wire1.NewMarshaller()
+	receiver := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+		MetricsAddress: metricsAddress,
 	})
+	sender := cluster.NewClient(wave.ClientConfig{})
 
-	requireT.NoError(client1.Send(&wire1.Msg1{
-		Value: "test",
-	}, m))
-	requireT.NoError(client2.Send(&wire1.Msg2{
+	// Msg2 is sent as Msg1, so its layout differs from the one expected by the receiver.
+	requireT.NoError(sender.Send(ctx, &wire1.Msg2{
 		Value: 1,
-	}, m))
+	}, wave.NamespacedMarshaller{Marshaller: swappedMarshaller{Marshaller: m}, Namespace: wave.NamespaceOf(m)}))
+	requireT.Eventually(func() bool {
+		return scrapeMetrics(metricsAddress)["wave_schema_mismatches_total"] == 1
+	}, 5*time.Second, 10*time.Millisecond)
 
-	testMsgs(ctx, requireT, recvCh1,
-		&wire1.Msg2{Value: 1},
-	)
-	testMsgs(ctx, requireT, recvCh2,
+	// Fingerprint doesn't depend on the package, so the same layout defined elsewhere is accepted.
+	m2 := wire2.NewMarshaller()
+	requireT.NoError(sender.Send(ctx, &wire2.Msg1{
+		Value: "test",
+	}, wave.NamespacedMarshaller{Marshaller: m2, Namespace: wave.NamespaceOf(m)}))
+
+	receiver.ExpectMessages(
 		&wire1.Msg1{Value: "test"},
 	)
 }
 
-func TestServerSynchronization(t *testing.T) {
+func TestWildcardSubscriptions(t *testing.T) {
 	requireT := require.New(t)
-
 	ctx := qa.NewContext(t)
-	group := qa.NewGroup(ctx, t)
-
-	defer func() {
-		group.Exit(nil)
-		requireT.NoError(group.Wait())
-	}()
-
-	ls1, err := net.Listen("tcp", "localhost:0")
-	requireT.NoError(err)
-	ls2, err := net.Listen("tcp", "localhost:0")
-	requireT.NoError(err)
 
-	servers := []string{
-		ls1.Addr().String(),
-		ls2.Addr().String(),
-	}
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
 
-	m := wire1.NewMarshaller()
-	clientConfig1 := wave.ClientConfig{
-		Servers:        []string{ls1.Addr().String()},
-		MaxMessageSize: maxMsgSize,
+	m1 := wire1.NewMarshaller()
+	m2 := wire2.NewMarshaller()
+	namespaceClient := cluster.NewClient(wave.ClientConfig{
 		Requests: []wave.RequestConfig{
 			{
-				Marshaller: m,
-				Messages:   []any{&wire1.Msg2{}},
+				Marshaller: m1,
 			},
 		},
-	}
-	clientConfig2 := wave.ClientConfig{
-		Servers:        []string{ls2.Addr().String()},
-		MaxMessageSize: maxMsgSize,
+	})
+	allClient := cluster.NewClient(wave.ClientConfig{
 		Requests: []wave.RequestConfig{
 			{
-				Marshaller: m,
+				Marshaller: m1,
 				Messages:   []any{&wire1.Msg1{}},
 			},
+			{
+				Namespace:  wire.AnyNamespace,
+				Marshaller: wave.RawMarshaller{},
+			},
 		},
-	}
-
-	client1, recvCh1, err := wave.NewClient(clientConfig1)
-	requireT.NoError(err)
-
-	client2, recvCh2, err := wave.NewClient(clientConfig2)
-	requireT.NoError(err)
-
-	group.Spawn("client1", parallel.Fail, client1.Run)
-	group.Spawn("client2", parallel.Fail, client2.Run)
-	group.Spawn("server1", parallel.Fail, func(ctx context.Context) error {
-		return wave.RunServer(ctx, ls1, wave.ServerConfig{
-			Servers:        servers,
-			MaxMessageSize: maxMsgSize,
-		})
 	})
+	sender := cluster.NewClient(wave.ClientConfig{})
 
-	requireT.NoError(client1.Send(&wire1.Msg1{
+	// Message type unknown to the marshaller is skipped.
+	requireT.NoError(sender.Send(ctx, &wave.RawMessage{
+		ID:      1000,
+		Content: []byte{0x01},
+	}, wave.RawMarshaller{Namespace: wave.NamespaceOf(m1)}))
+
+	requireT.NoError(sender.Send(ctx, &wire1.Msg1{
 		Value: "test",
-	}, m))
-	requireT.NoError(client2.Send(&wire1.Msg2{
+	}, m1))
+	requireT.NoError(sender.Send(ctx, &wire1.Msg2{
 		Value: 1,
-	}, m))
-
-	group.Spawn("server2", parallel.Fail, func(ctx context.Context) error {
-		return wave.RunServer(ctx, ls2, wave.ServerConfig{
-			Servers:        servers,
-			MaxMessageSize: maxMsgSize,
-		})
-	})
+	}, m1))
+	msg2 := &wire2.Msg2{
+		Value: 2,
+	}
+	requireT.NoError(sender.Send(ctx, msg2, m2))
 
-	testMsgs(ctx, requireT, recvCh1,
+	namespaceClient.ExpectMessages(
+		&wire1.Msg1{Value: "test"},
 		&wire1.Msg2{Value: 1},
 	)
-	testMsgs(ctx, requireT, recvCh2,
+
+	// Namespaces not requested explicitly are decoded by the marshaller of the wildcard request.
+	id, err := m2.ID(msg2)
+	requireT.NoError(err)
+	size, err := m2.Size(msg2)
+	requireT.NoError(err)
+	content := make([]byte, size)
+	_, _, err = m2.Marshal(msg2, content)
+	requireT.NoError(err)
+
+	allClient.ExpectMessages(
 		&wire1.Msg1{Value: "test"},
+		&wire1.Msg2{Value: 1},
+		&wave.RawMessage{ID: wire.MessageID(id), Content: content},
 	)
 }
 
-func TestOnlyLatestRevisionIsSynced(t *testing.T) {
+func TestSubscribeAndUnsubscribe(t *testing.T) {
 	requireT := require.New(t)
-
 	ctx := qa.NewContext(t)
-	group := qa.NewGroup(ctx, t)
-
-	defer func() {
-		group.Exit(nil)
-		requireT.NoError(group.Wait())
-	}()
 
-	ls1, err := net.Listen("tcp", "localhost:0")
-	requireT.NoError(err)
-	ls2, err := net.Listen("tcp", "localhost:0")
-	requireT.NoError(err)
-
-	servers := []string{
-		ls1.Addr().String(),
-		ls2.Addr().String(),
-	}
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
 
-	m := wire1.NewMarshaller()
-	clientConfig1 := wave.ClientConfig{
-		Servers:        []string{ls1.Addr().String()},
-		MaxMessageSize: maxMsgSize,
+	m1 := wire1.NewMarshaller()
+	m2 := wire2.NewMarshaller()
+	receiver := cluster.NewClient(wave.ClientConfig{
 		Requests: []wave.RequestConfig{
 			{
-				Marshaller: m,
+				Marshaller: m1,
 				Messages:   []any{&wire1.Msg2{}},
 			},
 		},
-	}
-	clientConfig2 := wave.ClientConfig{
-		Servers:        []string{ls2.Addr().String()},
-		MaxMessageSize: maxMsgSize,
+	})
+	sender := cluster.NewClient(wave.ClientConfig{
 		Requests: []wave.RequestConfig{
 			{
-				Marshaller: m,
-				Messages:   []any{&wire1.Msg1{}},
+				Marshaller: m2,
+				Messages:   []any{&wire2.Msg1{}},
+			},
+		},
+	})
+
+	requireT.NoError(sender.Send(ctx, &wire1.Msg1{
+		Value: "before",
+	}, m1))
+	requireT.NoError(sender.Send(ctx, &wire1.Msg2{
+		Value: 1,
+	}, m1))
+	receiver.ExpectMessages(
+		&wire1.Msg2{Value: 1},
+	)
+
+	// Current value of the newly requested message is sent by the server.
+	requireT.NoError(receiver.Subscribe(wave.RequestConfig{
+		Marshaller: m1,
+		Messages:   []any{&wire1.Msg1{}},
+	}))
+	receiver.ExpectMessages(
+		&wire1.Msg1{Value: "before"},
+	)
+
+	requireT.NoError(sender.Send(ctx, &wire1.Msg1{
+		Value: "after",
+	}, m1))
+	receiver.ExpectMessages(
+		&wire1.Msg1{Value: "after"},
+	)
+
+	requireT.NoError(receiver.Unsubscribe(wave.RequestConfig{
+		Marshaller: m1,
+		Messages:   []any{&wire1.Msg1{}},
+	}))
+
+	// Subscription is sent before the marker, so server applies it before sender receives the marker.
+	requireT.NoError(receiver.Send(ctx, &wire2.Msg1{
+		Value: "marker",
+	}, m2))
+	sender.ExpectMessages(
+		&wire2.Msg1{Value: "marker"},
+	)
+
+	requireT.NoError(sender.Send(ctx, &wire1.Msg1{
+		Value: "unsubscribed",
+	}, m1))
+	requireT.NoError(sender.Send(ctx, &wire1.Msg2{
+		Value: 2,
+	}, m1))
+	receiver.ExpectMessages(
+		&wire1.Msg2{Value: 2},
+	)
+}
+
+func TestSubscriptionIsKeptOnReconnect(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
+
+	m := wire1.NewMarshaller()
+	receiver := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg2{}},
+			},
+		},
+	})
+	sender := cluster.NewClient(wave.ClientConfig{})
+
+	requireT.NoError(sender.Send(ctx, &wire1.Msg2{
+		Value: 1,
+	}, m))
+	receiver.ExpectMessages(
+		&wire1.Msg2{Value: 1},
+	)
+
+	requireT.NoError(receiver.Subscribe(wave.RequestConfig{
+		Marshaller: m,
+		Messages:   []any{&wire1.Msg1{}},
+	}))
+	requireT.NoError(sender.Send(ctx, &wire1.Msg1{
+		Value: "first",
+	}, m))
+	receiver.ExpectMessages(
+		&wire1.Msg1{Value: "first"},
+	)
+
+	// Client sends current requests in hello when it reconnects.
+	cluster.Network().Cut(receiver.Name, cluster.Address(0))
+
+	requireT.NoError(sender.Send(ctx, &wire1.Msg1{
+		Value: "second",
+	}, m))
+	receiver.ExpectMessages(
+		&wire1.Msg1{Value: "second"},
+	)
+}
+
+func TestSubscriptions(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
+
+	m := wire1.NewMarshaller()
+	client := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+	})
+	sender := cluster.NewClient(wave.ClientConfig{})
+
+	requireT.NoError(sender.Send(ctx, &wire1.Msg1{
+		Value: "a",
+	}, m))
+	requireT.NoError(sender.Send(ctx, &wire1.Msg2{
+		Value: 1,
+	}, m))
+	client.ExpectMessages(
+		&wire1.Msg1{Value: "a"},
+	)
+
+	// Value known to the client is replayed by the client, the other one by the server.
+	sub1, err := client.NewSubscription(wave.SubscriptionConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+	})
+	requireT.NoError(err)
+	wavetest.ExpectMessages(t, sub1.Messages(),
+		&wire1.Msg1{Value: "a"},
+	)
+
+	sub2, err := client.NewSubscription(wave.SubscriptionConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg2{}},
+			},
+		},
+	})
+	requireT.NoError(err)
+	wavetest.ExpectMessages(t, sub2.Messages(),
+		&wire1.Msg2{Value: 1},
+	)
+
+	// Each subscriber receives only the requested messages.
+	requireT.NoError(sender.Send(ctx, &wire1.Msg1{
+		Value: "b",
+	}, m))
+	requireT.NoError(sender.Send(ctx, &wire1.Msg2{
+		Value: 2,
+	}, m))
+	wavetest.ExpectMessages(t, sub2.Messages(),
+		&wire1.Msg2{Value: 2},
+	)
+	wavetest.ExpectMessages(t, sub1.Messages(),
+		&wire1.Msg1{Value: "b"},
+	)
+	client.ExpectMessages(
+		&wire1.Msg1{Value: "b"},
+	)
+
+	sub2.Close()
+	_, ok := <-sub2.Messages()
+	requireT.False(ok)
+
+	requireT.NoError(sender.Send(ctx, &wire1.Msg1{
+		Value: "c",
+	}, m))
+	wavetest.ExpectMessages(t, sub1.Messages(),
+		&wire1.Msg1{Value: "c"},
+	)
+	client.ExpectMessages(
+		&wire1.Msg1{Value: "c"},
+	)
+}
+
+func TestResubscribedMessageIsReceivedAgain(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
+
+	m := wire1.NewMarshaller()
+	request := wave.RequestConfig{
+		Marshaller: m,
+		Messages:   []any{&wire1.Msg1{}},
+	}
+	receiver := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{request},
+	})
+	sender := cluster.NewClient(wave.ClientConfig{})
+
+	requireT.NoError(sender.Send(ctx, &wire1.Msg1{
+		Value: "a",
+	}, m))
+	receiver.ExpectMessages(
+		&wire1.Msg1{Value: "a"},
+	)
+
+	// Current value sent by the server again is not dropped as the one received before.
+	requireT.NoError(receiver.Unsubscribe(request))
+	requireT.NoError(receiver.Subscribe(request))
+	receiver.ExpectMessages(
+		&wire1.Msg1{Value: "a"},
+	)
+
+	requireT.NoError(sender.Send(ctx, &wire1.Msg1{
+		Value: "b",
+	}, m))
+	receiver.ExpectMessages(
+		&wire1.Msg1{Value: "b"},
+	)
+}
+
+func TestSubscribeReplaysMessageReceivedBySubscription(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
+
+	m := wire1.NewMarshaller()
+	request := wave.RequestConfig{
+		Marshaller: m,
+		Messages:   []any{&wire1.Msg1{}},
+	}
+	client := cluster.NewClient(wave.ClientConfig{})
+	sender := cluster.NewClient(wave.ClientConfig{})
+
+	sub, err := client.NewSubscription(wave.SubscriptionConfig{
+		Requests: []wave.RequestConfig{request},
+	})
+	requireT.NoError(err)
+
+	requireT.NoError(sender.Send(ctx, &wire1.Msg1{
+		Value: "a",
+	}, m))
+	wavetest.ExpectMessages(t, sub.Messages(),
+		&wire1.Msg1{Value: "a"},
+	)
+
+	// Server has sent the message already, so it is replayed by the client.
+	requireT.NoError(client.Subscribe(request))
+	client.ExpectMessages(
+		&wire1.Msg1{Value: "a"},
+	)
+
+	requireT.NoError(sender.Send(ctx, &wire1.Msg1{
+		Value: "b",
+	}, m))
+	client.ExpectMessages(
+		&wire1.Msg1{Value: "b"},
+	)
+	wavetest.ExpectMessages(t, sub.Messages(),
+		&wire1.Msg1{Value: "b"},
+	)
+}
+
+func TestSubscriptionsDoNotWaitForReceiver(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
+
+	m := wire1.NewMarshaller()
+	requests := []wave.RequestConfig{
+		{
+			Marshaller: m,
+			Messages:   []any{&wire1.Msg1{}},
+		},
+	}
+	client := cluster.NewClient(wave.ClientConfig{
+		Requests: requests,
+	})
+	sender := cluster.NewClient(wave.ClientConfig{})
+
+	stalled, err := client.NewSubscription(wave.SubscriptionConfig{
+		Requests:   requests,
+		BufferSize: 1,
+	})
+	requireT.NoError(err)
+
+	sub, err := client.NewSubscription(wave.SubscriptionConfig{
+		Requests: requests,
+	})
+	requireT.NoError(err)
+
+	// Stalled subscriber never reads, but the client and the other subscriber receive all the revisions.
+	const revisions = 20
+	for i := range revisions {
+		msg := &wire1.Msg1{Value: strconv.Itoa(i)}
+		requireT.NoError(sender.Send(ctx, msg, m))
+		client.ExpectMessages(msg)
+		wavetest.ExpectMessages(t, sub.Messages(), msg)
+	}
+	sub.Close()
+
+	// Revisions waiting for the stalled subscriber have been replaced by the latest one.
+	var values []int
+	for len(values) == 0 || values[len(values)-1] != revisions-1 {
+		select {
+		case <-time.After(5 * time.Second):
+			requireT.FailNow("timeout waiting for messages", "received: %v", values)
+		case msg := <-stalled.Messages():
+			value, err := strconv.Atoi(msg.(*wire1.Msg1).Value)
+			requireT.NoError(err)
+			values = append(values, value)
+		}
+	}
+	requireT.Equal(0, values[0])
+	requireT.True(sort.IntsAreSorted(values))
+	requireT.Less(len(values), revisions)
+	stalled.Close()
+
+	requireT.NoError(sender.Send(ctx, &wire1.Msg1{Value: "c"}, m))
+	client.ExpectMessages(&wire1.Msg1{Value: "c"})
+}
+
+func TestServerSendsMessagesToNewClient(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
+
+	m := wire1.NewMarshaller()
+	clientConfig := wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}, &wire1.Msg2{}},
+			},
+		},
+	}
+
+	client1 := cluster.NewClient(clientConfig)
+
+	requireT.NoError(client1.Send(ctx, &wire1.Msg1{
+		Value: "test1",
+	}, m))
+
+	client1.ExpectMessages(
+		&wire1.Msg1{Value: "test1"},
+	)
+
+	client2 := cluster.NewClient(clientConfig)
+
+	client1.ExpectMessages()
+	client2.ExpectMessages(
+		&wire1.Msg1{Value: "test1"},
+	)
+
+	requireT.NoError(client1.Send(ctx, &wire1.Msg1{
+		Value: "test2",
+	}, m))
+
+	client1.ExpectMessages(
+		&wire1.Msg1{Value: "test2"},
+	)
+	client2.ExpectMessages(
+		&wire1.Msg1{Value: "test2"},
+	)
+}
+
+func TestServersExchangeMessages(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{
+		Servers:        2,
+		MaxMessageSize: maxMsgSize,
+	})
+
+	// Clients are pinned to different servers, so messages must be exchanged between servers.
+	m := wire1.NewMarshaller()
+	client1 := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg2{}},
+			},
+		},
+	}, 0)
+	client2 := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+	}, 1)
+
+	requireT.NoError(client1.Send(ctx, &wire1.Msg1{
+		Value: "test",
+	}, m))
+	requireT.NoError(client2.Send(ctx, &wire1.Msg2{
+		Value: 1,
+	}, m))
+
+	client1.ExpectMessages(
+		&wire1.Msg2{Value: 1},
+	)
+	client2.ExpectMessages(
+		&wire1.Msg1{Value: "test"},
+	)
+
+	for i, client := range []*wavetest.Client{client1, client2} {
+		links := client.Links()
+		requireT.Len(links, 1)
+		requireT.Equal(cluster.Address(i), links[0].Address)
+	}
+}
+
+func TestClientWithDisabledDiscovery(t *testing.T) {
+	requireT := require.New(t)
+
+	const gossipInterval = 100 * time.Millisecond
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{
+		Servers:        2,
+		MaxMessageSize: maxMsgSize,
+		Server: func(i int, config *wave.ServerConfig) {
+			config.GossipInterval = gossipInterval
+		},
+	})
+
+	pinned := cluster.NewClient(wave.ClientConfig{
+		Servers:          []string{cluster.Address(0)},
+		DisableDiscovery: true,
+	})
+	discovering := cluster.NewClient(wave.ClientConfig{
+		Servers: []string{cluster.Address(0)},
+	})
+
+	requireT.Eventually(func() bool {
+		return len(discovering.Links()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Give server time to advertise members to the pinned client too.
+	time.Sleep(5 * gossipInterval)
+
+	links := pinned.Links()
+	requireT.Len(links, 1)
+	requireT.Equal(cluster.Address(0), links[0].Address)
+}
+
+func TestClientDropsFailingSeed(t *testing.T) {
+	requireT := require.New(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
+
+	lsDead, err := net.Listen("tcp", "localhost:0")
+	requireT.NoError(err)
+	requireT.NoError(lsDead.Close())
+
+	client := cluster.NewClient(wave.ClientConfig{
+		Servers: []string{lsDead.Addr().String(), cluster.Address(0)},
+		Reconnect: wave.ReconnectPolicy{
+			InitialDelay: 10 * time.Millisecond,
+			MaxDelay:     10 * time.Millisecond,
+		},
+	})
+
+	// Seed is dropped once the cluster is known and seed keeps failing.
+	requireT.Eventually(func() bool {
+		links := client.Links()
+		return len(links) == 1 && links[0].Address == cluster.Address(0) && links[0].Connected
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestServerSynchronization(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{
+		Servers:        2,
+		MaxMessageSize: maxMsgSize,
+	})
+
+	// Server is started again after messages are sent, so it receives them while synchronizing with the other one.
+	cluster.StopServer(1)
+
+	m := wire1.NewMarshaller()
+	client1 := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg2{}},
+			},
+		},
+	}, 0)
+	client2 := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+	}, 1)
+
+	requireT.NoError(client1.Send(ctx, &wire1.Msg1{
+		Value: "test",
+	}, m))
+	requireT.NoError(client2.Send(ctx, &wire1.Msg2{
+		Value: 1,
+	}, m))
+
+	cluster.StartServer(1)
+
+	client1.ExpectMessages(
+		&wire1.Msg2{Value: 1},
+	)
+	client2.ExpectMessages(
+		&wire1.Msg1{Value: "test"},
+	)
+}
+
+func TestOnlyLatestRevisionIsSynced(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{
+		Servers:        2,
+		MaxMessageSize: maxMsgSize,
+	})
+	cluster.StopServer(1)
+
+	m := wire1.NewMarshaller()
+	client1 := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg2{}},
+			},
+		},
+	}, 0)
+	client2 := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+	}, 1)
+
+	requireT.NoError(client1.Send(ctx, &wire1.Msg1{
+		Value: "test1",
+	}, m))
+	requireT.NoError(client1.Send(ctx, &wire1.Msg1{
+		Value: "test2",
+	}, m))
+
+	cluster.StartServer(1)
+
+	client2.ExpectMessages(
+		&wire1.Msg1{Value: "test2"},
+	)
+	client1.ExpectMessages()
+}
+
+func TestSameMessagesFromTwoSourcesAreReceived(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
+
+	m := wire1.NewMarshaller()
+	client1 := cluster.NewClient(wave.ClientConfig{})
+	client2 := cluster.NewClient(wave.ClientConfig{})
+
+	requireT.NoError(client1.Send(ctx, &wire1.Msg1{
+		Value: "test1",
+	}, m))
+	requireT.NoError(client2.Send(ctx, &wire1.Msg1{
+		Value: "test2",
+	}, m))
+
+	client3 := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+	})
+
+	client1.ExpectMessages()
+	client2.ExpectMessages()
+	client3.ExpectMessages(
+		&wire1.Msg1{Value: "test1"},
+		&wire1.Msg1{Value: "test2"},
+	)
+}
+
+func TestSameMessageReceivedTwiceIsIgnored(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{
+		Servers:        2,
+		MaxMessageSize: maxMsgSize,
+	})
+
+	m := wire1.NewMarshaller()
+	client1 := cluster.NewClient(wave.ClientConfig{})
+	client2 := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+	})
+
+	requireT.NoError(client1.Send(ctx, &wire1.Msg1{
+		Value: "test",
+	}, m))
+
+	client2.ExpectMessages(
+		&wire1.Msg1{Value: "test"},
+	)
+	client1.ExpectMessages()
+}
+
+func TestServersExchangeOnlyMissingRevisionsOnReconnect(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{
+		Servers:        2,
+		MaxMessageSize: maxMsgSize,
+	})
+	network := cluster.Network()
+
+	m := wire1.NewMarshaller()
+	receiver := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+	}, 1)
+
+	const numOfSenders = 5
+	value := strings.Repeat("a", 800)
+
+	expected := make([]any, 0, numOfSenders)
+	senders := make([]*wavetest.Client, 0, numOfSenders)
+	for i := range numOfSenders {
+		sender := cluster.NewClient(wave.ClientConfig{}, 0)
+
+		msg := &wire1.Msg1{Value: value + string(rune('a'+i))}
+		requireT.NoError(sender.Send(ctx, msg, m))
+
+		expected = append(expected, msg)
+		senders = append(senders, sender)
+	}
+
+	receiver.ExpectMessages(expected...)
+
+	received := network.BytesSent(cluster.Address(0), cluster.Address(1))
+	network.Cut(cluster.Address(0), cluster.Address(1))
+
+	requireT.NoError(senders[0].Send(ctx, &wire1.Msg1{
+		Value: "test",
+	}, m))
+
+	receiver.ExpectMessages(
+		&wire1.Msg1{Value: "test"},
+	)
+
+	requireT.Less(network.BytesSent(cluster.Address(0), cluster.Address(1))-received, uint64(len(value)))
+}
+
+func TestClientResumesFromKnownRevisions(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
+	network := cluster.Network()
+
+	m1 := wire1.NewMarshaller()
+	m2 := wire2.NewMarshaller()
+	client1 := cluster.NewClient(wave.ClientConfig{})
+	client2 := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m1,
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+	})
+
+	value := strings.Repeat("a", 800)
+	requireT.NoError(client1.Send(ctx, &wire1.Msg1{
+		Value: value,
+	}, m1))
+	requireT.NoError(client2.Send(ctx, &wire2.Msg1{
+		Value: value,
+	}, m2))
+
+	client2.ExpectMessages(
+		&wire1.Msg1{Value: value},
+	)
+
+	// Wait until message sent by client2 reaches the server.
+	client3 := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m2,
+				Messages:   []any{&wire2.Msg1{}},
+			},
+		},
+	})
+
+	client3.ExpectMessages(
+		&wire2.Msg1{Value: value},
+	)
+
+	sent := network.BytesSent(client2.Name, cluster.Address(0))
+	received := network.BytesSent(cluster.Address(0), client2.Name)
+	network.Cut(client2.Name, cluster.Address(0))
+
+	requireT.NoError(client1.Send(ctx, &wire1.Msg1{
+		Value: "test",
+	}, m1))
+
+	client2.ExpectMessages(
+		&wire1.Msg1{Value: "test"},
+	)
+
+	requireT.NoError(client2.Send(ctx, &wire2.Msg1{
+		Value: "test",
+	}, m2))
+
+	client3.ExpectMessages(
+		&wire2.Msg1{Value: "test"},
+	)
+
+	requireT.Less(network.BytesSent(client2.Name, cluster.Address(0))-sent, uint64(len(value)))
+	requireT.Less(network.BytesSent(cluster.Address(0), client2.Name)-received, uint64(len(value)))
+}
+
+func TestServersDiscoverMembersThroughGossip(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{
+		Servers:        3,
+		MaxMessageSize: maxMsgSize,
+		Server: func(i int, config *wave.ServerConfig) {
+			// Each server knows only the previous one, so others are discovered through gossip.
+			if i == 0 {
+				config.Servers = nil
+			} else {
+				config.Servers = config.Servers[i-1 : i]
+			}
+			config.GossipInterval = 100 * time.Millisecond
+			config.SuspicionTimeout = 500 * time.Millisecond
+			config.RemovalTimeout = time.Second
+		},
+	})
+
+	m := wire1.NewMarshaller()
+	client1 := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+	}, 0)
+	client3 := cluster.NewClient(wave.ClientConfig{}, 2)
+
+	addresses := func(server *wave.Server) []string {
+		members := server.Members()
+		addrs := make([]string, 0, len(members))
+		for _, m := range members {
+			addrs = append(addrs, m.Address)
+		}
+		return addrs
+	}
+
+	for i := range 3 {
+		requireT.Eventually(func() bool {
+			return assert.ObjectsAreEqual(sortedStrings([]string{
+				cluster.Address(0),
+				cluster.Address(1),
+				cluster.Address(2),
+			}), sortedStrings(addresses(cluster.Server(i))))
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	// Server1 and server3 communicate directly after server2 stops.
+	cluster.StopServer(1)
+
+	requireT.NoError(client3.Send(ctx, &wire1.Msg1{
+		Value: "test",
+	}, m))
+
+	client1.ExpectMessages(
+		&wire1.Msg1{Value: "test"},
+	)
+
+	for _, i := range []int{0, 2} {
+		requireT.Eventually(func() bool {
+			return assert.ObjectsAreEqual(sortedStrings([]string{
+				cluster.Address(0),
+				cluster.Address(2),
+			}), sortedStrings(addresses(cluster.Server(i))))
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestClientDiscoversServers(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	const gossipInterval = 100 * time.Millisecond
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{
+		Servers:        2,
+		MaxMessageSize: maxMsgSize,
+		Server: func(i int, config *wave.ServerConfig) {
+			config.GossipInterval = gossipInterval
+		},
+	})
+
+	m := wire1.NewMarshaller()
+	client1 := cluster.NewClient(wave.ClientConfig{
+		Servers: []string{cluster.Address(0)},
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+	})
+	client2 := cluster.NewClient(wave.ClientConfig{}, 1)
+
+	requireT.Eventually(func() bool {
+		return len(cluster.Server(0).Members()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Give server1 time to advertise new member to the client.
+	time.Sleep(5 * gossipInterval)
+
+	// Client1 receives message through server2 it discovered.
+	cluster.StopServer(0)
+
+	requireT.NoError(client2.Send(ctx, &wire1.Msg1{
+		Value: "test",
+	}, m))
+
+	client1.ExpectMessages(
+		&wire1.Msg1{Value: "test"},
+	)
+}
+
+func TestClientFailsOverToOtherServer(t *testing.T) {
+	for name, selection := range map[string]wave.ServerSelection{
+		"random":  wave.SelectRandom,
+		"latency": wave.SelectLatency,
+	} {
+		t.Run(name, func(t *testing.T) {
+			requireT := require.New(t)
+			ctx := qa.NewContext(t)
+
+			cluster := wavetest.NewCluster(t, wavetest.Config{
+				Servers:        2,
+				MaxMessageSize: maxMsgSize,
+			})
+
+			m := wire1.NewMarshaller()
+			client1 := cluster.NewClient(wave.ClientConfig{
+				Requests: []wave.RequestConfig{
+					{
+						Marshaller: m,
+						Messages:   []any{&wire1.Msg1{}},
+					},
+				},
+				Connections: 1,
+				Selection:   selection,
+			})
+			client2 := cluster.NewClient(wave.ClientConfig{})
+
+			requireT.NoError(client2.Send(ctx, &wire1.Msg1{
+				Value: "test1",
+			}, m))
+			client1.ExpectMessages(
+				&wire1.Msg1{Value: "test1"},
+			)
+
+			// Client1 is connected to one of the servers. Each of them is stopped in turn.
+			cluster.StopServer(0)
+
+			requireT.NoError(client2.Send(ctx, &wire1.Msg1{
+				Value: "test2",
+			}, m))
+			client1.ExpectMessages(
+				&wire1.Msg1{Value: "test2"},
+			)
+
+			cluster.StartServer(0)
+			cluster.StopServer(1)
+
+			requireT.NoError(client2.Send(ctx, &wire1.Msg1{
+				Value: "test3",
+			}, m))
+			client1.ExpectMessages(
+				&wire1.Msg1{Value: "test3"},
+			)
+		})
+	}
+}
+
+func TestReconnectPolicyOpensCircuit(t *testing.T) {
+	requireT := require.New(t)
+
+	ctx := qa.NewContext(t)
+	group := qa.NewGroup(ctx, t)
+
+	defer func() {
+		group.Exit(nil)
+		requireT.NoError(group.Wait())
+	}()
+
+	ls, err := net.Listen("tcp", "localhost:0")
+	requireT.NoError(err)
+	lsDead, err := net.Listen("tcp", "localhost:0")
+	requireT.NoError(err)
+	requireT.NoError(lsDead.Close())
+
+	// Discovery is disabled, so failing seed is not dropped.
+	client, _, err := wave.NewClient(wave.ClientConfig{
+		Servers:          []string{ls.Addr().String(), lsDead.Addr().String()},
+		MaxMessageSize:   maxMsgSize,
+		DisableDiscovery: true,
+		Reconnect: wave.ReconnectPolicy{
+			InitialDelay:      10 * time.Millisecond,
+			MaxDelay:          50 * time.Millisecond,
+			CircuitOpenAfter:  3,
+			CircuitOpenPeriod: time.Hour,
+		},
+	})
+	requireT.NoError(err)
+
+	group.Spawn("client", parallel.Fail, client.Run)
+	group.Spawn("server", parallel.Fail, func(ctx context.Context) error {
+		return wave.RunServer(ctx, ls, wave.ServerConfig{
+			MaxMessageSize: maxMsgSize,
+		})
+	})
+
+	linkStates := func() map[string]wave.LinkState {
+		links := map[string]wave.LinkState{}
+		for _, l := range client.Links() {
+			links[l.Address] = l
+		}
+		return links
+	}
+
+	requireT.Eventually(func() bool {
+		links := linkStates()
+		return links[ls.Addr().String()].Connected && links[lsDead.Addr().String()].CircuitOpen
+	}, 5*time.Second, 10*time.Millisecond)
+
+	links := linkStates()
+	dead := links[lsDead.Addr().String()]
+	requireT.False(dead.Connected)
+	requireT.EqualValues(3, dead.Attempts)
+	requireT.Equal(3, dead.ConsecutiveFailures)
+	requireT.NotEmpty(dead.LastError)
+	requireT.Greater(time.Until(dead.NextAttempt), 30*time.Minute)
+
+	live := links[ls.Addr().String()]
+	requireT.EqualValues(1, live.Attempts)
+	requireT.Zero(live.ConsecutiveFailures)
+}
+
+func TestClientConnectsToServerWithManyAddressesOnce(t *testing.T) {
+	requireT := require.New(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
+
+	// The same server is reachable under both addresses.
+	address := cluster.Address(0)
+	alias := strings.Replace(address, "127.0.0.1", "localhost", 1)
+	requireT.NotEqual(address, alias)
+
+	client := cluster.NewClient(wave.ClientConfig{
+		Servers: []string{address, alias},
+		Reconnect: wave.ReconnectPolicy{
+			InitialDelay:      10 * time.Millisecond,
+			CircuitOpenAfter:  1,
+			CircuitOpenPeriod: time.Hour,
+		},
+	})
+
+	// Address rejected as duplicate is not dialed anymore and the other one is not considered failed.
+	requireT.Eventually(func() bool {
+		links := client.Links()
+		return len(links) == 1 && links[0].Connected
+	}, 5*time.Second, 10*time.Millisecond)
+
+	links := client.Links()
+	requireT.EqualValues(1, links[0].Attempts)
+	requireT.Zero(links[0].ConsecutiveFailures)
+	requireT.False(links[0].CircuitOpen)
+	requireT.Len(client.Status().Servers, 1)
+}
+
+func TestClientEventsAndStatus(t *testing.T) {
+	requireT := require.New(t)
+
+	ctx := qa.NewContext(t)
+	group := qa.NewGroup(ctx, t)
+
+	defer func() {
+		group.Exit(nil)
+		requireT.NoError(group.Wait())
+	}()
+
+	ls, err := net.Listen("tcp", "localhost:0")
+	requireT.NoError(err)
+
+	servers := []string{
+		ls.Addr().String(),
+	}
+
+	m := wire1.NewMarshaller()
+	clientConfig := wave.ClientConfig{
+		Servers:        servers,
+		MaxMessageSize: maxMsgSize,
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages: []any{
+					&wire1.Msg1{},
+				},
+			},
+		},
+		Reconnect: wave.ReconnectPolicy{
+			InitialDelay: time.Hour,
+		},
+	}
+
+	client1, recvCh1, err := wave.NewClient(clientConfig)
+	requireT.NoError(err)
+
+	serverCtx, serverCancel := context.WithCancel(ctx)
+	group.Spawn("server", parallel.Continue, func(_ context.Context) error {
+		err := wave.RunServer(serverCtx, ls, wave.ServerConfig{
+			MaxMessageSize: maxMsgSize,
+		})
+		if serverCtx.Err() != nil {
+			return nil
+		}
+		return err
+	})
+	group.Spawn("client1", parallel.Fail, client1.Run)
+
+	requireT.NoError(client1.Send(ctx, &wire1.Msg1{
+		Value: "test",
+	}, m))
+	wavetest.ExpectMessages(t, recvCh1,
+		&wire1.Msg1{Value: "test"},
+	)
+
+	client2, recvCh2, err := wave.NewClient(clientConfig)
+	requireT.NoError(err)
+
+	requireT.Empty(client2.Status().Servers)
+
+	group.Spawn("client2", parallel.Fail, client2.Run)
+
+	nextEvent := func() wave.Event {
+		select {
+		case <-ctx.Done():
+			requireT.FailNow("timeout")
+		case e := <-client2.Events():
+			requireT.Equal(ls.Addr().String(), e.Address)
+			return e
+		}
+		return wave.Event{}
+	}
+
+	requireT.Equal(wave.EventConnected, nextEvent().Type)
+	e := nextEvent()
+	requireT.Equal(wave.EventHandshakeCompleted, e.Type)
+	serverID := e.ServerID
+	requireT.NotZero(serverID)
+	e = nextEvent()
+	requireT.Equal(wave.EventSyncCompleted, e.Type)
+	requireT.Equal(serverID, e.ServerID)
+
+	// Revision stored on the server has been delivered before sync completed.
+	requireT.Len(recvCh2, 1)
+	wavetest.ExpectMessages(t, recvCh2,
+		&wire1.Msg1{Value: "test"},
+	)
+
+	status := client2.Status()
+	requireT.Equal(1, status.Connected)
+	requireT.Equal(1, status.Synced)
+	requireT.Len(status.Servers, 1)
+	requireT.Equal(ls.Addr().String(), status.Servers[0].Address)
+	requireT.Equal(serverID, status.Servers[0].ServerID)
+	requireT.True(status.Servers[0].Connected)
+	requireT.True(status.Servers[0].Synced)
+
+	serverCancel()
+
+	e = nextEvent()
+	requireT.Equal(wave.EventDisconnected, e.Type)
+	requireT.Error(e.Err)
+	status = client2.Status()
+	status = client2.Status()
+	requireT.Zero(status.Connected)
+	requireT.Zero(status.Synced)
+	requireT.Len(status.Servers, 1)
+	requireT.False(status.Servers[0].Connected)
+	requireT.NotEmpty(status.Servers[0].LastError)
+}
+
+func TestServerAdminAPI(t *testing.T) {
+	requireT := require.New(t)
+
+	ctx := qa.NewContext(t)
+	group := qa.NewGroup(ctx, t)
+
+	defer func() {
+		group.Exit(nil)
+		requireT.NoError(group.Wait())
+	}()
+
+	ls, err := net.Listen("tcp", "localhost:0")
+	requireT.NoError(err)
+	adminAddress := freeAddress(requireT)
+
+	m := wire1.NewMarshaller()
+	client, recvCh, err := wave.NewClient(wave.ClientConfig{
+		Servers:        []string{ls.Addr().String()},
+		MaxMessageSize: maxMsgSize,
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages: []any{
+					&wire1.Msg1{},
+				},
+			},
+		},
+	})
+	requireT.NoError(err)
+
+	server, err := wave.NewServer(wave.ServerConfig{
+		MaxMessageSize: maxMsgSize,
+		AdminAddress:   adminAddress,
+	})
+	requireT.NoError(err)
+
+	group.Spawn("client", parallel.Fail, client.Run)
+	group.Spawn("server", parallel.Fail, func(ctx context.Context) error {
+		return server.Run(ctx, ls)
+	})
+
+	requireT.NoError(client.Send(ctx, &wire1.Msg1{
+		Value: "test",
+	}, m))
+	wavetest.ExpectMessages(t, recvCh,
+		&wire1.Msg1{Value: "test"},
+	)
+
+	get := func(path string, v any) {
+		var resp *http.Response
+		requireT.Eventually(func() bool {
+			var err error
+			resp, err = http.Get("http://" + adminAddress + path)
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+		defer resp.Body.Close()
+
+		requireT.Equal(http.StatusOK, resp.StatusCode)
+		requireT.NoError(json.NewDecoder(resp.Body).Decode(v))
+	}
+
+	var peers []wave.Peer
+	get("/peers", &peers)
+	requireT.Len(peers, 1)
+	requireT.Equal(wave.RoleClient, peers[0].Role)
+	requireT.NotEmpty(peers[0].RemoteAddress)
+	requireT.Len(peers[0].Requests, 1)
+	namespace := peers[0].Requests[0].Namespace
+
+	var revs map[wire.Namespace][]wave.StoredRevision
+	get("/revisions", &revs)
+	requireT.Len(revs, 1)
+	requireT.Len(revs[namespace], 1)
+	requireT.Equal(peers[0].Requests[0].MessageID, revs[namespace][0].MessageID)
+	requireT.Equal(peers[0].ID, revs[namespace][0].Sender)
+	requireT.Positive(revs[namespace][0].Size)
+
+	var queues []wave.PeerQueue
+	get("/queues", &queues)
+	requireT.Len(queues, 1)
+	requireT.Equal(peers[0].ID, queues[0].PeerID)
+	requireT.Positive(queues[0].Capacity)
+
+	var members []wave.Member
+	get("/members", &members)
+	requireT.Equal(server.Members(), members)
+
+	resp, err := http.Post("http://"+adminAddress+"/peers", "application/json", nil)
+	requireT.NoError(err)
+	requireT.NoError(resp.Body.Close())
+	requireT.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestMetrics(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	serverMetricsAddress := freeAddress(requireT)
+	clientMetricsAddress := freeAddress(requireT)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{
+		Servers:        2,
+		MaxMessageSize: maxMsgSize,
+		Server: func(i int, config *wave.ServerConfig) {
+			if i == 0 {
+				config.MetricsAddress = serverMetricsAddress
+			}
+		},
+	})
+
+	m := wire1.NewMarshaller()
+	client := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+		MetricsAddress: clientMetricsAddress,
+	})
+
+	requireT.NoError(client.Send(ctx, &wire1.Msg1{
+		Value: "test",
+	}, m))
+	client.ExpectMessages(
+		&wire1.Msg1{Value: "test"},
+	)
+
+	// The same revision is delivered by both servers, so one of them is dropped by the client.
+	var clientMetrics map[string]float64
+	requireT.Eventually(func() bool {
+		clientMetrics = scrapeMetrics(clientMetricsAddress)
+		return clientMetrics[`wave_connections{role="server"}`] == 2 && clientMetrics["wave_dedup_drops_total"] >= 1
+	}, 5*time.Second, 10*time.Millisecond)
+	requireT.GreaterOrEqual(clientMetrics["wave_reconnect_attempts_total"], float64(2))
+	requireT.Positive(clientMetrics["wave_bytes_sent_total"])
+	requireT.Positive(clientMetrics["wave_frames_received_total"])
+	requireT.Equal(float64(1), clientMetrics[`wave_revisions{namespace="github.com/outofforest/wave/test/wire1.Marshaller"}`])
+
+	var serverMetrics map[string]float64
+	requireT.Eventually(func() bool {
+		serverMetrics = scrapeMetrics(serverMetricsAddress)
+		return serverMetrics[`wave_connections{role="server"}`] == 1 &&
+			serverMetrics[`wave_revisions{namespace="github.com/outofforest/wave/test/wire1.Marshaller"}`] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	requireT.Equal(float64(1), serverMetrics[`wave_connections{role="client"}`])
+	requireT.Positive(serverMetrics["wave_bytes_received_total"])
+	requireT.Positive(serverMetrics["wave_frames_sent_total"])
+	requireT.Positive(serverMetrics["wave_broadcast_duration_seconds_count"])
+	requireT.Positive(serverMetrics["wave_replay_revisions_count"])
+}
+
+func TestTraceContextIsPropagated(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	recorder := &spanRecorder{}
+	cluster := wavetest.NewCluster(t, wavetest.Config{
+		Servers:        2,
+		MaxMessageSize: maxMsgSize,
+		Server: func(i int, config *wave.ServerConfig) {
+			config.Tracer = recorder
+		},
+	})
+
+	m := wire1.NewMarshaller()
+	client1 := cluster.NewClient(wave.ClientConfig{
+		Tracer: recorder,
+	}, 0)
+	client2 := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+		Tracer:    recorder,
+		Envelopes: true,
+	}, 1)
+
+	parent, err := wave.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	requireT.NoError(err)
+	requireT.Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", parent.String())
+
+	requireT.NoError(client1.SendTraced(ctx, &wire1.Msg1{
+		Value: "test",
+	}, m, parent))
+
+	var envelope *wave.Envelope
+	select {
+	case <-ctx.Done():
+		requireT.FailNow("timeout")
+	case msg := <-client2.Messages:
+		envelope = msg.(*wave.Envelope)
+	}
+	requireT.Equal(&wire1.Msg1{Value: "test"}, envelope.Message)
+	requireT.Equal(parent.TraceID, envelope.Header.Trace.TraceID)
+	requireT.Equal(parent.Flags, envelope.Header.Trace.Flags)
+
+	sendSpans := recorder.Spans(wave.SpanSend)
+	requireT.Len(sendSpans, 1)
+	sendSpan := sendSpans[0]
+	requireT.Equal(parent.SpanID, sendSpan.ParentID)
+	requireT.Equal(sendSpan.Trace, envelope.Header.Trace)
+	requireT.Equal(envelope.Header.Revision, sendSpan.Revision)
+
+	// Servers don't modify header, so all the spans are children of the publisher's span.
+	requireT.Eventually(func() bool {
+		peers := map[wire.PeerID]struct{}{}
+		for _, span := range recorder.Spans(wave.SpanServerReceive) {
+			if span.Trace.TraceID == parent.TraceID && span.ParentID == sendSpan.Trace.SpanID {
+				peers[span.PeerID] = struct{}{}
+			}
+		}
+		return len(peers) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	var receiveSpans []wave.Span
+	requireT.Eventually(func() bool {
+		receiveSpans = recorder.Spans(wave.SpanClientReceive)
+		return len(receiveSpans) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	requireT.Equal(parent.TraceID, receiveSpans[0].Trace.TraceID)
+	requireT.Equal(sendSpan.Trace.SpanID, receiveSpans[0].ParentID)
+	requireT.Equal(sendSpan.PeerID, receiveSpans[0].Sender)
+
+	// Messages sent without parent start new traces.
+	requireT.NoError(client1.Send(ctx, &wire1.Msg1{
+		Value: "test2",
+	}, m))
+
+	select {
+	case <-ctx.Done():
+		requireT.FailNow("timeout")
+	case msg := <-client2.Messages:
+		envelope = msg.(*wave.Envelope)
+	}
+	requireT.Equal(&wire1.Msg1{Value: "test2"}, envelope.Message)
+	requireT.False(envelope.Header.Trace.IsZero())
+	requireT.NotEqual(parent.TraceID, envelope.Header.Trace.TraceID)
+}
+
+func TestSpansAreNotRecordedForDuplicates(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	recorder := &spanRecorder{}
+	metricsAddresses := []string{freeAddress(requireT), freeAddress(requireT)}
+	cluster := wavetest.NewCluster(t, wavetest.Config{
+		Servers:        2,
+		MaxMessageSize: maxMsgSize,
+		Server: func(i int, config *wave.ServerConfig) {
+			config.Tracer = recorder
+			config.MetricsAddress = metricsAddresses[i]
+		},
+	})
+
+	m := wire1.NewMarshaller()
+	client := cluster.NewClient(wave.ClientConfig{}, 0)
+
+	// Revision comes back to the server it has been received by first and it is dropped as duplicate.
+	// Revision sent before servers are connected is synchronized instead, so new ones are sent until then.
+	var sent int
+	requireT.Eventually(func() bool {
+		sent++
+		requireT.NoError(client.SendTraced(ctx, &wire1.Msg2{
+			Value: uint64(sent),
+		}, m, wire.TraceContext{}))
+
+		for _, address := range metricsAddresses {
+			if scrapeMetrics(address)["wave_dedup_drops_total"] >= 1 {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 100*time.Millisecond)
+
+	requireT.Eventually(func() bool {
+		spans := map[wire.PeerID]int{}
+		for _, span := range recorder.Spans(wave.SpanServerReceive) {
+			spans[span.PeerID]++
+		}
+		return spans[cluster.Server(0).ID()] == sent && spans[cluster.Server(1).ID()] == sent
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestWavectlPrintsUsageToOutput(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	out := &bytes.Buffer{}
+	requireT.Error(wavectl.Run(ctx, nil, out))
+	requireT.Contains(out.String(), "Usage: wavectl")
+
+	out.Reset()
+	requireT.Error(wavectl.Run(ctx, []string{"dump", "--unknown"}, out))
+	requireT.Contains(out.String(), "unknown flag: --unknown")
+	requireT.Contains(out.String(), "--servers")
+
+	out.Reset()
+	requireT.Error(wavectl.Run(ctx, []string{"publish", "--help"}, out))
+	requireT.Contains(out.String(), "--namespace")
+}
+
+func TestWavectlPublishAndDump(t *testing.T) {
+	requireT := require.New(t)
+
+	ctx := qa.NewContext(t)
+	group := qa.NewGroup(ctx, t)
+
+	defer func() {
+		group.Exit(nil)
+		requireT.NoError(group.Wait())
+	}()
+
+	ls, err := net.Listen("tcp", "localhost:0")
+	requireT.NoError(err)
+	adminAddress := freeAddress(requireT)
+
+	server, err := wave.NewServer(wave.ServerConfig{
+		MaxMessageSize: maxMsgSize,
+		AdminAddress:   adminAddress,
+	})
+	requireT.NoError(err)
+
+	group.Spawn("server", parallel.Fail, func(ctx context.Context) error {
+		return server.Run(ctx, ls)
+	})
+
+	m := wire1.NewMarshaller()
+	msg := &wire1.Msg1{Value: "test"}
+	msgID, err := m.ID(msg)
+	requireT.NoError(err)
+	buf := make([]byte, maxMsgSize)
+	_, size, err := m.Marshal(msg, buf)
+	requireT.NoError(err)
+	content := hex.EncodeToString(buf[:size])
+	namespace := string(wave.NamespaceOf(m))
+
+	servers := "--servers=" + ls.Addr().String()
+	out := &bytes.Buffer{}
+	requireT.NoError(wavectl.Run(ctx, []string{
+		"publish", servers, "--namespace=" + namespace, "--message=" + strconv.FormatUint(msgID, 10),
+		"--content=" + content,
+	}, out))
+	requireT.Contains(out.String(), "namespace="+namespace)
+	requireT.Contains(out.String(), "hex="+content)
+
+	requireT.Eventually(func() bool {
+		_, err := http.Get("http://" + adminAddress + "/revisions")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	out.Reset()
+	requireT.NoError(wavectl.Run(ctx, []string{"dump", servers, "--admin=" + adminAddress}, out, m))
+	requireT.Equal(1, strings.Count(out.String(), "\n"))
+	requireT.Contains(out.String(), fmt.Sprintf("type=%T {\"Value\":\"test\"}", msg))
+
+	out.Reset()
+	requireT.NoError(wavectl.Run(ctx, []string{"dump", servers, "--hex"}, out, m))
+	requireT.Equal(1, strings.Count(out.String(), "\n"))
+	requireT.Contains(out.String(), "hex="+content)
+
+	// Without marshallers all the namespaces are dumped as hex.
+	out.Reset()
+	requireT.NoError(wavectl.Run(ctx, []string{"dump", servers}, out))
+	requireT.Equal(1, strings.Count(out.String(), "\n"))
+	requireT.Contains(out.String(), "namespace="+namespace)
+	requireT.Contains(out.String(), "hex="+content)
+}
+
+func TestGatewayPublishAndSubscribe(t *testing.T) {
+	requireT := require.New(t)
+
+	ctx := qa.NewContext(t)
+	group := qa.NewGroup(ctx, t)
+
+	defer func() {
+		group.Exit(nil)
+		requireT.NoError(group.Wait())
+	}()
+
+	ls, err := net.Listen("tcp", "localhost:0")
+	requireT.NoError(err)
+	gatewayAddress := freeAddress(requireT)
+
+	m := wire1.NewMarshaller()
+	namespace := url.QueryEscape(string(wave.NamespaceOf(m)))
+	client, recvCh, err := wave.NewClient(wave.ClientConfig{
+		Servers:        []string{ls.Addr().String()},
+		MaxMessageSize: maxMsgSize,
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages: []any{
+					&wire1.Msg1{},
+					&wire1.Msg2{},
+				},
+			},
+		},
+	})
+	requireT.NoError(err)
+
+	server, err := wave.NewServer(wave.ServerConfig{
+		MaxMessageSize: maxMsgSize,
+	})
+	requireT.NoError(err)
+
+	gateway, err := wave.NewGateway(wave.GatewayConfig{
+		Servers:        []string{ls.Addr().String()},
+		MaxMessageSize: maxMsgSize,
+		Address:        gatewayAddress,
+		Marshallers:    []proton.Marshaller{m},
+	})
+	requireT.NoError(err)
+
+	group.Spawn("client", parallel.Fail, client.Run)
+	group.Spawn("server", parallel.Fail, func(ctx context.Context) error {
+		return server.Run(ctx, ls)
+	})
+	group.Spawn("gateway", parallel.Fail, gateway.Run)
+
+	requireT.NoError(client.Send(ctx, &wire1.Msg1{
+		Value: "test1",
+	}, m))
+
+	var resp *http.Response
+	requireT.Eventually(func() bool {
+		var err error
+		resp, err = http.Post("http://"+gatewayAddress+"/publish?namespace="+namespace+"&type=Msg2",
+			"application/json", strings.NewReader(`{"Value":5}`))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	requireT.NoError(resp.Body.Close())
+	requireT.Equal(http.StatusAccepted, resp.StatusCode)
+
+	wavetest.ExpectMessages(t, recvCh,
+		&wire1.Msg1{Value: "test1"},
+		&wire1.Msg2{Value: 5},
+	)
+
+	resp, err = http.Post("http://"+gatewayAddress+"/publish?namespace="+namespace+"&type=Msg3",
+		"application/json", strings.NewReader(`{}`))
+	requireT.NoError(err)
+	requireT.NoError(resp.Body.Close())
+	requireT.Equal(http.StatusNotFound, resp.StatusCode)
+
+	subscribe := func(query, lastEventID string) (*bufio.Reader, func()) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+			"http://"+gatewayAddress+"/subscribe?"+query, nil)
+		requireT.NoError(err)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		requireT.NoError(err)
+		requireT.Equal(http.StatusOK, resp.StatusCode)
+		requireT.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+		return bufio.NewReader(resp.Body), func() {
+			requireT.NoError(resp.Body.Close())
+		}
+	}
+
+	events, closeEvents := subscribe("namespace="+namespace+"&type=Msg1", "")
+	_, rev := readGatewayEvent(requireT, events)
+	closeEvents()
+	requireT.Equal("Msg1", rev.Type)
+	requireT.Equal(client.ID(), rev.Sender)
+	requireT.JSONEq(`{"Value":"test1"}`, string(rev.Message))
+
+	events, closeEvents = subscribe("", "")
+	_, rev1 := readGatewayEvent(requireT, events)
+	lastEventID, rev2 := readGatewayEvent(requireT, events)
+	closeEvents()
+	if rev1.Type == "Msg2" {
+		rev1, rev2 = rev2, rev1
+	}
+	requireT.Equal("Msg1", rev1.Type)
+	requireT.Equal("Msg2", rev2.Type)
+	requireT.Equal(gateway.Client().ID(), rev2.Sender)
+
+	// Wildcard namespace matches all the namespaces, like in requests sent to servers.
+	events, closeEvents = subscribe("namespace="+url.QueryEscape(string(wire.AnyNamespace)), "")
+	_, rev1 = readGatewayEvent(requireT, events)
+	_, rev2 = readGatewayEvent(requireT, events)
+	closeEvents()
+	requireT.ElementsMatch([]string{"Msg1", "Msg2"}, []string{rev1.Type, rev2.Type})
+
+	resp, err = http.Get("http://" + gatewayAddress + "/subscribe?namespace=" +
+		url.QueryEscape(string(wire.AnyNamespace)) + "&type=Msg1")
+	requireT.NoError(err)
+	requireT.NoError(resp.Body.Close())
+	requireT.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	requireT.NoError(client.Send(ctx, &wire1.Msg1{
+		Value: "test2",
+	}, m))
+	wavetest.ExpectMessages(t, recvCh,
+		&wire1.Msg1{Value: "test2"},
+	)
+
+	// Only the revision received after the last event is sent on resumption.
+	events, closeEvents = subscribe("namespace="+namespace, lastEventID)
+	_, rev = readGatewayEvent(requireT, events)
+	closeEvents()
+	requireT.Equal("Msg1", rev.Type)
+	requireT.Equal(wire.Revision(1), rev.Index)
+	requireT.JSONEq(`{"Value":"test2"}`, string(rev.Message))
+}
+
+func TestGatewayExposesRawNamespaces(t *testing.T) {
+	requireT := require.New(t)
+
+	ctx := qa.NewContext(t)
+	group := qa.NewGroup(ctx, t)
+
+	defer func() {
+		group.Exit(nil)
+		requireT.NoError(group.Wait())
+	}()
+
+	ls, err := net.Listen("tcp", "localhost:0")
+	requireT.NoError(err)
+	gatewayAddress := freeAddress(requireT)
+
+	m := wire1.NewMarshaller()
+	namespace := url.QueryEscape(string(wave.NamespaceOf(m)))
+	client, recvCh, err := wave.NewClient(wave.ClientConfig{
+		Servers:        []string{ls.Addr().String()},
+		MaxMessageSize: maxMsgSize,
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages: []any{
+					&wire1.Msg1{},
+					&wire1.Msg2{},
+				},
 			},
 		},
+	})
+	requireT.NoError(err)
+
+	server, err := wave.NewServer(wave.ServerConfig{
+		MaxMessageSize: maxMsgSize,
+	})
+	requireT.NoError(err)
+
+	// No marshallers are registered, so content is exposed as is.
+	gateway, err := wave.NewGateway(wave.GatewayConfig{
+		Servers:        []string{ls.Addr().String()},
+		MaxMessageSize: maxMsgSize,
+		Address:        gatewayAddress,
+	})
+	requireT.NoError(err)
+
+	group.Spawn("client", parallel.Fail, client.Run)
+	group.Spawn("server", parallel.Fail, func(ctx context.Context) error {
+		return server.Run(ctx, ls)
+	})
+	group.Spawn("gateway", parallel.Fail, gateway.Run)
+
+	msg1 := &wire1.Msg1{Value: "test"}
+	requireT.NoError(client.Send(ctx, msg1, m))
+
+	msg2 := &wire1.Msg2{Value: 5}
+	msg2ID, err := m.ID(msg2)
+	requireT.NoError(err)
+	buf := make([]byte, maxMsgSize)
+	_, size, err := m.Marshal(msg2, buf)
+	requireT.NoError(err)
+	body, err := json.Marshal(&wave.RawMessage{
+		ID:      wire.MessageID(msg2ID),
+		Content: buf[:size],
+	})
+	requireT.NoError(err)
+
+	var resp *http.Response
+	requireT.Eventually(func() bool {
+		var err error
+		resp, err = http.Post("http://"+gatewayAddress+"/publish?namespace="+namespace,
+			"application/json", bytes.NewReader(body))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	requireT.NoError(resp.Body.Close())
+	requireT.Equal(http.StatusAccepted, resp.StatusCode)
+
+	wavetest.ExpectMessages(t, recvCh, msg1, msg2)
+
+	resp, err = http.Post("http://"+gatewayAddress+"/publish?namespace="+namespace+"&type=Msg1",
+		"application/json", strings.NewReader(`{}`))
+	requireT.NoError(err)
+	requireT.NoError(resp.Body.Close())
+	requireT.Equal(http.StatusNotFound, resp.StatusCode)
+
+	// Body is limited even before the message is decoded.
+	resp, err = http.Post("http://"+gatewayAddress+"/publish?namespace="+namespace,
+		"application/json", strings.NewReader(strings.Repeat(" ", 100*maxMsgSize)+`{}`))
+	requireT.NoError(err)
+	requireT.NoError(resp.Body.Close())
+	requireT.Equal(http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://"+gatewayAddress+"/subscribe?namespace="+namespace, nil)
+	requireT.NoError(err)
+	resp, err = http.DefaultClient.Do(req)
+	requireT.NoError(err)
+	defer resp.Body.Close()
+	requireT.Equal(http.StatusOK, resp.StatusCode)
+
+	events := bufio.NewReader(resp.Body)
+	_, rev1 := readGatewayEvent(requireT, events)
+	_, rev2 := readGatewayEvent(requireT, events)
+	if rev1.Sender != client.ID() {
+		rev1, rev2 = rev2, rev1
 	}
+	requireT.Empty(rev1.Type)
+	requireT.Equal(client.ID(), rev1.Sender)
+	requireT.Equal(gateway.Client().ID(), rev2.Sender)
+
+	var rawMsg wave.RawMessage
+	requireT.NoError(json.Unmarshal(rev2.Message, &rawMsg))
+	requireT.Equal(wire.MessageID(msg2ID), rawMsg.ID)
+	requireT.Equal(buf[:size], rawMsg.Content)
+}
+
+func TestWebSocketClients(t *testing.T) {
+	requireT := require.New(t)
+
+	ctx := qa.NewContext(t)
+	group := qa.NewGroup(ctx, t)
 
-	client1, recvCh1, err := wave.NewClient(clientConfig1)
+	defer func() {
+		group.Exit(nil)
+		requireT.NoError(group.Wait())
+	}()
+
+	ls, err := net.Listen("tcp", "localhost:0")
+	requireT.NoError(err)
+	wsAddress := "ws://" + freeAddress(requireT)
+
+	// Server advertises WebSocket address, so clients never connect using TCP.
+	server, err := wave.NewServer(wave.ServerConfig{
+		MaxMessageSize:   maxMsgSize,
+		AdvertiseAddress: wsAddress,
+		WebSocketAddress: strings.TrimPrefix(wsAddress, "ws://"),
+	})
 	requireT.NoError(err)
 
-	client2, recvCh2, err := wave.NewClient(clientConfig2)
+	m := wire1.NewMarshaller()
+	client1, recvCh1, err := wave.NewClient(wave.ClientConfig{
+		Servers:        []string{wsAddress},
+		MaxMessageSize: maxMsgSize,
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg2{}},
+			},
+		},
+	})
+	requireT.NoError(err)
+	client2, recvCh2, err := wave.NewClient(wave.ClientConfig{
+		Servers:        []string{wsAddress},
+		MaxMessageSize: maxMsgSize,
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+	})
 	requireT.NoError(err)
 
+	group.Spawn("server", parallel.Fail, func(ctx context.Context) error {
+		return server.Run(ctx, ls)
+	})
 	group.Spawn("client1", parallel.Fail, client1.Run)
 	group.Spawn("client2", parallel.Fail, client2.Run)
-	group.Spawn("server1", parallel.Fail, func(ctx context.Context) error {
-		return wave.RunServer(ctx, ls1, wave.ServerConfig{
-			Servers:        servers,
-			MaxMessageSize: maxMsgSize,
-		})
-	})
 
-	requireT.NoError(client1.Send(&wire1.Msg1{
-		Value: "test1",
+	requireT.NoError(client1.Send(ctx, &wire1.Msg1{
+		Value: "test",
 	}, m))
-	requireT.NoError(client1.Send(&wire1.Msg1{
-		Value: "test2",
+	requireT.NoError(client2.Send(ctx, &wire1.Msg2{
+		Value: 1,
 	}, m))
 
-	group.Spawn("server2", parallel.Fail, func(ctx context.Context) error {
-		return wave.RunServer(ctx, ls2, wave.ServerConfig{
-			Servers:        servers,
-			MaxMessageSize: maxMsgSize,
-		})
-	})
-
-	testMsgs(ctx, requireT, recvCh1)
-	testMsgs(ctx, requireT, recvCh2,
-		&wire1.Msg1{Value: "test2"},
+	wavetest.ExpectMessages(t, recvCh1,
+		&wire1.Msg2{Value: 1},
+	)
+	wavetest.ExpectMessages(t, recvCh2,
+		&wire1.Msg1{Value: "test"},
 	)
+
+	peers := server.Peers()
+	requireT.Len(peers, 2)
+	requireT.ElementsMatch([]wire.PeerID{client1.ID(), client2.ID()}, []wire.PeerID{peers[0].ID, peers[1].ID})
+
+	resp, err := http.Get("http" + strings.TrimPrefix(wsAddress, "ws"))
+	requireT.NoError(err)
+	requireT.NoError(resp.Body.Close())
+	requireT.Equal(http.StatusBadRequest, resp.StatusCode)
 }
 
-func TestSameMessagesFromTwoSourcesAreReceived(t *testing.T) {
+func TestServerWithManyListeners(t *testing.T) {
 	requireT := require.New(t)
 
 	ctx := qa.NewContext(t)
@@ -520,20 +2215,29 @@ func TestSameMessagesFromTwoSourcesAreReceived(t *testing.T) {
 		requireT.NoError(group.Wait())
 	}()
 
-	ls, err := net.Listen("tcp", "localhost:0")
+	tcpLs, err := wave.Listen("tcp://localhost:0")
+	requireT.NoError(err)
+	unixAddress := "unix://" + filepath.Join(t.TempDir(), "wave.sock")
+	unixLs, err := wave.Listen(unixAddress)
 	requireT.NoError(err)
 
-	servers := []string{
-		ls.Addr().String(),
-	}
+	_, err = wave.Listen("udp://localhost:0")
+	requireT.Error(err)
 
 	m := wire1.NewMarshaller()
-	clientConfig := wave.ClientConfig{
-		Servers:        servers,
+	client1, recvCh1, err := wave.NewClient(wave.ClientConfig{
+		Servers:        []string{unixAddress},
 		MaxMessageSize: maxMsgSize,
-	}
-	clientConfig3 := wave.ClientConfig{
-		Servers:        servers,
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg2{}},
+			},
+		},
+	})
+	requireT.NoError(err)
+	client2, recvCh2, err := wave.NewClient(wave.ClientConfig{
+		Servers:        []string{"tcp://" + tcpLs.Addr().String()},
 		MaxMessageSize: maxMsgSize,
 		Requests: []wave.RequestConfig{
 			{
@@ -541,125 +2245,479 @@ func TestSameMessagesFromTwoSourcesAreReceived(t *testing.T) {
 				Messages:   []any{&wire1.Msg1{}},
 			},
 		},
-	}
-
-	client1, recvCh1, err := wave.NewClient(clientConfig)
-	requireT.NoError(err)
-
-	client2, recvCh2, err := wave.NewClient(clientConfig)
+	})
 	requireT.NoError(err)
 
-	client3, recvCh3, err := wave.NewClient(clientConfig3)
+	server, err := wave.NewServer(wave.ServerConfig{
+		MaxMessageSize: maxMsgSize,
+	})
 	requireT.NoError(err)
 
-	group.Spawn("client1", parallel.Fail, client1.Run)
-	group.Spawn("client2", parallel.Fail, client2.Run)
 	group.Spawn("server", parallel.Fail, func(ctx context.Context) error {
-		return wave.RunServer(ctx, ls, wave.ServerConfig{
-			Servers:        servers,
-			MaxMessageSize: maxMsgSize,
-		})
+		return server.Run(ctx, unixLs, tcpLs)
 	})
+	group.Spawn("client1", parallel.Fail, client1.Run)
+	group.Spawn("client2", parallel.Fail, client2.Run)
 
-	requireT.NoError(client1.Send(&wire1.Msg1{
-		Value: "test1",
+	requireT.NoError(client1.Send(ctx, &wire1.Msg1{
+		Value: "test",
 	}, m))
-	requireT.NoError(client2.Send(&wire1.Msg1{
-		Value: "test2",
+	requireT.NoError(client2.Send(ctx, &wire1.Msg2{
+		Value: 1,
 	}, m))
 
-	group.Spawn("client3", parallel.Fail, client3.Run)
+	wavetest.ExpectMessages(t, recvCh1,
+		&wire1.Msg2{Value: 1},
+	)
+	wavetest.ExpectMessages(t, recvCh2,
+		&wire1.Msg1{Value: "test"},
+	)
+
+	// Address of the first listener is advertised, so client1 never connects using TCP.
+	members := server.Members()
+	requireT.Len(members, 1)
+	requireT.Equal(unixAddress, members[0].Address)
+}
 
-	testMsgs(ctx, requireT, recvCh1)
-	testMsgs(ctx, requireT, recvCh2)
-	testMsgs(ctx, requireT, recvCh3,
-		&wire1.Msg1{Value: "test1"},
-		&wire1.Msg1{Value: "test2"},
+func TestConvergenceAfterPartitionHeals(t *testing.T) {
+	cluster := wavetest.NewCluster(t, wavetest.Config{
+		Servers:        2,
+		MaxMessageSize: maxMsgSize,
+		Server: func(i int, config *wave.ServerConfig) {
+			config.Reconnect = fastReconnect
+		},
+	})
+	network := cluster.Network()
+
+	// Existing connections are dropped and new ones are refused, so servers synchronize after reconnecting.
+	cluster.Partition(0, 1)
+	network.Cut(cluster.Address(0), cluster.Address(1))
+
+	client1, client2 := partitionedClients(t, cluster)
+	cluster.Heal(0, 1)
+
+	client1.ExpectMessages(
+		&wire1.Msg2{Value: 2},
+	)
+	client2.ExpectMessages(
+		&wire1.Msg1{Value: "test"},
 	)
 }
 
-func TestSameMessageReceivedTwiceIsIgnored(t *testing.T) {
+func TestConvergenceAfterStalledConnectionResumes(t *testing.T) {
 	requireT := require.New(t)
 
+	cluster := wavetest.NewCluster(t, wavetest.Config{
+		Servers:        2,
+		MaxMessageSize: maxMsgSize,
+	})
+
+	requireT.Eventually(func() bool {
+		return len(cluster.Server(0).Peers()) == 1 && len(cluster.Server(1).Peers()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Connection between servers stays open, but data don't flow, like on half-open socket.
+	cluster.Partition(0, 1)
+
+	client1, client2 := partitionedClients(t, cluster)
+	requireT.Len(cluster.Server(0).Peers(), 2)
+	cluster.Heal(0, 1)
+
+	client1.ExpectMessages(
+		&wire1.Msg2{Value: 2},
+	)
+	client2.ExpectMessages(
+		&wire1.Msg1{Value: "test"},
+	)
+}
+
+func TestConvergenceAfterConnectionIsCutMidFrame(t *testing.T) {
+	requireT := require.New(t)
 	ctx := qa.NewContext(t)
-	group := qa.NewGroup(ctx, t)
 
-	defer func() {
-		group.Exit(nil)
-		requireT.NoError(group.Wait())
-	}()
+	cluster := wavetest.NewCluster(t, wavetest.Config{
+		Servers:        2,
+		MaxMessageSize: maxMsgSize,
+		Server: func(i int, config *wave.ServerConfig) {
+			config.Reconnect = fastReconnect
+		},
+	})
+	network := cluster.Network()
 
-	ls1, err := net.Listen("tcp", "localhost:0")
-	requireT.NoError(err)
-	ls2, err := net.Listen("tcp", "localhost:0")
-	requireT.NoError(err)
+	m := wire1.NewMarshaller()
+	client1 := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+		Reconnect: fastReconnect,
+	}, 0)
+	client2 := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}},
+			},
+		},
+		Reconnect: fastReconnect,
+	}, 1)
 
-	servers := []string{
-		ls1.Addr().String(),
-		ls2.Addr().String(),
-	}
+	requireT.Eventually(func() bool {
+		return len(cluster.Server(0).Peers()) == 2 && len(cluster.Server(1).Peers()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	network.CutMidFrame(cluster.Address(0), cluster.Address(1))
+	network.CutMidFrame(client1.Name, cluster.Address(0))
+
+	requireT.NoError(client1.Send(ctx, &wire1.Msg1{
+		Value: "test",
+	}, m))
+
+	client1.ExpectMessages(
+		&wire1.Msg1{Value: "test"},
+	)
+	client2.ExpectMessages(
+		&wire1.Msg1{Value: "test"},
+	)
+}
+
+func TestDelayAndBandwidthLimit(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	const delay = 200 * time.Millisecond
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
+	network := cluster.Network()
 
 	m := wire1.NewMarshaller()
-	clientConfig1 := wave.ClientConfig{
-		Servers:        servers,
-		MaxMessageSize: maxMsgSize,
-	}
-	clientConfig2 := wave.ClientConfig{
-		Servers:        servers,
-		MaxMessageSize: maxMsgSize,
+	client := cluster.NewClient(wave.ClientConfig{
 		Requests: []wave.RequestConfig{
 			{
 				Marshaller: m,
 				Messages:   []any{&wire1.Msg1{}},
 			},
 		},
-	}
+	})
 
-	client1, recvCh1, err := wave.NewClient(clientConfig1)
-	requireT.NoError(err)
+	requireT.Eventually(func() bool {
+		return len(cluster.Server(0).Peers()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
 
-	client2, recvCh2, err := wave.NewClient(clientConfig2)
-	requireT.NoError(err)
+	network.SetDelay(client.Name, cluster.Address(0), delay)
 
-	group.Spawn("client1", parallel.Fail, client1.Run)
-	group.Spawn("server", parallel.Fail, func(ctx context.Context) error {
-		return wave.RunServer(ctx, ls1, wave.ServerConfig{
-			Servers:        servers,
-			MaxMessageSize: maxMsgSize,
-		})
+	// Message travels to the server and back.
+	start := time.Now()
+	requireT.NoError(client.Send(ctx, &wire1.Msg1{
+		Value: "test1",
+	}, m))
+	client.ExpectMessages(
+		&wire1.Msg1{Value: "test1"},
+	)
+	requireT.GreaterOrEqual(time.Since(start), 2*delay)
+
+	network.SetDelay(client.Name, cluster.Address(0), 0)
+	network.SetBandwidth(client.Name, cluster.Address(0), 2000)
+
+	start = time.Now()
+	requireT.NoError(client.Send(ctx, &wire1.Msg1{
+		Value: strings.Repeat("a", 800),
+	}, m))
+	client.ExpectMessages(
+		&wire1.Msg1{Value: strings.Repeat("a", 800)},
+	)
+	requireT.GreaterOrEqual(time.Since(start), 800*time.Millisecond)
+}
+
+func TestSendDoesNotWaitForStalledConnection(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
+
+	m := wire1.NewMarshaller()
+	receiver := cluster.NewClient(wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg2{}},
+			},
+		},
 	})
-	group.Spawn("server", parallel.Fail, func(ctx context.Context) error {
-		return wave.RunServer(ctx, ls2, wave.ServerConfig{
-			Servers:        servers,
-			MaxMessageSize: maxMsgSize,
-		})
+	writes := newStaller()
+	sender := cluster.NewClient(wave.ClientConfig{
+		Dialer: writes.Dial,
 	})
 
-	group.Spawn("client2", parallel.Fail, client2.Run)
+	requireT.Eventually(func() bool {
+		return len(cluster.Server(0).Peers()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Connection is stalled once the first message is being written.
+	writes.Stall()
+	requireT.NoError(sender.Send(ctx, &wire1.Msg2{}, m))
+	<-writes.Stalled()
+
+	// Only the latest revision waits for the stalled connection, so sending never blocks. Send waiting for
+	// the connection would return the error once the context expires.
+	sendCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	const count = 1000
+	for i := range uint64(count) {
+		requireT.NoError(sender.Send(sendCtx, &wire1.Msg2{
+			Value: i + 1,
+		}, m))
+	}
+
+	writes.Resume()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case <-timeout:
+			requireT.FailNow("timeout waiting for the latest revision")
+		case msg := <-receiver.Messages:
+			if msg.(*wire1.Msg2).Value == count {
+				return
+			}
+		}
+	}
+}
+
+func TestSendReportsErrors(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{MaxMessageSize: maxMsgSize})
+
+	m := wire1.NewMarshaller()
+	client := cluster.NewClient(wave.ClientConfig{})
+
+	requireT.Error(client.Send(ctx, &wire1.Msg1{
+		Value: strings.Repeat("a", maxMsgSize),
+	}, m))
+	requireT.Error(client.Send(ctx, &wire2.Msg1{}, m))
+
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	requireT.ErrorIs(client.Send(cancelledCtx, &wire1.Msg1{}, m), context.Canceled)
+
+	client.Stop()
+	requireT.Eventually(func() bool {
+		return errors.Is(client.Send(ctx, &wire1.Msg1{}, m), wave.ErrClientStopped)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []wave.Span
+}
+
+func (r *spanRecorder) RecordSpan(span wave.Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spans = append(r.spans, span)
+}
+
+func (r *spanRecorder) Spans(name string) []wave.Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var spans []wave.Span
+	for _, span := range r.spans {
+		if span.Name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// fastReconnect makes peers reconnect quickly, so tests don't wait for the default delays.
+var fastReconnect = wave.ReconnectPolicy{
+	InitialDelay: 10 * time.Millisecond,
+	MaxDelay:     100 * time.Millisecond,
+}
 
-	requireT.NoError(client1.Send(&wire1.Msg1{
+// partitionedClients starts clients pinned to servers 0 and 1. Each client sends a message and receives it back
+// from its own side of the partition.
+func partitionedClients(t *testing.T, cluster *wavetest.Cluster) (*wavetest.Client, *wavetest.Client) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	m := wire1.NewMarshaller()
+	config := wave.ClientConfig{
+		Requests: []wave.RequestConfig{
+			{
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg1{}, &wire1.Msg2{}},
+			},
+		},
+		Reconnect: fastReconnect,
+	}
+	client1 := cluster.NewClient(config, 0)
+	client2 := cluster.NewClient(config, 1)
+
+	requireT.NoError(client1.Send(ctx, &wire1.Msg1{
 		Value: "test",
 	}, m))
+	requireT.NoError(client2.Send(ctx, &wire1.Msg2{
+		Value: 2,
+	}, m))
 
-	testMsgs(ctx, requireT, recvCh1)
-	testMsgs(ctx, requireT, recvCh2,
+	client1.ExpectMessages(
 		&wire1.Msg1{Value: "test"},
 	)
+	client2.ExpectMessages(
+		&wire1.Msg2{Value: 2},
+	)
+
+	return client1, client2
+}
+
+// freeAddress returns address of the port which is free at the moment.
+func freeAddress(requireT *require.Assertions) string {
+	ls, err := net.Listen("tcp", "localhost:0")
+	requireT.NoError(err)
+	defer ls.Close()
+
+	return ls.Addr().String()
+}
+
+// scrapeMetrics returns metrics served in Prometheus text format, or nil if they can't be fetched.
+func scrapeMetrics(address string) map[string]float64 {
+	resp, err := http.Get("http://" + address + "/metrics")
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil
+	}
+
+	metrics := map[string]float64{}
+	for _, line := range strings.Split(string(body), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			return nil
+		}
+		metrics[line[:i]] = v
+	}
+	return metrics
+}
+
+type gatewayRevision struct {
+	Type    string
+	Sender  wire.PeerID
+	Index   wire.Revision
+	Message json.RawMessage
+}
+
+func readGatewayEvent(requireT *require.Assertions, events *bufio.Reader) (string, gatewayRevision) {
+	var id string
+	var rev gatewayRevision
+	for {
+		line, err := events.ReadString('\n')
+		requireT.NoError(err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			requireT.NotEmpty(id)
+			return id, rev
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			requireT.NoError(json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &rev))
+		}
+	}
+}
+
+func sortedStrings(s []string) []string {
+	sort.Strings(s)
+	return s
+}
+
+// staller dials connections whose writes block after Stall is called until Resume is called.
+type staller struct {
+	stalled   atomic.Bool
+	stalledCh chan struct{}
+	resumeCh  chan struct{}
+}
+
+func newStaller() *staller {
+	return &staller{
+		stalledCh: make(chan struct{}, 1),
+		resumeCh:  make(chan struct{}),
+	}
+}
+
+func (s *staller) Dial(ctx context.Context, address string) (net.Conn, error) {
+	conn, err := wave.Dial(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return &stalledConn{
+		Conn:    conn,
+		staller: s,
+	}, nil
+}
+
+// Stall blocks the subsequent writes.
+func (s *staller) Stall() {
+	s.stalled.Store(true)
+}
+
+// Stalled returns channel signaled when the first write is blocked.
+func (s *staller) Stalled() <-chan struct{} {
+	return s.stalledCh
+}
+
+// Resume unblocks the writes.
+func (s *staller) Resume() {
+	s.stalled.Store(false)
+	close(s.resumeCh)
+}
+
+type stalledConn struct {
+	net.Conn
+
+	staller *staller
 }
 
-func testMsgs(ctx context.Context, requireT *require.Assertions, recvCh <-chan any, msgs ...any) {
-	received := make([]any, 0, len(msgs))
-	for range msgs {
+func (c *stalledConn) Write(b []byte) (int, error) {
+	if c.staller.stalled.Load() {
 		select {
-		case <-ctx.Done():
-			return
-		case <-time.After(5 * time.Second):
-			requireT.Fail("timeout")
-		case msg := <-recvCh:
-			received = append(received, msg)
+		case c.staller.stalledCh <- struct{}{}:
+		default:
 		}
+		<-c.staller.resumeCh
 	}
+	return c.Conn.Write(b)
+}
+
+// swappedMarshaller marshals Msg2 using ID of Msg1.
+type swappedMarshaller struct {
+	wire1.Marshaller
+}
+
+func (m swappedMarshaller) ID(msg any) (uint64, error) {
+	return m.Marshaller.ID(&wire1.Msg1{})
+}
 
-	requireT.ElementsMatch(msgs, received)
-	requireT.Empty(recvCh)
+func (m swappedMarshaller) Marshal(msg any, buf []byte) (uint64, uint64, error) {
+	_, size, err := m.Marshaller.Marshal(msg, buf)
+	if err != nil {
+		return 0, 0, err
+	}
+	id, err := m.ID(msg)
+	return id, size, err
 }
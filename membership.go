@@ -0,0 +1,241 @@
+package wave
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/wave/wire"
+)
+
+// MemberState is the state of the cluster member.
+type MemberState int
+
+const (
+	// MemberAlive means that heartbeat of the member is fresh.
+	MemberAlive MemberState = iota
+
+	// MemberSuspected means that heartbeat of the member hasn't been updated for a while.
+	MemberSuspected
+)
+
+func (s MemberState) String() string {
+	switch s {
+	case MemberAlive:
+		return "alive"
+	case MemberSuspected:
+		return "suspected"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes member state as its name.
+func (s MemberState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes member state from its name.
+func (s *MemberState) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "alive":
+		*s = MemberAlive
+	case "suspected":
+		*s = MemberSuspected
+	default:
+		return errors.Errorf("unknown member state %q", text)
+	}
+	return nil
+}
+
+// Member describes server being a member of the cluster.
+type Member struct {
+	ID      wire.PeerID
+	Address string
+	State   MemberState
+}
+
+type memberInfo struct {
+	Address   string
+	Heartbeat uint64
+	Updated   time.Time
+	Suspected bool
+}
+
+type tombstone struct {
+	Heartbeat uint64
+	Removed   time.Time
+}
+
+type membership struct {
+	selfID wire.PeerID
+
+	mu          sync.RWMutex
+	selfAddress string
+	heartbeat   uint64
+	members     map[wire.PeerID]*memberInfo
+	removed     map[wire.PeerID]tombstone
+}
+
+func newMembership(selfID wire.PeerID) *membership {
+	return &membership{
+		selfID:  selfID,
+		members: map[wire.PeerID]*memberInfo{},
+		removed: map[wire.PeerID]tombstone{},
+	}
+}
+
+func (m *membership) SetAddress(address string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.selfAddress = address
+}
+
+// Heartbeat increments heartbeat of the server.
+func (m *membership) Heartbeat() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.heartbeat++
+}
+
+// Gossip returns the list of members to be sent to other servers.
+func (m *membership) Gossip() *wire.Members {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	msg := &wire.Members{
+		Members: make([]wire.Member, 0, len(m.members)+1),
+	}
+	msg.Members = append(msg.Members, wire.Member{
+		PeerID:    m.selfID,
+		Address:   m.selfAddress,
+		Heartbeat: m.heartbeat,
+	})
+	for id, info := range m.members {
+		msg.Members = append(msg.Members, wire.Member{
+			PeerID:    id,
+			Address:   info.Address,
+			Heartbeat: info.Heartbeat,
+		})
+	}
+
+	return msg
+}
+
+// Merge merges members received from other server and returns the ones which joined the cluster.
+func (m *membership) Merge(members []wire.Member, now time.Time) []wire.Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var joined []wire.Member
+	for _, member := range members {
+		if member.PeerID == m.selfID || member.Address == "" {
+			continue
+		}
+		if t, exists := m.removed[member.PeerID]; exists {
+			if t.Heartbeat >= member.Heartbeat {
+				continue
+			}
+			delete(m.removed, member.PeerID)
+		}
+
+		info, exists := m.members[member.PeerID]
+		if !exists {
+			m.members[member.PeerID] = &memberInfo{
+				Address:   member.Address,
+				Heartbeat: member.Heartbeat,
+				Updated:   now,
+			}
+			joined = append(joined, member)
+			continue
+		}
+		if member.Heartbeat > info.Heartbeat {
+			info.Address = member.Address
+			info.Heartbeat = member.Heartbeat
+			info.Updated = now
+			info.Suspected = false
+		}
+	}
+
+	return joined
+}
+
+// Check suspects members whose heartbeat hasn't been updated within the suspicion timeout and removes
+// those not updated within removal timeout.
+func (m *membership) Check(
+	now time.Time,
+	suspicionTimeout, removalTimeout time.Duration,
+) (suspected, removed []wire.PeerID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, info := range m.members {
+		age := now.Sub(info.Updated)
+		switch {
+		case age > removalTimeout:
+			delete(m.members, id)
+			m.removed[id] = tombstone{
+				Heartbeat: info.Heartbeat,
+				Removed:   now,
+			}
+			removed = append(removed, id)
+		case age > suspicionTimeout && !info.Suspected:
+			info.Suspected = true
+			suspected = append(suspected, id)
+		}
+	}
+
+	// Tombstones are kept long enough for the removed member to disappear from the gossip of other servers.
+	for id, t := range m.removed {
+		if now.Sub(t.Removed) > removalTimeout {
+			delete(m.removed, id)
+		}
+	}
+
+	return suspected, removed
+}
+
+// Address returns the address of the member.
+func (m *membership) Address(id wire.PeerID) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	info, exists := m.members[id]
+	if !exists {
+		return "", false
+	}
+	return info.Address, true
+}
+
+// Members returns members of the cluster including this server.
+func (m *membership) Members() []Member {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	members := make([]Member, 0, len(m.members)+1)
+	members = append(members, Member{
+		ID:      m.selfID,
+		Address: m.selfAddress,
+		State:   MemberAlive,
+	})
+	for id, info := range m.members {
+		state := MemberAlive
+		if info.Suspected {
+			state = MemberSuspected
+		}
+		members = append(members, Member{
+			ID:      id,
+			Address: info.Address,
+			State:   state,
+		})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Address < members[j].Address
+	})
+
+	return members
+}
@@ -0,0 +1,193 @@
+package wave
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/outofforest/wave/wire"
+)
+
+var (
+	durationBuckets = []float64{0.00001, 0.0001, 0.001, 0.01, 0.1, 1}
+	sizeBuckets     = []float64{0, 1, 10, 100, 1000, 10000}
+)
+
+type histogram struct {
+	bounds []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+// Observe records the value.
+func (h *histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+type metrics struct {
+	clientConns       atomic.Int64
+	serverConns       atomic.Int64
+	bytesSent         atomic.Uint64
+	bytesReceived     atomic.Uint64
+	framesSent        atomic.Uint64
+	framesReceived    atomic.Uint64
+	dedupDrops        atomic.Uint64
+	schemaMismatches  atomic.Uint64
+	reconnectAttempts atomic.Uint64
+	broadcastDuration *histogram
+	replaySize        *histogram
+
+	// revisions returns number of revisions stored per namespace.
+	revisions func() map[wire.Namespace]int
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		broadcastDuration: newHistogram(durationBuckets),
+		replaySize:        newHistogram(sizeBuckets),
+		revisions: func() map[wire.Namespace]int {
+			return nil
+		},
+	}
+}
+
+// Connected records connection to the peer and returns function to be called on disconnection.
+func (m *metrics) Connected(role PeerRole) func() {
+	gauge := &m.clientConns
+	if role == RoleServer {
+		gauge = &m.serverConns
+	}
+	gauge.Add(1)
+	return func() {
+		gauge.Add(-1)
+	}
+}
+
+// FrameSent records frame sent to the peer.
+func (m *metrics) FrameSent(size uint64) {
+	m.framesSent.Add(1)
+	m.bytesSent.Add(size)
+}
+
+// FrameReceived records frame received from the peer.
+func (m *metrics) FrameReceived(size uint64) {
+	m.framesReceived.Add(1)
+	m.bytesReceived.Add(size)
+}
+
+// DedupDropped records revision dropped because newer one is already known.
+func (m *metrics) DedupDropped() {
+	m.dedupDrops.Add(1)
+}
+
+// SchemaMismatched records revision refused because its schema fingerprint doesn't match the local message type.
+func (m *metrics) SchemaMismatched() {
+	m.schemaMismatches.Add(1)
+}
+
+// ReconnectAttempted records attempt to connect to the peer.
+func (m *metrics) ReconnectAttempted() {
+	m.reconnectAttempts.Add(1)
+}
+
+// Broadcasted records time it took to pass revision to all the connections.
+func (m *metrics) Broadcasted(d time.Duration) {
+	m.broadcastDuration.Observe(d.Seconds())
+}
+
+// Replayed records number of revisions sent to the peer during synchronization.
+func (m *metrics) Replayed(n int) {
+	m.replaySize.Observe(float64(n))
+}
+
+// Write writes metrics in Prometheus text exposition format.
+func (m *metrics) Write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP wave_connections Number of connected peers.\n# TYPE wave_connections gauge\n")
+	fmt.Fprintf(w, "wave_connections{role=%q} %d\n", RoleClient, m.clientConns.Load())
+	fmt.Fprintf(w, "wave_connections{role=%q} %d\n", RoleServer, m.serverConns.Load())
+
+	revs := m.revisions()
+	namespaces := make([]wire.Namespace, 0, len(revs))
+	for ns := range revs {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i] < namespaces[j]
+	})
+	fmt.Fprintf(w, "# HELP wave_revisions Number of stored revisions.\n# TYPE wave_revisions gauge\n")
+	for _, ns := range namespaces {
+		fmt.Fprintf(w, "wave_revisions{namespace=%q} %d\n", ns, revs[ns])
+	}
+
+	writeCounter(w, "wave_bytes_sent_total", "Bytes sent to peers.", m.bytesSent.Load())
+	writeCounter(w, "wave_bytes_received_total", "Bytes received from peers.", m.bytesReceived.Load())
+	writeCounter(w, "wave_frames_sent_total", "Frames sent to peers.", m.framesSent.Load())
+	writeCounter(w, "wave_frames_received_total", "Frames received from peers.", m.framesReceived.Load())
+	writeCounter(w, "wave_dedup_drops_total", "Revisions dropped because newer ones are known.",
+		m.dedupDrops.Load())
+	writeCounter(w, "wave_schema_mismatches_total",
+		"Revisions refused because their schema fingerprint doesn't match the local message type.",
+		m.schemaMismatches.Load())
+	writeCounter(w, "wave_reconnect_attempts_total", "Attempts to connect to peers.", m.reconnectAttempts.Load())
+
+	m.broadcastDuration.write(w, "wave_broadcast_duration_seconds",
+		"Time it takes to pass revision to all the connections.")
+	m.replaySize.write(w, "wave_replay_revisions", "Number of revisions sent to peer during synchronization.")
+}
+
+// Handler returns HTTP handler serving metrics.
+func (m *metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.Write(w)
+	})
+}
+
+func writeCounter(w io.Writer, name, help string, v uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,249 @@
+package wave
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/proton"
+	"github.com/outofforest/varuint64"
+	"github.com/outofforest/wave/wire"
+)
+
+// sendQueue keeps messages waiting to be sent through the connection. Pending revision of the message is replaced
+// by the newer one, so publisher never waits for the connection and the queue is bounded by the number
+// of message types and pending subscriptions.
+type sendQueue struct {
+	readyCh chan struct{}
+
+	mu      sync.Mutex
+	items   []msgToSend
+	indexes map[wire.MessageDescriptor]int
+	closed  bool
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{
+		readyCh: make(chan struct{}, 1),
+		indexes: map[wire.MessageDescriptor]int{},
+	}
+}
+
+// Push adds message to the queue. Pending revision of the same message is replaced in place, so it is never
+// sent later than before. Subscriptions are never replaced, because server replays current values of messages
+// requested again only if it has seen them unrequested.
+func (q *sendQueue) Push(item msgToSend) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	switch {
+	case item.Subscription != nil:
+		q.items = append(q.items, item)
+	default:
+		if i, exists := q.indexes[item.Header.Revision.Message]; exists {
+			q.items[i] = item
+		} else {
+			q.indexes[item.Header.Revision.Message] = len(q.items)
+			q.items = append(q.items, item)
+		}
+	}
+
+	q.notify()
+}
+
+// Ready returns channel signaled when messages are pushed or queue is closed.
+func (q *sendQueue) Ready() <-chan struct{} {
+	return q.readyCh
+}
+
+// Pop returns pending messages in the order they have been pushed. False is returned if queue is closed.
+func (q *sendQueue) Pop() ([]msgToSend, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, false
+	}
+
+	items := q.items
+	q.items = nil
+	clear(q.indexes)
+	return items, true
+}
+
+// Close closes the queue, pending messages are dropped.
+func (q *sendQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.items = nil
+	q.notify()
+}
+
+func (q *sendQueue) notify() {
+	select {
+	case q.readyCh <- struct{}{}:
+	default:
+	}
+}
+
+// deliveryQueue keeps messages waiting to be delivered to the receiver. Pending revision of the message is replaced
+// by the newer one, so delivery never waits for the receiver and the queue is bounded by the number of messages.
+type deliveryQueue struct {
+	readyCh  chan struct{}
+	closedCh chan struct{}
+
+	mu      sync.Mutex
+	items   []any
+	indexes map[revDescriptor]int
+	closed  bool
+
+	// pushed counts pushes, sent is the count of pushes passed to the channel. sentCh is closed and replaced
+	// each time sent grows.
+	pushed uint64
+	sent   uint64
+	sentCh chan struct{}
+}
+
+func newDeliveryQueue() *deliveryQueue {
+	return &deliveryQueue{
+		readyCh:  make(chan struct{}, 1),
+		closedCh: make(chan struct{}),
+		indexes:  map[revDescriptor]int{},
+		sentCh:   make(chan struct{}),
+	}
+}
+
+// Push adds message to the queue. Pending revision of the same message is replaced in place, so it is never
+// delivered later than before.
+func (q *deliveryQueue) Push(revDesc revDescriptor, msg any) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	if i, exists := q.indexes[revDesc]; exists {
+		q.items[i] = msg
+	} else {
+		q.indexes[revDesc] = len(q.items)
+		q.items = append(q.items, msg)
+	}
+	q.pushed++
+
+	select {
+	case q.readyCh <- struct{}{}:
+	default:
+	}
+}
+
+// Flush waits until messages pushed so far are passed to the channel or queue is closed.
+func (q *deliveryQueue) Flush(ctx context.Context) error {
+	q.mu.Lock()
+	target := q.pushed
+	for q.sent < target && !q.closed {
+		sentCh := q.sentCh
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case <-q.closedCh:
+		case <-sentCh:
+		}
+
+		q.mu.Lock()
+	}
+	q.mu.Unlock()
+	return nil
+}
+
+// Run passes queued messages to the channel until the queue is closed, then the channel is closed.
+func (q *deliveryQueue) Run(ch chan<- any) {
+	defer close(ch)
+
+	for {
+		select {
+		case <-q.closedCh:
+			return
+		case <-q.readyCh:
+		}
+
+		items, pushed := q.pop()
+		for _, msg := range items {
+			select {
+			case <-q.closedCh:
+				return
+			case ch <- msg:
+			}
+		}
+		q.markSent(pushed)
+	}
+}
+
+// pop returns pending messages in the order they have been pushed, together with the count of pushes
+// they include.
+func (q *deliveryQueue) pop() ([]any, uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := q.items
+	q.items = nil
+	clear(q.indexes)
+	return items, q.pushed
+}
+
+func (q *deliveryQueue) markSent(pushed uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.sent = pushed
+	close(q.sentCh)
+	q.sentCh = make(chan struct{})
+}
+
+// Close closes the queue, pending messages are dropped.
+func (q *deliveryQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		q.items = nil
+		close(q.closedCh)
+	}
+}
+
+// encodeFrame encodes message the way connection sends it, so it is sent later as raw bytes.
+func encodeFrame(msg any, m proton.Marshaller, maxMessageSize uint64) ([]byte, error) {
+	size, err := m.Size(msg)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxMessageSize {
+		return nil, errors.Errorf("message size %d exceeds maximum %d", size, maxMessageSize)
+	}
+
+	// Space is reserved for the length and message ID preceding the message.
+	buf := make([]byte, 2*varuint64.MaxSize+size)
+	msgID, msgSize, err := m.Marshal(msg, buf[2*varuint64.MaxSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	msgIDSize := varuint64.Size(msgID)
+	varuint64.Put(buf[2*varuint64.MaxSize-msgIDSize:], msgID)
+
+	totalSize := msgIDSize + msgSize
+	start := 2*varuint64.MaxSize - msgIDSize - varuint64.Size(totalSize)
+	varuint64.Put(buf[start:], totalSize)
+
+	return buf[start : 2*varuint64.MaxSize+msgSize], nil
+}
@@ -0,0 +1,94 @@
+package wave
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/proton"
+	"github.com/outofforest/wave/wire"
+)
+
+// RawMessage is the message whose content is passed as is, without decoding.
+type RawMessage struct {
+	ID      wire.MessageID
+	Content []byte
+}
+
+var _ proton.Marshaller = RawMarshaller{}
+
+// RawMarshaller exchanges messages of the namespace as RawMessage. It is used by tools which don't have
+// the marshaller of the namespace compiled in.
+type RawMarshaller struct {
+	Namespace wire.Namespace
+}
+
+func (m RawMarshaller) namespace() wire.Namespace {
+	return m.Namespace
+}
+
+// Messages returns nil because message types of the namespace are not known.
+func (m RawMarshaller) Messages() []any {
+	return nil
+}
+
+// ID returns ID of the message.
+func (m RawMarshaller) ID(msg any) (uint64, error) {
+	rawMsg, err := toRawMessage(msg)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(rawMsg.ID), nil
+}
+
+// Size returns size of the content.
+func (m RawMarshaller) Size(msg any) (uint64, error) {
+	rawMsg, err := toRawMessage(msg)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(rawMsg.Content)), nil
+}
+
+// Marshal copies content to the buffer.
+func (m RawMarshaller) Marshal(msg any, buf []byte) (uint64, uint64, error) {
+	rawMsg, err := toRawMessage(msg)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(buf) < len(rawMsg.Content) {
+		return 0, 0, errors.WithStack(proton.ErrBufferFailure)
+	}
+	return uint64(rawMsg.ID), uint64(copy(buf, rawMsg.Content)), nil
+}
+
+// Unmarshal copies content from the buffer.
+func (m RawMarshaller) Unmarshal(id uint64, buf []byte) (any, uint64, error) {
+	return &RawMessage{
+		ID:      wire.MessageID(id),
+		Content: bytes.Clone(buf),
+	}, uint64(len(buf)), nil
+}
+
+// IsPatchNeeded is not supported.
+func (m RawMarshaller) IsPatchNeeded(msgDst, msgSrc any) (bool, error) {
+	return false, errors.New("patches are not supported by raw marshaller")
+}
+
+// MakePatch is not supported.
+func (m RawMarshaller) MakePatch(msgDst, msgSrc any, buf []byte) (uint64, uint64, error) {
+	return 0, 0, errors.New("patches are not supported by raw marshaller")
+}
+
+// ApplyPatch is not supported.
+func (m RawMarshaller) ApplyPatch(msg any, buf []byte) (uint64, error) {
+	return 0, errors.New("patches are not supported by raw marshaller")
+}
+
+func toRawMessage(msg any) (*RawMessage, error) {
+	rawMsg, ok := msg.(*RawMessage)
+	if !ok {
+		return nil, errors.Errorf("unknown message type %T", msg)
+	}
+	return rawMsg, nil
+}
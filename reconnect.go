@@ -0,0 +1,222 @@
+package wave
+
+import (
+	"context"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultInitialDelay      = 500 * time.Millisecond
+	defaultMaxDelay          = 30 * time.Second
+	defaultMultiplier        = 2
+	defaultJitter            = 0.2
+	defaultCircuitOpenAfter  = 10
+	defaultCircuitOpenPeriod = time.Minute
+)
+
+// ReconnectPolicy defines delays between subsequent attempts to connect to the peer.
+// Zero values are replaced with defaults.
+type ReconnectPolicy struct {
+	// InitialDelay is the delay after the first failure.
+	InitialDelay time.Duration
+
+	// MaxDelay limits the delay growing after subsequent failures.
+	MaxDelay time.Duration
+
+	// Multiplier is the factor the delay is multiplied by after each failure. If zero, it is 2.
+	// Set it to 1 to keep the delay fixed.
+	Multiplier float64
+
+	// Jitter is the fraction of the delay which is randomized, so peers don't reconnect in lockstep.
+	// If zero, it is 0.2. Set it to negative value to turn jitter off.
+	Jitter float64
+
+	// CircuitOpenAfter is the number of consecutive failures after which the circuit opens.
+	CircuitOpenAfter int
+
+	// CircuitOpenPeriod is the time connection attempts are suspended for, when circuit is open.
+	CircuitOpenPeriod time.Duration
+}
+
+func (p ReconnectPolicy) withDefaults() ReconnectPolicy {
+	if p.InitialDelay == 0 {
+		p.InitialDelay = defaultInitialDelay
+	}
+	if p.MaxDelay == 0 {
+		p.MaxDelay = defaultMaxDelay
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = defaultMultiplier
+	}
+	switch {
+	case p.Jitter == 0:
+		p.Jitter = defaultJitter
+	case p.Jitter < 0:
+		p.Jitter = 0
+	}
+	if p.CircuitOpenAfter == 0 {
+		p.CircuitOpenAfter = defaultCircuitOpenAfter
+	}
+	if p.CircuitOpenPeriod == 0 {
+		p.CircuitOpenPeriod = defaultCircuitOpenPeriod
+	}
+	return p
+}
+
+// LinkState describes the state of connection to the address.
+type LinkState struct {
+	Address             string
+	Connected           bool
+	Attempts            uint64
+	ConsecutiveFailures int
+	CircuitOpen         bool
+	LastError           string
+	LastFailure         time.Time
+	NextAttempt         time.Time
+}
+
+type reconnector struct {
+	policy  ReconnectPolicy
+	metrics *metrics
+
+	mu    sync.Mutex
+	links map[string]*LinkState
+}
+
+func newReconnector(policy ReconnectPolicy, metrics *metrics) *reconnector {
+	return &reconnector{
+		policy:  policy.withDefaults(),
+		metrics: metrics,
+		links:   map[string]*LinkState{},
+	}
+}
+
+// Attempt records attempt to connect to the address.
+func (r *reconnector) Attempt(address string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.link(address).Attempts++
+	r.metrics.ReconnectAttempted()
+}
+
+// Connected records successful connection and resets the delay.
+func (r *reconnector) Connected(address string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	link := r.link(address)
+	link.Connected = true
+	link.ConsecutiveFailures = 0
+	link.CircuitOpen = false
+	link.NextAttempt = time.Time{}
+}
+
+// Failed records failure and returns the delay before next attempt.
+func (r *reconnector) Failed(address string, err error, now time.Time) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	link := r.link(address)
+	link.Connected = false
+	link.ConsecutiveFailures++
+	link.LastFailure = now
+	if err != nil {
+		link.LastError = err.Error()
+	}
+
+	var delay time.Duration
+	if link.ConsecutiveFailures >= r.policy.CircuitOpenAfter {
+		link.CircuitOpen = true
+		delay = r.policy.CircuitOpenPeriod
+	} else {
+		d := float64(r.policy.InitialDelay)
+		for range link.ConsecutiveFailures - 1 {
+			d *= r.policy.Multiplier
+			if d >= float64(r.policy.MaxDelay) {
+				break
+			}
+		}
+		delay = time.Duration(min(d, float64(r.policy.MaxDelay)))
+	}
+
+	delay = r.jitter(delay)
+	link.NextAttempt = now.Add(delay)
+
+	return delay
+}
+
+// Duplicated records connection rejected because other one to the same peer exists and returns the delay
+// before next attempt. Peer is reachable, so it is not a failure and the delay doesn't grow.
+func (r *reconnector) Duplicated(address string, now time.Time) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	link := r.link(address)
+	link.Connected = false
+	link.ConsecutiveFailures = 0
+	link.CircuitOpen = false
+
+	delay := r.jitter(r.policy.InitialDelay)
+	link.NextAttempt = now.Add(delay)
+
+	return delay
+}
+
+// Wait records the end of the connection and waits before next attempt.
+func (r *reconnector) Wait(ctx context.Context, address string, err error) error {
+	var delay time.Duration
+	if errors.Is(err, errDuplicateConn) {
+		delay = r.Duplicated(address, time.Now())
+	} else {
+		delay = r.Failed(address, err, time.Now())
+	}
+
+	select {
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// Remove forgets the address.
+func (r *reconnector) Remove(address string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.links, address)
+}
+
+// Links returns states of the links.
+func (r *reconnector) Links() []LinkState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	links := make([]LinkState, 0, len(r.links))
+	for _, link := range r.links {
+		links = append(links, *link)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].Address < links[j].Address
+	})
+	return links
+}
+
+func (r *reconnector) jitter(delay time.Duration) time.Duration {
+	return time.Duration(float64(delay) * (1 + r.policy.Jitter*(2*rand.Float64()-1)))
+}
+
+func (r *reconnector) link(address string) *LinkState {
+	link, exists := r.links[address]
+	if !exists {
+		link = &LinkState{Address: address}
+		r.links[address] = link
+	}
+	return link
+}
@@ -0,0 +1,49 @@
+package wave
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconnectPolicyDefaults(t *testing.T) {
+	requireT := require.New(t)
+
+	policy := ReconnectPolicy{}.withDefaults()
+	requireT.Equal(float64(defaultMultiplier), policy.Multiplier)
+	requireT.Equal(defaultJitter, policy.Jitter)
+
+	policy = ReconnectPolicy{Jitter: -1}.withDefaults()
+	requireT.Zero(policy.Jitter)
+}
+
+func TestReconnectWithFixedDelayAndNoJitter(t *testing.T) {
+	requireT := require.New(t)
+
+	r := newReconnector(ReconnectPolicy{
+		InitialDelay: time.Second,
+		Multiplier:   1,
+		Jitter:       -1,
+	}, newMetrics())
+
+	now := time.Now()
+	for range 5 {
+		requireT.Equal(time.Second, r.Failed("address", nil, now))
+	}
+}
+
+func TestReconnectWithGrowingDelayAndNoJitter(t *testing.T) {
+	requireT := require.New(t)
+
+	r := newReconnector(ReconnectPolicy{
+		InitialDelay: time.Second,
+		MaxDelay:     5 * time.Second,
+		Jitter:       -1,
+	}, newMetrics())
+
+	now := time.Now()
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		requireT.Equal(expected, r.Failed("address", nil, now))
+	}
+}
@@ -0,0 +1,277 @@
+package wave
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/wave/wire"
+)
+
+// revisionStore keeps the latest revision of each message. It does no I/O and no locking, so it is guarded
+// by the caller.
+type revisionStore struct {
+	revs map[revDescriptor]revision
+}
+
+func newRevisionStore() *revisionStore {
+	return &revisionStore{
+		revs: map[revDescriptor]revision{},
+	}
+}
+
+// Apply stores the revision if it is newer than the stored one. False is returned for stale and duplicated
+// revisions.
+func (s *revisionStore) Apply(msgRev revision) bool {
+	revDesc := revDescriptor{
+		MessageDescriptor: msgRev.Header.Revision.Message,
+		Sender:            msgRev.Header.Sender,
+	}
+
+	if existingRevision, exists := s.revs[revDesc]; exists &&
+		existingRevision.Header.Revision.Index >= msgRev.Header.Revision.Index {
+		return false
+	}
+
+	s.revs[revDesc] = msgRev
+	return true
+}
+
+// Remove forgets the revision of the message, so any revision of it is applied later.
+func (s *revisionStore) Remove(revDesc revDescriptor) {
+	delete(s.revs, revDesc)
+}
+
+// Snapshot returns copy of the stored revisions.
+func (s *revisionStore) Snapshot() map[revDescriptor]revision {
+	revs := make(map[revDescriptor]revision, len(s.revs))
+	for revDesc, msgRev := range s.revs {
+		revs[revDesc] = msgRev
+	}
+	return revs
+}
+
+// Indexes returns indexes of the stored revisions.
+func (s *revisionStore) Indexes() map[revDescriptor]wire.Revision {
+	indexes := make(map[revDescriptor]wire.Revision, len(s.revs))
+	for revDesc, msgRev := range s.revs {
+		indexes[revDesc] = msgRev.Header.Revision.Index
+	}
+	return indexes
+}
+
+// Counts returns number of stored revisions per namespace.
+func (s *revisionStore) Counts() map[wire.Namespace]int {
+	counts := map[wire.Namespace]int{}
+	for revDesc := range s.revs {
+		counts[revDesc.Namespace]++
+	}
+	return counts
+}
+
+// peerIndexes returns indexes of the revisions included in the digest sent to the peer. Client knows revisions
+// it requested and the ones it sent, so only those are included.
+func peerIndexes(
+	revs map[revDescriptor]revision,
+	peerID wire.PeerID,
+	isServer bool,
+	reqs map[wire.MessageDescriptor]struct{},
+) map[revDescriptor]wire.Revision {
+	indexes := make(map[revDescriptor]wire.Revision, len(revs))
+	for revDesc, msgRev := range revs {
+		if isServer || revDesc.Sender == peerID || requested(reqs, revDesc.MessageDescriptor) {
+			indexes[revDesc] = msgRev.Header.Revision.Index
+		}
+	}
+	return indexes
+}
+
+// anyMessage is the message ID stored in requests matching all messages of the namespace.
+const anyMessage wire.MessageID = 0
+
+// requestedDescriptors returns descriptors of the messages requested by the peer, as a set and in the order
+// of requests.
+func requestedDescriptors(
+	requests []wire.NamespaceRequest,
+) (map[wire.MessageDescriptor]struct{}, []wire.MessageDescriptor) {
+	reqs := map[wire.MessageDescriptor]struct{}{}
+	var msgDescs []wire.MessageDescriptor
+	for _, r := range requests {
+		mIDs := r.MessageIDs
+		if len(mIDs) == 0 {
+			mIDs = []wire.MessageID{anyMessage}
+		}
+		for _, mID := range mIDs {
+			msgDesc := wire.MessageDescriptor{
+				Namespace: r.Namespace,
+				MessageID: mID,
+			}
+			reqs[msgDesc] = struct{}{}
+			msgDescs = append(msgDescs, msgDesc)
+		}
+	}
+	return reqs, msgDescs
+}
+
+// requested returns true if message has been requested, directly or by wildcard. Requests don't carry
+// fingerprints, so messages of any layout are sent and receivers verify them.
+func requested(reqs map[wire.MessageDescriptor]struct{}, msgDesc wire.MessageDescriptor) bool {
+	msgDesc.Fingerprint = 0
+	for _, d := range [...]wire.MessageDescriptor{
+		msgDesc,
+		{Namespace: msgDesc.Namespace, MessageID: anyMessage},
+		{Namespace: wire.AnyNamespace, MessageID: msgDesc.MessageID},
+		{Namespace: wire.AnyNamespace, MessageID: anyMessage},
+	} {
+		if _, exists := reqs[d]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeIndexes copies indexes from src to dst unless dst contains newer ones.
+func mergeIndexes(dst, src map[revDescriptor]wire.Revision) {
+	for revDesc, index := range src {
+		if dstIndex, exists := dst[revDesc]; !exists || dstIndex < index {
+			dst[revDesc] = index
+		}
+	}
+}
+
+// syncSender produces synchronization messages sent to the peer: digest first, then entries from the buckets
+// which differ, then the revisions missing on the peer.
+type syncSender struct {
+	revs       map[revDescriptor]wire.Revision
+	digest     *wire.Digest
+	mismatched [wire.DigestBuckets]bool
+}
+
+func newSyncSender(revs map[revDescriptor]wire.Revision) *syncSender {
+	return &syncSender{
+		revs:   revs,
+		digest: newDigest(revs),
+	}
+}
+
+// Digest returns digest of the local revisions.
+func (s *syncSender) Digest() *wire.Digest {
+	return s.digest
+}
+
+// Entries returns entries sent in response to the peer digest. DigestEnd follows them.
+func (s *syncSender) Entries(peerDigest *wire.Digest) []*wire.DigestEntry {
+	var entries []*wire.DigestEntry
+	s.mismatched, entries = digestEntries(s.revs, s.digest, peerDigest)
+	return entries
+}
+
+// Missing returns revisions sent to the peer after receiving its entries. SyncEnd follows them.
+func (s *syncSender) Missing(peerRevs map[revDescriptor]wire.Revision) []revDescriptor {
+	return missingRevisions(s.revs, s.mismatched, peerRevs)
+}
+
+// syncReceiver validates the order of synchronization messages received from the peer and collects its entries.
+type syncReceiver struct {
+	digestReceived, digestEndReceived, syncEndReceived bool
+	peerRevs                                           map[revDescriptor]wire.Revision
+}
+
+func newSyncReceiver() *syncReceiver {
+	return &syncReceiver{
+		peerRevs: map[revDescriptor]wire.Revision{},
+	}
+}
+
+// Digest is called when digest is received.
+func (r *syncReceiver) Digest() error {
+	if r.digestReceived {
+		return errors.New("unexpected digest")
+	}
+	r.digestReceived = true
+	return nil
+}
+
+// Entry is called when digest entry is received.
+func (r *syncReceiver) Entry(entry *wire.DigestEntry) error {
+	if r.digestEndReceived {
+		return errors.New("unexpected digest entry")
+	}
+	r.peerRevs[revDescriptor{
+		MessageDescriptor: entry.Revision.Message,
+		Sender:            entry.Sender,
+	}] = entry.Revision.Index
+	return nil
+}
+
+// DigestEnd is called when digest end is received. Entries collected from the peer are returned.
+func (r *syncReceiver) DigestEnd() (map[revDescriptor]wire.Revision, error) {
+	if r.digestEndReceived {
+		return nil, errors.New("unexpected digest end")
+	}
+	r.digestEndReceived = true
+	return r.peerRevs, nil
+}
+
+// Receive validates the synchronization message received from the peer. Digest and the entries collected until
+// digest end are returned, so the sender responds to them. Nil is returned for the other messages.
+func (r *syncReceiver) Receive(msg any) (any, error) {
+	switch msg := msg.(type) {
+	case *wire.Digest:
+		if err := r.Digest(); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	case *wire.DigestEntry:
+		return nil, r.Entry(msg)
+	case *wire.DigestEnd:
+		peerRevs, err := r.DigestEnd()
+		if err != nil {
+			return nil, err
+		}
+		return peerRevs, nil
+	case *wire.SyncEnd:
+		return nil, r.SyncEnd()
+	default:
+		return nil, errors.Errorf("unexpected synchronization message %T", msg)
+	}
+}
+
+// SyncEnd is called when sync end is received.
+func (r *syncReceiver) SyncEnd() error {
+	if !r.digestEndReceived || r.syncEndReceived {
+		return errors.New("unexpected sync end")
+	}
+	r.syncEndReceived = true
+	return nil
+}
+
+// sortRevDescriptors sorts descriptors, so revisions are sent in the same order on each run.
+func sortRevDescriptors(revDescs []revDescriptor) {
+	sort.Slice(revDescs, func(i, j int) bool {
+		return compareRevDescriptors(revDescs[i], revDescs[j]) < 0
+	})
+}
+
+func compareRevDescriptors(a, b revDescriptor) int {
+	switch {
+	case a.Namespace != b.Namespace:
+		if a.Namespace < b.Namespace {
+			return -1
+		}
+		return 1
+	case a.MessageID != b.MessageID:
+		if a.MessageID < b.MessageID {
+			return -1
+		}
+		return 1
+	case a.Fingerprint != b.Fingerprint:
+		if a.Fingerprint < b.Fingerprint {
+			return -1
+		}
+		return 1
+	default:
+		return bytes.Compare(a.Sender[:], b.Sender[:])
+	}
+}
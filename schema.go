@@ -0,0 +1,106 @@
+package wave
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/outofforest/proton"
+	"github.com/outofforest/wave/wire"
+)
+
+var fingerprints sync.Map
+
+// SchemaFingerprint returns the fingerprint of the message type. It is derived from the names, order and types
+// of exported fields, so it doesn't change when the type or its package is renamed. Zero is returned
+// for RawMessage because its layout is not known.
+func SchemaFingerprint(msg any) uint64 {
+	t := reflect.TypeOf(msg)
+	if t == nil {
+		return 0
+	}
+	// Messages are passed by pointer or by value, both have the same layout.
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(RawMessage{}) {
+		return 0
+	}
+	if fp, exists := fingerprints.Load(t); exists {
+		return fp.(uint64)
+	}
+
+	h := fnv.New64a()
+	writeSchema(h, t, map[reflect.Type]bool{})
+	fp := h.Sum64()
+	// Zero is reserved for unknown layout.
+	if fp == 0 {
+		fp = 1
+	}
+
+	fingerprints.Store(t, fp)
+	return fp
+}
+
+// messageFingerprints returns fingerprints of the message types listed by the marshaller. Nil is returned
+// if marshaller doesn't list its messages.
+func messageFingerprints(m proton.Marshaller) (map[wire.MessageID]uint64, error) {
+	msgTypes := m.Messages()
+	if len(msgTypes) == 0 {
+		return nil, nil
+	}
+
+	fps := make(map[wire.MessageID]uint64, len(msgTypes))
+	for _, msgType := range msgTypes {
+		msg := reflect.New(reflect.TypeOf(msgType)).Interface()
+		id, err := m.ID(msg)
+		if err != nil {
+			return nil, err
+		}
+		fps[wire.MessageID(id)] = SchemaFingerprint(msg)
+	}
+	return fps, nil
+}
+
+func writeSchema(w io.Writer, t reflect.Type, visiting map[reflect.Type]bool) {
+	switch t.Kind() {
+	case reflect.Pointer:
+		_, _ = io.WriteString(w, "*")
+		writeSchema(w, t.Elem(), visiting)
+	case reflect.Slice:
+		_, _ = io.WriteString(w, "[]")
+		writeSchema(w, t.Elem(), visiting)
+	case reflect.Array:
+		_, _ = fmt.Fprintf(w, "[%d]", t.Len())
+		writeSchema(w, t.Elem(), visiting)
+	case reflect.Map:
+		_, _ = io.WriteString(w, "map[")
+		writeSchema(w, t.Key(), visiting)
+		_, _ = io.WriteString(w, "]")
+		writeSchema(w, t.Elem(), visiting)
+	case reflect.Struct:
+		// Recursive types refer to the struct being described.
+		if visiting[t] {
+			_, _ = io.WriteString(w, "recursive")
+			return
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		_, _ = io.WriteString(w, "struct{")
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			_, _ = io.WriteString(w, f.Name+" ")
+			writeSchema(w, f.Type, visiting)
+			_, _ = io.WriteString(w, ";")
+		}
+		_, _ = io.WriteString(w, "}")
+	default:
+		_, _ = io.WriteString(w, t.Kind().String())
+	}
+}
@@ -0,0 +1,231 @@
+package wave
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/outofforest/wave/wire"
+)
+
+const (
+	failoverCooldown  = 30 * time.Second
+	rebalanceInterval = 10 * time.Second
+	probeTimeout      = 2 * time.Second
+)
+
+// ServerSelection defines how client chooses servers to connect to, if it connects to a subset of them.
+type ServerSelection int
+
+const (
+	// SelectRandom spreads clients randomly among servers. The choice is stable, so server joining the cluster
+	// takes over only its fair share of clients.
+	SelectRandom ServerSelection = iota
+
+	// SelectLatency chooses servers with the lowest latency.
+	SelectLatency
+)
+
+type serverSelector struct {
+	clientID    wire.PeerID
+	connections int
+	selection   ServerSelection
+
+	mu        sync.Mutex
+	latencies map[string]time.Duration
+	failures  map[string]time.Time
+	servers   map[string]wire.PeerID
+	connected map[string]struct{}
+}
+
+func newServerSelector(clientID wire.PeerID, connections int, selection ServerSelection) *serverSelector {
+	return &serverSelector{
+		clientID:    clientID,
+		connections: connections,
+		selection:   selection,
+		latencies:   map[string]time.Duration{},
+		failures:    map[string]time.Time{},
+		servers:     map[string]wire.PeerID{},
+		connected:   map[string]struct{}{},
+	}
+}
+
+// Failed records failure of the server.
+func (s *serverSelector) Failed(address string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[address] = now
+}
+
+// Identified records ID of the server reachable under the address. Addresses of the same server
+// are selected only once.
+func (s *serverSelector) Identified(address string, serverID wire.PeerID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.servers[address] = serverID
+}
+
+// Connected records successful connection to the server.
+func (s *serverSelector) Connected(address string, serverID wire.PeerID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.failures, address)
+	s.servers[address] = serverID
+	s.connected[address] = struct{}{}
+}
+
+// Disconnected records the end of the connection to the server.
+func (s *serverSelector) Disconnected(address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.connected, address)
+}
+
+// Probed records latency of the server.
+func (s *serverSelector) Probed(address string, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latencies[address] = latency
+}
+
+// ResetLatencies forgets measured latencies, so servers are probed again.
+func (s *serverSelector) ResetLatencies() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clear(s.latencies)
+}
+
+// Unprobed returns servers which need to be probed before selection.
+func (s *serverSelector) Unprobed(candidates map[string]struct{}, now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	candidates = s.unique(candidates, now)
+	if s.connections == 0 || s.connections >= len(candidates) || s.selection != SelectLatency {
+		return nil
+	}
+
+	var unprobed []string
+	for address := range candidates {
+		if _, exists := s.latencies[address]; !exists {
+			unprobed = append(unprobed, address)
+		}
+	}
+	return unprobed
+}
+
+// Select chooses servers to connect to. Servers which failed recently are chosen only if there are not enough
+// healthy ones. Only one address of each server is chosen.
+func (s *serverSelector) Select(candidates map[string]struct{}, now time.Time) map[string]struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	candidates = s.unique(candidates, now)
+	if s.connections == 0 || s.connections >= len(candidates) {
+		return candidates
+	}
+
+	type candidate struct {
+		Address  string
+		Failed   bool
+		FailedAt time.Time
+		Latency  time.Duration
+		Score    uint64
+	}
+
+	ranked := make([]candidate, 0, len(candidates))
+	for address := range candidates {
+		c := candidate{
+			Address: address,
+			Score:   s.score(address),
+		}
+		if failedAt, failed := s.failedAt(address, now); failed {
+			c.Failed = true
+			c.FailedAt = failedAt
+		}
+		if latency, exists := s.latencies[address]; exists {
+			c.Latency = latency
+		} else {
+			c.Latency = probeTimeout
+		}
+		ranked = append(ranked, c)
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		ci, cj := ranked[i], ranked[j]
+		switch {
+		case ci.Failed != cj.Failed:
+			return !ci.Failed
+		case ci.Failed && !ci.FailedAt.Equal(cj.FailedAt):
+			return ci.FailedAt.Before(cj.FailedAt)
+		case s.selection == SelectLatency && ci.Latency != cj.Latency:
+			return ci.Latency < cj.Latency
+		default:
+			return ci.Score > cj.Score
+		}
+	})
+
+	selected := make(map[string]struct{}, s.connections)
+	for _, c := range ranked[:s.connections] {
+		selected[c.Address] = struct{}{}
+	}
+	return selected
+}
+
+// unique returns candidates with one address of each known server. Connected address is preferred, so connection
+// isn't moved to other address of the same server. Other addresses are used if it fails.
+func (s *serverSelector) unique(candidates map[string]struct{}, now time.Time) map[string]struct{} {
+	unique := make(map[string]struct{}, len(candidates))
+	chosen := map[wire.PeerID]string{}
+	for address := range candidates {
+		serverID, exists := s.servers[address]
+		if !exists {
+			unique[address] = struct{}{}
+			continue
+		}
+		if other, exists := chosen[serverID]; !exists || s.better(address, other, now) {
+			chosen[serverID] = address
+		}
+	}
+	for _, address := range chosen {
+		unique[address] = struct{}{}
+	}
+	return unique
+}
+
+// better tells if address is preferred over other address of the same server.
+func (s *serverSelector) better(address, other string, now time.Time) bool {
+	_, connected := s.connected[address]
+	_, otherConnected := s.connected[other]
+	_, failed := s.failedAt(address, now)
+	_, otherFailed := s.failedAt(other, now)
+
+	switch {
+	case connected != otherConnected:
+		return connected
+	case failed != otherFailed:
+		return !failed
+	default:
+		return s.score(address) > s.score(other)
+	}
+}
+
+func (s *serverSelector) failedAt(address string, now time.Time) (time.Time, bool) {
+	failedAt, exists := s.failures[address]
+	return failedAt, exists && now.Sub(failedAt) < failoverCooldown
+}
+
+// score is the rendezvous hash of the client and server. Each client ranks servers differently.
+func (s *serverSelector) score(address string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(s.clientID[:])
+	_, _ = h.Write([]byte(address))
+	return h.Sum64()
+}
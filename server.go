@@ -1,8 +1,11 @@
 package wave
 
 import (
+	"bytes"
 	"context"
 	"net"
+	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -15,7 +18,12 @@ import (
 	"github.com/outofforest/wave/wire"
 )
 
-var errSameServer = errors.New("connected to myself")
+const sendQueueSize = 10
+
+var (
+	errSameServer    = errors.New("connected to myself")
+	errDuplicateConn = errors.New("connection to the peer already exists")
+)
 
 type revDescriptor struct {
 	wire.MessageDescriptor
@@ -29,124 +37,399 @@ type revision struct {
 }
 
 type chans struct {
-	Sender   chan<- revision
-	Receiver <-chan revision
+	Sender    chan<- revision
+	Receiver  <-chan revision
+	Preferred bool
+}
+
+type peerConn struct {
+	Sender chan<- revision
+	Peer   Peer
 }
 
 type serverConns struct {
-	mu    sync.RWMutex
-	conns map[wire.PeerID]chans
-	msgs  map[revDescriptor]revision
+	metrics *metrics
+
+	mu      sync.RWMutex
+	conns   map[<-chan revision]peerConn
+	servers map[wire.PeerID]chans
+	store   *revisionStore
 }
 
-func newServerConns() *serverConns {
+func newServerConns(metrics *metrics) *serverConns {
 	return &serverConns{
-		conns: map[wire.PeerID]chans{},
-		msgs:  map[revDescriptor]revision{},
+		metrics: metrics,
+		conns:   map[<-chan revision]peerConn{},
+		servers: map[wire.PeerID]chans{},
+		store:   newRevisionStore(),
 	}
 }
 
-func (c *serverConns) Add(peerID wire.PeerID) <-chan revision {
-	ch := make(chan revision, 10)
-
+// Add registers connection and returns snapshot of the revisions stored at the moment of registration.
+// Revisions broadcast later are delivered through the returned channel.
+// When two servers dial each other, both of them keep the preferred connection and reject the other one.
+// Client may connect to the same server many times, so its connections are never replaced.
+func (c *serverConns) Add(peer Peer, preferred bool) (<-chan revision, map[revDescriptor]revision, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if ch, ok := c.conns[peerID]; ok {
-		close(ch.Sender)
+	ch := make(chan revision, sendQueueSize)
+	if peer.Role == RoleServer {
+		if chs, ok := c.servers[peer.ID]; ok {
+			if chs.Preferred && !preferred {
+				return nil, nil, errors.WithStack(errDuplicateConn)
+			}
+			delete(c.conns, chs.Receiver)
+			close(chs.Sender)
+		}
+		c.servers[peer.ID] = chans{Sender: ch, Receiver: ch, Preferred: preferred}
 	}
+	c.conns[ch] = peerConn{Sender: ch, Peer: peer}
 
-	c.conns[peerID] = chans{Sender: ch, Receiver: ch}
+	return ch, c.store.Snapshot(), nil
+}
 
-	for _, m := range c.msgs {
-		ch <- m
-	}
+// Subscribe replaces requests of the peer connected through the channel and returns snapshot of the revisions
+// stored at the moment of the change.
+func (c *serverConns) Subscribe(ch <-chan revision, requests []wire.MessageDescriptor) map[revDescriptor]revision {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	return ch
+	if conn, exists := c.conns[ch]; exists {
+		conn.Peer.Requests = requests
+		c.conns[ch] = conn
+	}
+	return c.store.Snapshot()
 }
 
 func (c *serverConns) Remove(peerID wire.PeerID, ch <-chan revision) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if chs, exists := c.conns[peerID]; exists && chs.Receiver == ch {
-		delete(c.conns, peerID)
-		close(chs.Sender)
+	if chs, exists := c.servers[peerID]; exists && chs.Receiver == ch {
+		delete(c.servers, peerID)
+	}
+	if conn, exists := c.conns[ch]; exists {
+		delete(c.conns, ch)
+		close(conn.Sender)
 	}
 }
 
-func (c *serverConns) Broadcast(msgRev revision) {
+// Broadcast stores the revision and sends it to all the peers. False is returned if revision is not newer
+// than the stored one.
+func (c *serverConns) Broadcast(msgRev revision) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	revDesc := revDescriptor{
-		MessageDescriptor: msgRev.Header.Revision.Message,
-		Sender:            msgRev.Header.Sender,
+	if !c.store.Apply(msgRev) {
+		c.metrics.DedupDropped()
+		return false
 	}
 
-	if existingRevision, exists := c.msgs[revDesc]; exists &&
-		existingRevision.Header.Revision.Index >= msgRev.Header.Revision.Index {
-		return
+	start := time.Now()
+	for _, conn := range c.conns {
+		conn.Sender <- msgRev
 	}
+	c.metrics.Broadcasted(time.Since(start))
+
+	return true
+}
+
+// RevisionCounts returns number of stored revisions per namespace.
+func (c *serverConns) RevisionCounts() map[wire.Namespace]int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
-	c.msgs[revDesc] = msgRev
+	return c.store.Counts()
+}
+
+// Peers returns connected peers together with the depths of their queues.
+func (c *serverConns) Peers() ([]Peer, []PeerQueue) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
+	peers := make([]Peer, 0, len(c.conns))
+	queues := make([]PeerQueue, 0, len(c.conns))
 	for _, conn := range c.conns {
-		conn.Sender <- msgRev
+		peers = append(peers, conn.Peer)
+		queues = append(queues, PeerQueue{
+			PeerID:        conn.Peer.ID,
+			RemoteAddress: conn.Peer.RemoteAddress,
+			Depth:         len(conn.Sender),
+			Capacity:      cap(conn.Sender),
+		})
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].RemoteAddress < peers[j].RemoteAddress
+	})
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].RemoteAddress < queues[j].RemoteAddress
+	})
+
+	return peers, queues
+}
+
+// Revisions returns stored revisions.
+func (c *serverConns) Revisions() []StoredRevision {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	revs := make([]StoredRevision, 0, len(c.store.revs))
+	for revDesc, msgRev := range c.store.revs {
+		revs = append(revs, StoredRevision{
+			Namespace:   revDesc.Namespace,
+			MessageID:   revDesc.MessageID,
+			Sender:      revDesc.Sender,
+			Index:       msgRev.Header.Revision.Index,
+			Fingerprint: revDesc.Fingerprint,
+			Size:        len(msgRev.Content),
+		})
 	}
+	sort.Slice(revs, func(i, j int) bool {
+		ri, rj := revs[i], revs[j]
+		switch {
+		case ri.Namespace != rj.Namespace:
+			return ri.Namespace < rj.Namespace
+		case ri.MessageID != rj.MessageID:
+			return ri.MessageID < rj.MessageID
+		default:
+			return bytes.Compare(ri.Sender[:], rj.Sender[:]) < 0
+		}
+	})
+
+	return revs
 }
 
+const (
+	defaultGossipInterval   = time.Second
+	defaultSuspicionTimeout = 5 * time.Second
+	defaultRemovalTimeout   = 30 * time.Second
+)
+
 // ServerConfig defines server configuration.
 type ServerConfig struct {
+	// Servers are the seed addresses used to join the cluster. Besides host:port, addresses like
+	// tcp://host:port, unix:///path/to/socket and ws://host:port are accepted.
 	Servers        []string
 	MaxMessageSize uint64
+
+	// AdvertiseAddress is the address other servers use to connect to this one.
+	// If empty, address of the first listener is used, with unspecified IP replaced by the IP of the network
+	// interface.
+	AdvertiseAddress string
+
+	// GossipInterval is the interval between subsequent exchanges of the member list.
+	GossipInterval time.Duration
+
+	// SuspicionTimeout is the time after which member not sending heartbeats is suspected.
+	SuspicionTimeout time.Duration
+
+	// RemovalTimeout is the time after which member not sending heartbeats is removed from the cluster.
+	RemovalTimeout time.Duration
+
+	// Reconnect defines delays between attempts to connect to other servers.
+	Reconnect ReconnectPolicy
+
+	// AdminAddress is the address of the HTTP listener serving read-only admin API. If empty, API is disabled.
+	// Wire protocol doesn't authenticate peers, so API isn't authenticated either and should be exposed
+	// to trusted networks only.
+	AdminAddress string
+
+	// MetricsAddress is the address of the HTTP listener serving metrics in Prometheus format.
+	// If empty, metrics are not exposed.
+	MetricsAddress string
+
+	// WebSocketAddress is the address of the HTTP listener accepting WebSocket connections. Peers exchange
+	// the same frames as on TCP connections, sent as binary WebSocket messages. If empty, WebSocket is disabled.
+	WebSocketAddress string
+
+	// Tracer records spans of traced revisions passing through the server.
+	Tracer SpanRecorder
+
+	// Dialer connects to other servers. If nil, Dial is used.
+	Dialer Dialer
+}
+
+// Server propagates messages between clients and other servers.
+type Server struct {
+	config  ServerConfig
+	id      wire.PeerID
+	conns   *serverConns
+	members *membership
+	links   *reconnector
+	metrics *metrics
+
+	mu      sync.Mutex
+	dialing map[wire.PeerID]struct{}
+}
+
+// NewServer creates new server.
+func NewServer(config ServerConfig) (*Server, error) {
+	serverID, err := peerID()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.GossipInterval == 0 {
+		config.GossipInterval = defaultGossipInterval
+	}
+	if config.SuspicionTimeout == 0 {
+		config.SuspicionTimeout = defaultSuspicionTimeout
+	}
+	if config.RemovalTimeout == 0 {
+		config.RemovalTimeout = defaultRemovalTimeout
+	}
+
+	m := newMetrics()
+	conns := newServerConns(m)
+	m.revisions = conns.RevisionCounts
+
+	return &Server{
+		config:  config,
+		id:      serverID,
+		conns:   conns,
+		members: newMembership(serverID),
+		links:   newReconnector(config.Reconnect, m),
+		metrics: m,
+		dialing: map[wire.PeerID]struct{}{},
+	}, nil
 }
 
 // RunServer runs server.
 func RunServer(ctx context.Context, ls net.Listener, config ServerConfig) error {
-	serverID, err := peerID()
+	s, err := NewServer(config)
 	if err != nil {
 		return err
 	}
+	return s.Run(ctx, ls)
+}
+
+// ID returns ID of the server.
+func (s *Server) ID() wire.PeerID {
+	return s.id
+}
+
+// Members returns current members of the cluster.
+func (s *Server) Members() []Member {
+	return s.members.Members()
+}
 
-	conns := newServerConns()
-	connConfig := resonance.Config{
-		MaxMessageSize: config.MaxMessageSize,
+// Links returns states of the connections to other servers.
+func (s *Server) Links() []LinkState {
+	return s.links.Links()
+}
+
+// MetricsHandler returns HTTP handler serving metrics in Prometheus text format.
+func (s *Server) MetricsHandler() http.Handler {
+	return s.metrics.Handler()
+}
+
+// Run runs server accepting connections on all the listeners.
+func (s *Server) Run(ctx context.Context, listeners ...net.Listener) error {
+	if len(listeners) == 0 {
+		return errors.New("no listeners specified")
 	}
 
-	return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) (err error) {
-		spawn("server", parallel.Fail, func(ctx context.Context) error {
-			return resonance.RunServer(ctx, ls, connConfig,
-				func(ctx context.Context, c *resonance.Connection) error {
-					return runServerConn(ctx, serverID, c, conns)
-				})
-		})
+	address := s.config.AdvertiseAddress
+	if address == "" {
+		var err error
+		address, err = listenerAddress(listeners[0])
+		if err != nil {
+			return err
+		}
+	}
+	s.members.SetAddress(address)
+
+	connConfig := transportConfig{
+		Connection: resonance.Config{
+			MaxMessageSize: s.config.MaxMessageSize,
+		},
+		Metrics: s.metrics,
+		Dialer:  s.config.Dialer,
+	}
 
-		for _, s := range config.Servers {
-			spawn("client", parallel.Continue, func(ctx context.Context) error {
-				log := logger.Get(ctx)
+	return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
+		joinedCh := make(chan wire.PeerID, 10)
 
-				for {
-					err := resonance.RunClient(ctx, s, connConfig,
-						func(ctx context.Context, c *resonance.Connection) error {
-							return runServerConn(ctx, serverID, c, conns)
-						})
+		accept := func(ctx context.Context, c *connection, remoteAddress string) error {
+			_, err := s.runConn(ctx, c, "", remoteAddress, joinedCh)
+			// Dialing side reports connection to itself.
+			if errors.Is(err, errDuplicateConn) || errors.Is(err, errSameServer) {
+				return nil
+			}
+			return err
+		}
 
-					if ctx.Err() != nil {
-						return errors.WithStack(ctx.Err())
-					}
+		for _, ls := range listeners {
+			spawn("server", parallel.Fail, func(ctx context.Context) error {
+				return serve(ctx, ls, connConfig, accept)
+			})
+		}
+		if s.config.WebSocketAddress != "" {
+			wsLs := newWSListener(s.config.WebSocketAddress)
+			spawn("websocket", parallel.Fail, func(ctx context.Context) error {
+				return serveHTTP(ctx, s.config.WebSocketAddress, wsLs)
+			})
+			spawn("websocketServer", parallel.Fail, func(ctx context.Context) error {
+				return serve(ctx, wsLs, connConfig, accept)
+			})
+		}
+		if s.config.AdminAddress != "" {
+			spawn("admin", parallel.Fail, func(ctx context.Context) error {
+				return serveHTTP(ctx, s.config.AdminAddress, s.AdminHandler())
+			})
+		}
+		if s.config.MetricsAddress != "" {
+			spawn("metrics", parallel.Fail, func(ctx context.Context) error {
+				return serveHTTP(ctx, s.config.MetricsAddress, s.MetricsHandler())
+			})
+		}
+		spawn("membership", parallel.Fail, func(ctx context.Context) error {
+			log := logger.Get(ctx)
 
-					if errors.Is(err, errSameServer) {
-						return nil
-					}
+			ticker := time.NewTicker(s.config.GossipInterval)
+			defer ticker.Stop()
 
-					log.Error("Wave connection failed", zap.String("server", s), zap.Error(err))
-					select {
-					case <-ctx.Done():
-						return errors.WithStack(ctx.Err())
-					case <-time.After(time.Second):
-					}
+			for {
+				select {
+				case <-ctx.Done():
+					return errors.WithStack(ctx.Err())
+				case <-ticker.C:
+				}
+
+				s.members.Heartbeat()
+				suspected, removed := s.members.Check(time.Now(), s.config.SuspicionTimeout, s.config.RemovalTimeout)
+				for _, id := range suspected {
+					log.Warn("Wave member suspected", zap.Stringer("member", id))
+				}
+				for _, id := range removed {
+					log.Info("Wave member removed", zap.Stringer("member", id))
+				}
+			}
+		})
+		spawn("mesh", parallel.Fail, func(ctx context.Context) error {
+			for {
+				var id wire.PeerID
+				select {
+				case <-ctx.Done():
+					return errors.WithStack(ctx.Err())
+				case id = <-joinedCh:
 				}
+
+				s.mu.Lock()
+				if _, exists := s.dialing[id]; !exists {
+					s.dialing[id] = struct{}{}
+					spawn("member", parallel.Continue, func(ctx context.Context) error {
+						return s.runMember(ctx, id, connConfig, joinedCh)
+					})
+				}
+				s.mu.Unlock()
+			}
+		})
+
+		for _, address := range s.config.Servers {
+			spawn("seed", parallel.Continue, func(ctx context.Context) error {
+				return s.runSeed(ctx, address, connConfig, joinedCh)
 			})
 		}
 
@@ -154,50 +437,182 @@ func RunServer(ctx context.Context, ls net.Listener, config ServerConfig) error
 	})
 }
 
-func runServerConn(
+// runSeed connects to the seed server. Once the seed becomes a member of the cluster, connection to it is
+// maintained by the membership.
+func (s *Server) runSeed(
 	ctx context.Context,
-	serverID wire.PeerID,
-	c *resonance.Connection,
-	conns *serverConns,
+	address string,
+	connConfig transportConfig,
+	joinedCh chan<- wire.PeerID,
 ) error {
-	m := wire.NewMarshaller()
+	defer s.links.Remove(address)
+
+	log := logger.Get(ctx)
+
+	for {
+		s.links.Attempt(address)
+		peerID, err := s.dial(ctx, address, connConfig, joinedCh)
+		if ctx.Err() != nil {
+			return errors.WithStack(ctx.Err())
+		}
+
+		switch {
+		case errors.Is(err, errSameServer):
+			return nil
+		case !errors.Is(err, errDuplicateConn):
+			log.Error("Wave connection failed", zap.String("server", address), zap.Error(err))
+		}
+
+		if err := s.links.Wait(ctx, address, err); err != nil {
+			return err
+		}
+
+		for {
+			if _, exists := s.members.Address(peerID); !exists {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				return errors.WithStack(ctx.Err())
+			case <-time.After(s.config.GossipInterval):
+			}
+		}
+	}
+}
+
+// runMember connects to the member of the cluster until it is removed.
+func (s *Server) runMember(
+	ctx context.Context,
+	peerID wire.PeerID,
+	connConfig transportConfig,
+	joinedCh chan<- wire.PeerID,
+) error {
+	log := logger.Get(ctx)
+
+	for {
+		s.mu.Lock()
+		address, exists := s.members.Address(peerID)
+		if !exists {
+			delete(s.dialing, peerID)
+		}
+		s.mu.Unlock()
+
+		if !exists {
+			return nil
+		}
+
+		s.links.Attempt(address)
+		_, err := s.dial(ctx, address, connConfig, joinedCh)
+		if ctx.Err() != nil {
+			return errors.WithStack(ctx.Err())
+		}
+
+		switch {
+		case errors.Is(err, errSameServer):
+			// Address of the member leads to this server, so member is not dialed again until it rejoins.
+			log.Warn("Wave member advertises address of this server", zap.Stringer("member", peerID),
+				zap.String("server", address))
+
+			s.mu.Lock()
+			delete(s.dialing, peerID)
+			s.mu.Unlock()
+			s.links.Remove(address)
+
+			return nil
+		case !errors.Is(err, errDuplicateConn):
+			log.Error("Wave connection failed", zap.String("server", address), zap.Error(err))
+		}
+
+		if err := s.links.Wait(ctx, address, err); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) dial(
+	ctx context.Context,
+	address string,
+	connConfig transportConfig,
+	joinedCh chan<- wire.PeerID,
+) (wire.PeerID, error) {
+	var peerID wire.PeerID
+	err := dial(ctx, address, connConfig,
+		func(ctx context.Context, c *connection) error {
+			var err error
+			peerID, err = s.runConn(ctx, c, address, address, joinedCh)
+			return err
+		})
+	return peerID, err
+}
+
+// runConn runs connection with the peer. Address is empty if connection has been accepted.
+func (s *Server) runConn(
+	ctx context.Context,
+	c *connection,
+	address, remoteAddress string,
+	joinedCh chan<- wire.PeerID,
+) (wire.PeerID, error) {
+	m := wire.NewBoundedMarshaller()
 
 	if _, err := c.SendProton(&wire.Hello{
-		PeerID:   serverID,
+		PeerID:   s.id,
 		IsServer: true,
 	}, m); err != nil {
-		return err
+		return wire.PeerID{}, err
 	}
 
 	msg, _, err := c.ReceiveProton(m)
 	if err != nil {
-		return err
+		return wire.PeerID{}, err
 	}
 
 	helloMsg, ok := msg.(*wire.Hello)
 	if !ok {
-		return errors.New("hello message expected")
+		return wire.PeerID{}, errors.New("hello message expected")
 	}
 
-	if helloMsg.PeerID == serverID {
-		return errSameServer
+	if helloMsg.PeerID == s.id {
+		return helloMsg.PeerID, errSameServer
 	}
 
-	reqs := map[wire.MessageDescriptor]struct{}{}
-	for _, r := range helloMsg.Requests {
-		for _, mID := range r.MessageIDs {
-			reqs[wire.MessageDescriptor{
-				Namespace: r.Namespace,
-				MessageID: mID,
-			}] = struct{}{}
-		}
+	// Client measuring latency closes connection right after receiving hello.
+	if helloMsg.Probe {
+		return helloMsg.PeerID, nil
+	}
+
+	peer := Peer{
+		ID:            helloMsg.PeerID,
+		RemoteAddress: remoteAddress,
+		Role:          RoleClient,
+	}
+	if helloMsg.IsServer {
+		peer.Role = RoleServer
 	}
 
-	sendCh := conns.Add(helloMsg.PeerID)
+	// Connection dialed by the server with lower ID is preferred, so both sides make the same decision.
+	dialed := address != ""
+	preferred := dialed == (bytes.Compare(s.id[:], helloMsg.PeerID[:]) < 0)
+	session, err := newServerSession(s.conns, peer, helloMsg, preferred)
+	if err != nil {
+		return helloMsg.PeerID, err
+	}
 
-	return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
+	defer s.metrics.Connected(peer.Role)()
+
+	if dialed {
+		s.links.Connected(address)
+	}
+
+	// Peer sends one digest and one set of entries, so the channel never blocks.
+	syncCh := make(chan any, 2)
+	subscriptionCh := make(chan []revision)
+
+	return helloMsg.PeerID, parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
 		spawn("receiver", parallel.Fail, func(ctx context.Context) error {
-			defer conns.Remove(helloMsg.PeerID, sendCh)
+			defer session.Close()
+
+			log := logger.Get(ctx)
 
 			for {
 				msg, _, err := c.ReceiveProton(m)
@@ -205,43 +620,128 @@ func runServerConn(
 					return err
 				}
 
-				headerMsg, ok := msg.(*wire.Header)
-				if !ok {
-					return errors.New("header message expected")
-				}
+				switch msg := msg.(type) {
+				case *wire.Header:
+					start := time.Now()
 
-				contentMsg, _, err := c.ReceiveRawBytes()
-				if err != nil {
-					return err
-				}
+					contentMsg, _, err := c.ReceiveRawBytes()
+					if err != nil {
+						return err
+					}
 
-				conns.Broadcast(revision{
-					Header:  headerMsg,
-					Content: contentMsg,
-				})
+					// Header is forwarded as is, so trace context reaches all the receivers unchanged.
+					// Revision dropped as duplicate doesn't go any further, so its span is not recorded.
+					if s.conns.Broadcast(revision{
+						Header:  msg,
+						Content: contentMsg,
+					}) {
+						recordSpan(s.config.Tracer, SpanServerReceive, s.id, msg, start)
+					}
+				case *wire.Digest, *wire.DigestEntry, *wire.DigestEnd, *wire.SyncEnd:
+					item, err := session.Receive(msg)
+					if err != nil {
+						return err
+					}
+					if item != nil {
+						syncCh <- item
+					}
+				case *wire.Subscription:
+					// Sender never takes the lock of the connections, because broadcast holding it waits
+					// for the sender.
+					replayed, err := session.Subscribe(msg)
+					if err != nil {
+						return err
+					}
+					select {
+					case <-ctx.Done():
+						return errors.WithStack(ctx.Err())
+					case subscriptionCh <- replayed:
+					}
+				case *wire.Members:
+					if !helloMsg.IsServer {
+						return errors.New("unexpected members")
+					}
+					for _, member := range s.members.Merge(msg.Members, time.Now()) {
+						log.Info("Wave member joined",
+							zap.Stringer("member", member.PeerID), zap.String("address", member.Address))
+
+						// Member with lower ID dials the one with higher ID.
+						if bytes.Compare(s.id[:], member.PeerID[:]) >= 0 {
+							continue
+						}
+						select {
+						case <-ctx.Done():
+							return errors.WithStack(ctx.Err())
+						case joinedCh <- member.PeerID:
+						}
+					}
+				default:
+					return errors.New("header message expected")
+				}
 			}
 		})
 		spawn("sender", parallel.Fail, func(ctx context.Context) error {
 			defer func() {
-				for range sendCh {
+				for range session.SendCh() {
 				}
 			}()
 			defer c.Close()
 
-			for msgRev := range sendCh {
-				if _, exists := reqs[msgRev.Header.Revision.Message]; !exists && !helloMsg.IsServer {
-					continue
+			send := func(items []any) error {
+				for _, item := range items {
+					msgRev, ok := item.(revision)
+					if !ok {
+						if _, err := c.SendProton(item, m); err != nil {
+							return err
+						}
+						continue
+					}
+					if _, err := c.SendProton(msgRev.Header, m); err != nil {
+						return err
+					}
+					if _, err := c.SendRawBytes(msgRev.Content); err != nil {
+						return err
+					}
 				}
+				return nil
+			}
 
-				if _, err := c.SendProton(msgRev.Header, m); err != nil {
-					return err
-				}
-				if _, err := c.SendRawBytes(msgRev.Content); err != nil {
-					return err
-				}
+			// Peers exchange digests to find out which revisions are missing on the other side.
+			if _, err := c.SendProton(session.Digest(), m); err != nil {
+				return err
 			}
 
-			return nil
+			// Servers merge the member list into their own, clients use it to discover servers.
+			if _, err := c.SendProton(s.members.Gossip(), m); err != nil {
+				return err
+			}
+
+			gossipTicker := time.NewTicker(s.config.GossipInterval)
+			defer gossipTicker.Stop()
+
+			for {
+				select {
+				case msgRev, ok := <-session.SendCh():
+					if !ok {
+						return nil
+					}
+					if err := send(session.Send(msgRev)); err != nil {
+						return err
+					}
+				case <-gossipTicker.C:
+					if _, err := c.SendProton(s.members.Gossip(), m); err != nil {
+						return err
+					}
+				case replayed := <-subscriptionCh:
+					if err := send(session.Replay(replayed)); err != nil {
+						return err
+					}
+				case item := <-syncCh:
+					if err := send(session.Respond(item)); err != nil {
+						return err
+					}
+				}
+			}
 		})
 
 		return nil
@@ -0,0 +1,166 @@
+package wave
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/parallel"
+	"github.com/outofforest/qa"
+	"github.com/outofforest/resonance"
+	"github.com/outofforest/wave/wire"
+)
+
+func TestSubscriptionWhileSendQueueIsFull(t *testing.T) {
+	requireT := require.New(t)
+
+	s, err := NewServer(ServerConfig{MaxMessageSize: fuzzMaxMessageSize})
+	requireT.NoError(err)
+
+	ctx, cancel := context.WithCancel(qa.NewContext(t))
+	defer cancel()
+
+	serverConn, peerConn := net.Pipe()
+	defer peerConn.Close()
+
+	// Deadlock makes the peer wait for messages which are never sent.
+	requireT.NoError(peerConn.SetDeadline(time.Now().Add(fuzzTimeout)))
+
+	connErrCh := make(chan error, 1)
+	go func() {
+		connErrCh <- runConnection(ctx, serverConn, transportConfig{
+			Connection: resonance.Config{MaxMessageSize: fuzzMaxMessageSize},
+			Metrics:    s.metrics,
+		}, func(ctx context.Context, c *connection) error {
+			_, err := s.runConn(ctx, c, "", "peer", make(chan wire.PeerID))
+			return err
+		})
+	}()
+
+	peer := resonance.NewConnection(peerConn, resonance.Config{MaxMessageSize: fuzzMaxMessageSize})
+	m := wire.NewMarshaller()
+
+	msg, _, err := peer.ReceiveProton(m)
+	requireT.NoError(err)
+	requireT.IsType(&wire.Hello{}, msg)
+
+	_, err = peer.SendProton(&wire.Hello{
+		PeerID: fuzzPeerID,
+		Requests: []wire.NamespaceRequest{
+			{
+				Namespace:  "namespace",
+				MessageIDs: []wire.MessageID{1},
+			},
+		},
+	}, m)
+	requireT.NoError(err)
+
+	msg, _, err = peer.ReceiveProton(m)
+	requireT.NoError(err)
+	requireT.IsType(&wire.Digest{}, msg)
+	msg, _, err = peer.ReceiveProton(m)
+	requireT.NoError(err)
+	requireT.IsType(&wire.Members{}, msg)
+
+	content, err := encodeFrame(&wire.DigestEnd{}, m, fuzzMaxMessageSize)
+	requireT.NoError(err)
+
+	// Peer doesn't read, so sender waits for the connection, the queue fills up and broadcast keeps
+	// waiting for the sender.
+	const revisions = 3 * sendQueueSize
+	broadcastCh := make(chan struct{}, revisions)
+	go func() {
+		for i := range revisions {
+			s.conns.Broadcast(revision{
+				Header: &wire.Header{
+					Sender: wire.PeerID{0x02},
+					Revision: wire.RevisionDescriptor{
+						Message: wire.MessageDescriptor{
+							Namespace: "namespace",
+							MessageID: 1,
+						},
+						Index: wire.Revision(i + 1),
+					},
+				},
+				Content: content,
+			})
+			broadcastCh <- struct{}{}
+		}
+	}()
+	for range sendQueueSize + 1 {
+		<-broadcastCh
+	}
+
+	_, err = peer.SendProton(&wire.Subscription{
+		Requests: []wire.NamespaceRequest{
+			{
+				Namespace:  "namespace",
+				MessageIDs: []wire.MessageID{1, 2},
+			},
+		},
+	}, m)
+	requireT.NoError(err)
+
+	for {
+		msg, _, err := peer.ReceiveProton(m)
+		requireT.NoError(err)
+		header, ok := msg.(*wire.Header)
+		requireT.True(ok)
+		_, _, err = peer.ReceiveRawBytes()
+		requireT.NoError(err)
+
+		if header.Revision.Index == revisions {
+			break
+		}
+	}
+
+	cancel()
+	_ = peerConn.Close()
+	<-connErrCh
+}
+
+func TestMemberAdvertisingAddressOfServerIsDropped(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+	group := qa.NewGroup(ctx, t)
+
+	s, err := NewServer(ServerConfig{MaxMessageSize: fuzzMaxMessageSize})
+	requireT.NoError(err)
+
+	ls, err := Listen("localhost:0")
+	requireT.NoError(err)
+	group.Spawn("server", parallel.Fail, func(ctx context.Context) error {
+		defer ls.Close()
+
+		return s.Run(ctx, ls)
+	})
+
+	// Member advertises address of the server, so dialing it leads to the server itself.
+	s.members.Merge([]wire.Member{
+		{
+			PeerID:    fuzzPeerID,
+			Address:   ls.Addr().String(),
+			Heartbeat: 1,
+		},
+	}, time.Now())
+	s.mu.Lock()
+	s.dialing[fuzzPeerID] = struct{}{}
+	s.mu.Unlock()
+
+	memberCtx, cancel := context.WithTimeout(ctx, fuzzTimeout)
+	defer cancel()
+
+	requireT.NoError(s.runMember(memberCtx, fuzzPeerID, transportConfig{
+		Connection: resonance.Config{MaxMessageSize: fuzzMaxMessageSize},
+		Metrics:    s.metrics,
+	}, make(chan wire.PeerID)))
+	requireT.Empty(s.Links())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requireT.NotContains(s.dialing, fuzzPeerID)
+}
@@ -0,0 +1,224 @@
+package wave
+
+import (
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/wave/wire"
+)
+
+// serverSession is the replication logic of the server's connection with a peer. It does no I/O, so the connection
+// and the simulation drive the same logic. Receive and Subscribe are called by the receiver, the other methods
+// by the sender. Produced items are revisions sent as the header followed by the content, and protocol messages.
+type serverSession struct {
+	conns    *serverConns
+	peerID   wire.PeerID
+	isServer bool
+
+	sendCh       <-chan revision
+	revs         map[revDescriptor]revision
+	syncSender   *syncSender
+	syncReceiver *syncReceiver
+
+	// Requests are replaced by the receiver and used by the sender.
+	reqs atomic.Pointer[map[wire.MessageDescriptor]struct{}]
+}
+
+// newServerSession registers connection of the peer which sent the hello.
+func newServerSession(conns *serverConns, peer Peer, hello *wire.Hello, preferred bool) (*serverSession, error) {
+	reqs, msgDescs := requestedDescriptors(hello.Requests)
+	peer.Requests = msgDescs
+
+	sendCh, revs, err := conns.Add(peer, preferred)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &serverSession{
+		conns:        conns,
+		peerID:       hello.PeerID,
+		isServer:     hello.IsServer,
+		sendCh:       sendCh,
+		revs:         revs,
+		syncSender:   newSyncSender(peerIndexes(revs, hello.PeerID, hello.IsServer, reqs)),
+		syncReceiver: newSyncReceiver(),
+	}
+	s.reqs.Store(&reqs)
+	return s, nil
+}
+
+// SendCh returns channel delivering revisions broadcast after the session is created. It is closed when
+// the session is closed or replaced by the preferred connection to the same server.
+func (s *serverSession) SendCh() <-chan revision {
+	return s.sendCh
+}
+
+// Close unregisters the connection.
+func (s *serverSession) Close() {
+	s.conns.Remove(s.peerID, s.sendCh)
+}
+
+// Digest returns digest sent to the peer first.
+func (s *serverSession) Digest() *wire.Digest {
+	return s.syncSender.Digest()
+}
+
+// Receive handles synchronization message received from the peer. Returned item, if not nil, is passed to Respond.
+func (s *serverSession) Receive(msg any) (any, error) {
+	return s.syncReceiver.Receive(msg)
+}
+
+// Subscribe replaces requests of the client. Current values of the newly requested messages are returned,
+// to be passed to Replay. Requests are replaced before the snapshot is taken, so revisions broadcast later
+// are sent through the channel and the earlier ones are in the snapshot.
+func (s *serverSession) Subscribe(msg *wire.Subscription) ([]revision, error) {
+	if s.isServer {
+		return nil, errors.New("unexpected subscription")
+	}
+
+	newReqs, msgDescs := requestedDescriptors(msg.Requests)
+	oldReqs := *s.reqs.Swap(&newReqs)
+	revs := s.conns.Subscribe(s.sendCh, msgDescs)
+
+	var added []revDescriptor
+	for revDesc := range revs {
+		if revDesc.Sender != s.peerID && requested(newReqs, revDesc.MessageDescriptor) &&
+			!requested(oldReqs, revDesc.MessageDescriptor) {
+			added = append(added, revDesc)
+		}
+	}
+	sortRevDescriptors(added)
+
+	replayed := make([]revision, 0, len(added))
+	for _, revDesc := range added {
+		replayed = append(replayed, revs[revDesc])
+	}
+	return replayed, nil
+}
+
+// Send returns items sending the revision to the peer. Clients receive only the requested revisions.
+func (s *serverSession) Send(msgRev revision) []any {
+	if !s.isServer && !requested(*s.reqs.Load(), msgRev.Header.Revision.Message) {
+		return nil
+	}
+	return []any{msgRev}
+}
+
+// Replay returns items sending revisions returned by Subscribe.
+func (s *serverSession) Replay(replayed []revision) []any {
+	var items []any
+	for _, msgRev := range replayed {
+		items = append(items, s.Send(msgRev)...)
+	}
+	s.conns.metrics.Replayed(len(replayed))
+	return items
+}
+
+// Respond returns items sent in response to the item returned by Receive: entries for the peer digest
+// and revisions missing on the peer once its entries are received.
+func (s *serverSession) Respond(item any) []any {
+	switch item := item.(type) {
+	case *wire.Digest:
+		return digestResponse(s.syncSender.Entries(item))
+	case map[revDescriptor]wire.Revision:
+		missing := s.syncSender.Missing(item)
+		var items []any
+		for _, revDesc := range missing {
+			items = append(items, s.Send(s.revs[revDesc])...)
+		}
+		s.conns.metrics.Replayed(len(missing))
+		return append(items, &wire.SyncEnd{})
+	default:
+		return nil
+	}
+}
+
+// clientSession is the replication logic of the client's connection with a server. It does no I/O, so
+// the connection and the simulation drive the same logic. Receive is called by the receiver, the other methods
+// by the sender. Produced items are messages queued by the client and protocol messages.
+type clientSession struct {
+	conns *clientConns
+
+	queue        *sendQueue
+	sent         map[revDescriptor]msgToSend
+	syncSender   *syncSender
+	syncReceiver *syncReceiver
+}
+
+// newClientSession registers connection of the client. Requests version is the one sent in hello.
+func newClientSession(conns *clientConns, requestsVersion uint64) *clientSession {
+	queue, sent, revIndexes := conns.Add(requestsVersion)
+	return &clientSession{
+		conns:        conns,
+		queue:        queue,
+		sent:         sent,
+		syncSender:   newSyncSender(revIndexes),
+		syncReceiver: newSyncReceiver(),
+	}
+}
+
+// Ready returns channel signaled when client queues messages or session is closed.
+func (s *clientSession) Ready() <-chan struct{} {
+	return s.queue.Ready()
+}
+
+// Pop returns items sending messages queued by the client since the previous call. False is returned
+// if session is closed.
+func (s *clientSession) Pop() ([]any, bool) {
+	toSend, ok := s.queue.Pop()
+	if !ok {
+		return nil, false
+	}
+	items := make([]any, 0, len(toSend))
+	for _, msg := range toSend {
+		items = append(items, msg)
+	}
+	return items, true
+}
+
+// Close unregisters the connection.
+func (s *clientSession) Close() {
+	s.conns.Remove(s.queue)
+}
+
+// Digest returns digest sent to the server first. It tells server which revisions client already has,
+// so they are not sent again.
+func (s *clientSession) Digest() *wire.Digest {
+	return s.syncSender.Digest()
+}
+
+// Receive handles synchronization message received from the server. Returned item, if not nil, is passed
+// to Respond.
+func (s *clientSession) Receive(msg any) (any, error) {
+	return s.syncReceiver.Receive(msg)
+}
+
+// Respond returns items sent in response to the item returned by Receive: entries for the server digest
+// and messages missing on the server once its entries are received.
+func (s *clientSession) Respond(item any) []any {
+	switch item := item.(type) {
+	case *wire.Digest:
+		return digestResponse(s.syncSender.Entries(item))
+	case map[revDescriptor]wire.Revision:
+		// Only the messages sent by this client may be delivered to the server.
+		var items []any
+		for _, revDesc := range s.syncSender.Missing(item) {
+			if toSend, exists := s.sent[revDesc]; exists {
+				items = append(items, toSend)
+			}
+		}
+		s.conns.metrics.Replayed(len(items))
+		return append(items, &wire.SyncEnd{})
+	default:
+		return nil
+	}
+}
+
+func digestResponse(entries []*wire.DigestEntry) []any {
+	items := make([]any, 0, len(entries)+1)
+	for _, e := range entries {
+		items = append(items, e)
+	}
+	return append(items, &wire.DigestEnd{})
+}
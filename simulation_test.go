@@ -0,0 +1,269 @@
+package wave_test
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/parallel"
+	"github.com/outofforest/qa"
+	"github.com/outofforest/wave"
+	"github.com/outofforest/wave/test/wire1"
+	"github.com/outofforest/wave/wavetest"
+	"github.com/outofforest/wave/wire"
+)
+
+const simulationTimeout = 30 * time.Second
+
+func TestSimulation(t *testing.T) {
+	for seed := range uint64(8) {
+		t.Run(fmt.Sprintf("seed-%d", seed), func(t *testing.T) {
+			simulate(t, simulationConfig{
+				Seed:       seed,
+				Servers:    1 + int(seed%4),
+				Clients:    1 + int(seed%5),
+				Namespaces: 4,
+				Steps:      200,
+				MaxDelay:   20 * time.Millisecond,
+			})
+		})
+	}
+}
+
+type simulationConfig struct {
+	// Seed drives all the random decisions, so runs with the same config inject the same faults in the same order.
+	Seed       uint64
+	Servers    int
+	Clients    int
+	Namespaces int
+
+	// Steps is the number of random publications and faults.
+	Steps int
+
+	// MaxDelay is the maximum delay of the link and the time between steps.
+	MaxDelay time.Duration
+}
+
+// simulate runs real servers and clients publishing revisions, while connections between them are randomly cut,
+// partitioned and delayed. Schedule of the steps is reproducible, but goroutines interleave freely, so different
+// runs exercise different races. Client receiving revision not newer than the previous one fails the test.
+// Once the network is healed, every client must receive the latest revisions it requested and every server
+// must store the latest revisions of all the messages.
+func simulate(t *testing.T, config simulationConfig) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+	group := qa.NewGroup(ctx, t)
+	rnd := rand.New(rand.NewPCG(config.Seed, config.Seed)) //nolint:gosec // Schedule must be reproducible.
+
+	// Circuit is never opened, otherwise peers wouldn't reconnect before the end of the test.
+	reconnect := fastReconnect
+	reconnect.CircuitOpenAfter = math.MaxInt
+
+	cluster := wavetest.NewCluster(t, wavetest.Config{
+		Servers:        config.Servers,
+		MaxMessageSize: maxMsgSize,
+		Server: func(_ int, config *wave.ServerConfig) {
+			config.Reconnect = reconnect
+		},
+	})
+
+	m := wire1.NewMarshaller()
+	namespaces := make([]wire.Namespace, 0, config.Namespaces)
+	for i := range config.Namespaces {
+		namespaces = append(namespaces, wire.Namespace(fmt.Sprintf("simulation-%d", i)))
+	}
+	newClient := func(namespaces []wire.Namespace, servers ...int) *simulationClient {
+		clientConfig := wave.ClientConfig{
+			Envelopes: true,
+			Reconnect: reconnect,
+		}
+		requested := map[wire.Namespace]struct{}{}
+		for _, ns := range namespaces {
+			clientConfig.Requests = append(clientConfig.Requests, wave.RequestConfig{
+				Namespace:  ns,
+				Marshaller: m,
+				Messages:   []any{&wire1.Msg2{}},
+			})
+			requested[ns] = struct{}{}
+		}
+
+		c := &simulationClient{
+			Client:    cluster.NewClient(clientConfig, servers...),
+			requested: requested,
+			received:  map[simulationRevision]uint64{},
+		}
+		group.Spawn(c.Name, parallel.Fail, c.receive)
+		return c
+	}
+
+	// Network links are identified by names of nodes.
+	var links [][2]string
+	for i := range config.Servers {
+		for j := range i {
+			links = append(links, [2]string{cluster.Address(j), cluster.Address(i)})
+		}
+	}
+
+	clients := make([]*simulationClient, 0, config.Clients)
+	for range config.Clients {
+		var requested []wire.Namespace
+		for _, ns := range namespaces {
+			if rnd.IntN(2) == 0 {
+				requested = append(requested, ns)
+			}
+		}
+
+		// Each client is connected to at least one server.
+		first := rnd.IntN(config.Servers)
+		var servers []int
+		for i := range config.Servers {
+			if i == first || rnd.IntN(2) == 0 {
+				servers = append(servers, i)
+			}
+		}
+
+		client := newClient(requested, servers...)
+		for _, i := range servers {
+			links = append(links, [2]string{client.Name, cluster.Address(i)})
+		}
+		clients = append(clients, client)
+	}
+
+	network := cluster.Network()
+	published := map[simulationRevision]uint64{}
+	for range config.Steps {
+		link := links[rnd.IntN(len(links))]
+
+		switch r := rnd.IntN(10); {
+		case r < 6:
+			client := clients[rnd.IntN(len(clients))]
+			ns := namespaces[rnd.IntN(len(namespaces))]
+			rev := simulationRevision{Sender: client.ID(), Namespace: ns}
+			published[rev]++
+			requireT.NoError(client.Send(ctx, &wire1.Msg2{Value: published[rev]},
+				wave.NamespacedMarshaller{Marshaller: m, Namespace: ns}))
+		case r < 7:
+			network.Cut(link[0], link[1])
+		case r < 8:
+			network.Partition(link[0], link[1])
+		case r < 9:
+			network.Heal(link[0], link[1])
+		default:
+			network.SetDelay(link[0], link[1], time.Duration(rnd.Int64N(int64(config.MaxDelay))))
+		}
+
+		time.Sleep(time.Duration(rnd.Int64N(int64(config.MaxDelay))))
+	}
+
+	for _, link := range links {
+		network.Heal(link[0], link[1])
+		network.SetDelay(link[0], link[1], 0)
+	}
+
+	for _, client := range clients {
+		expected := map[simulationRevision]uint64{}
+		for rev, value := range published {
+			// Client doesn't need to receive its own revisions.
+			if _, exists := client.requested[rev.Namespace]; exists && rev.Sender != client.ID() {
+				expected[rev] = value
+			}
+		}
+		client.requireReceived(t, expected)
+	}
+
+	// Client connected to the single server receives everything it stores.
+	for i := range config.Servers {
+		newClient(namespaces, i).requireReceived(t, published)
+	}
+}
+
+// simulationRevision identifies the message published by the client.
+type simulationRevision struct {
+	Sender    wire.PeerID
+	Namespace wire.Namespace
+}
+
+type simulationClient struct {
+	*wavetest.Client
+
+	requested map[wire.Namespace]struct{}
+
+	mu       sync.Mutex
+	err      error
+	received map[simulationRevision]uint64
+}
+
+func (c *simulationClient) receive(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case msg, ok := <-c.Messages:
+			if !ok {
+				return errors.WithStack(ctx.Err())
+			}
+			envelope := msg.(*wave.Envelope)
+			if err := c.record(simulationRevision{
+				Sender:    envelope.Header.Sender,
+				Namespace: envelope.Header.Revision.Message.Namespace,
+			}, envelope.Message.(*wire1.Msg2).Value); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *simulationClient) record(rev simulationRevision, value uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.requested[rev.Namespace]; !exists {
+		c.err = errors.Errorf("client %s received message of namespace %s it hasn't requested", c.Name, rev.Namespace)
+		return c.err
+	}
+	if prevValue := c.received[rev]; value <= prevValue {
+		c.err = errors.Errorf("client %s received revision %d of namespace %s sent by %s after revision %d",
+			c.Name, value, rev.Namespace, rev.Sender, prevValue)
+		return c.err
+	}
+	c.received[rev] = value
+	return nil
+}
+
+// requireReceived waits until the latest received revisions are the expected ones.
+func (c *simulationClient) requireReceived(t *testing.T, expected map[simulationRevision]uint64) {
+	requireT := require.New(t)
+
+	deadline := time.Now().Add(simulationTimeout)
+	for {
+		received, err := c.state()
+		requireT.NoError(err)
+		if maps.Equal(expected, received) || time.Now().After(deadline) {
+			requireT.Equal(expected, received, "client %s hasn't received the latest revisions", c.Name)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// state returns the latest revisions received from other clients and the invariant violation if detected.
+func (c *simulationClient) state() (map[simulationRevision]uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	received := map[simulationRevision]uint64{}
+	for rev, value := range c.received {
+		if rev.Sender != c.ID() {
+			received[rev] = value
+		}
+	}
+	return received, c.err
+}
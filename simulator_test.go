@@ -0,0 +1,676 @@
+package wave
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"maps"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/qa"
+	"github.com/outofforest/wave/wire"
+)
+
+const (
+	simulatorMaxMessageSize                = 1024
+	simulatorNamespace      wire.Namespace = "simulator"
+)
+
+func TestSimulator(t *testing.T) {
+	for seed := range uint64(50) {
+		t.Run(fmt.Sprintf("seed-%d", seed), func(t *testing.T) {
+			_, err := simulate(qa.NewContext(t), simulatorConfig{
+				Seed:       seed,
+				Servers:    1 + int(seed%4),
+				Clients:    1 + int(seed%5),
+				Messages:   4,
+				Steps:      500,
+				MaxLatency: 50 * time.Millisecond,
+			})
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestSimulatorIsReproducible(t *testing.T) {
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	config := simulatorConfig{
+		Seed:       1,
+		Servers:    3,
+		Clients:    4,
+		Messages:   4,
+		Steps:      500,
+		MaxLatency: 50 * time.Millisecond,
+	}
+	result1, err := simulate(ctx, config)
+	requireT.NoError(err)
+	result2, err := simulate(ctx, config)
+	requireT.NoError(err)
+	requireT.Equal(result1, result2)
+
+	config.Seed = 2
+	result3, err := simulate(ctx, config)
+	requireT.NoError(err)
+	requireT.NotEqual(result1.Fingerprint, result3.Fingerprint)
+}
+
+type simulatorConfig struct {
+	// Seed drives all the random decisions, so runs with the same config are identical.
+	Seed uint64
+
+	Servers int
+	Clients int
+
+	// Messages is the number of message IDs published and requested by clients.
+	Messages int
+
+	// Steps is the number of random publications, subscription changes, disconnections and reconnections.
+	Steps int
+
+	// MaxLatency is the maximum time message travels between peers.
+	MaxLatency time.Duration
+}
+
+type simulatorResult struct {
+	// Events is the number of messages delivered between peers.
+	Events int
+
+	// Duration is the virtual time elapsed.
+	Duration time.Duration
+
+	// Fingerprint is the hash of the delivered messages. Runs with the same config produce the same fingerprint.
+	Fingerprint uint64
+}
+
+// simulate runs servers and clients exchanging revisions over virtual links which are randomly dropped
+// and restored, while clients publish revisions and change their requests. Connections run the sessions
+// used by the real ones on top of serverConns and clientConns, but the messages are passed in virtual time
+// in the order defined by the seed, instead of goroutines and sockets. Revision regressions are detected
+// during the run. Once all the links are restored and messages delivered, it checks that servers converged
+// and clients received the latest revisions they requested.
+func simulate(ctx context.Context, config simulatorConfig) (simulatorResult, error) {
+	s := newSimulator(ctx, config)
+	defer s.Close()
+
+	if err := s.Run(); err != nil {
+		return simulatorResult{}, errors.Wrapf(err, "simulation with seed %d failed", config.Seed)
+	}
+	return simulatorResult{
+		Events:      s.events,
+		Duration:    s.now,
+		Fingerprint: s.hash.Sum64(),
+	}, nil
+}
+
+type simServer struct {
+	ID    wire.PeerID
+	Conns *serverConns
+}
+
+type simClient struct {
+	ID     wire.PeerID
+	Conns  *clientConns
+	RecvCh chan any
+
+	// Requested are the message IDs currently requested by the client.
+	Requested map[wire.MessageID]struct{}
+
+	// Delivered are the revisions received through the channel since messages have been requested.
+	Delivered map[revDescriptor]wire.Revision
+}
+
+// simLink connects client or server dialing the server. End 0 is the dialing side.
+type simLink struct {
+	ID        int
+	Ends      [2]*simEnd
+	Connected bool
+
+	// Generation is increased on each connection, so messages sent over the previous one are dropped.
+	Generation uint64
+
+	// Messages travelling in one direction are delivered in order, like on TCP connection.
+	lastDelivery [2]time.Duration
+}
+
+type simEnd struct {
+	Server *simServer
+	Client *simClient
+
+	// Sessions are created once hello is received from the other side.
+	ServerSession *serverSession
+	ClientSession *clientSession
+
+	// RequestsVersion is the version of the requests sent by the client in hello.
+	RequestsVersion uint64
+}
+
+func (e *simEnd) Hello() *wire.Hello {
+	if e.Server != nil {
+		return &wire.Hello{
+			PeerID:   e.Server.ID,
+			IsServer: true,
+		}
+	}
+
+	var requests []wire.NamespaceRequest
+	requests, e.RequestsVersion = e.Client.Conns.Requests()
+	return &wire.Hello{
+		PeerID:   e.Client.ID,
+		Requests: requests,
+	}
+}
+
+func (e *simEnd) Close() {
+	if e.ServerSession != nil {
+		e.ServerSession.Close()
+	}
+	if e.ClientSession != nil {
+		e.ClientSession.Close()
+	}
+	e.ServerSession = nil
+	e.ClientSession = nil
+}
+
+type simEvent struct {
+	Time time.Duration
+	Seq  uint64
+
+	// Link is nil for the step events.
+	Link       *simLink
+	Generation uint64
+	To         int
+	Msg        any
+}
+
+type simQueue []*simEvent
+
+func (q simQueue) Len() int {
+	return len(q)
+}
+
+func (q simQueue) Less(i, j int) bool {
+	if q[i].Time != q[j].Time {
+		return q[i].Time < q[j].Time
+	}
+	return q[i].Seq < q[j].Seq
+}
+
+func (q simQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *simQueue) Push(x any) {
+	*q = append(*q, x.(*simEvent))
+}
+
+func (q *simQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+type simulator struct {
+	ctx    context.Context
+	config simulatorConfig
+	rand   *rand.Rand
+	hash   hash.Hash64
+
+	now    time.Duration
+	seq    uint64
+	queue  simQueue
+	events int
+	steps  int
+
+	servers   []*simServer
+	clients   []*simClient
+	links     []*simLink
+	published map[revDescriptor]wire.Revision
+}
+
+func newSimulator(ctx context.Context, config simulatorConfig) *simulator {
+	s := &simulator{
+		ctx:       ctx,
+		config:    config,
+		rand:      rand.New(rand.NewPCG(config.Seed, config.Seed)), //nolint:gosec // Simulation must be reproducible.
+		hash:      fnv.New64a(),
+		published: map[revDescriptor]wire.Revision{},
+	}
+
+	for range config.Servers {
+		s.servers = append(s.servers, &simServer{
+			ID:    s.peerID(),
+			Conns: newServerConns(newMetrics()),
+		})
+	}
+	for range config.Clients {
+		id := s.peerID()
+		// Channel is drained after each delivery, it is large enough to keep the revisions replayed on subscription.
+		recvCh := make(chan any, 1024)
+		client := &simClient{
+			ID:        id,
+			Conns:     newClientConns(id, recvCh, true, simulatorMaxMessageSize, newMetrics()),
+			RecvCh:    recvCh,
+			Requested: map[wire.MessageID]struct{}{},
+			Delivered: map[revDescriptor]wire.Revision{},
+		}
+		for i := range config.Messages {
+			if s.rand.IntN(2) == 0 {
+				s.subscribe(client, simulatorMessageID(i))
+			}
+		}
+		s.clients = append(s.clients, client)
+	}
+
+	for i, server := range s.servers {
+		for _, server2 := range s.servers[i+1:] {
+			s.addLink(&simEnd{Server: server}, &simEnd{Server: server2})
+		}
+	}
+	for _, client := range s.clients {
+		// Each client is connected to at least one server.
+		first := s.rand.IntN(len(s.servers))
+		for i, server := range s.servers {
+			if i == first || s.rand.IntN(2) == 0 {
+				s.addLink(&simEnd{Client: client}, &simEnd{Server: server})
+			}
+		}
+	}
+
+	return s
+}
+
+// simulatorMessageID returns ID of the message. Zero is not used, because it requests all the messages.
+func simulatorMessageID(i int) wire.MessageID {
+	return wire.MessageID(i + 1)
+}
+
+func (s *simulator) Run() error {
+	for _, link := range s.links {
+		s.connect(link)
+	}
+	s.schedule(&simEvent{Time: s.latency()})
+
+	for s.queue.Len() > 0 {
+		e := heap.Pop(&s.queue).(*simEvent)
+		s.now = e.Time
+
+		if e.Link == nil {
+			if err := s.step(); err != nil {
+				return err
+			}
+		} else if e.Link.Connected && e.Generation == e.Link.Generation {
+			s.events++
+			s.record(uint64(s.now), uint64(e.Link.ID), uint64(e.To))
+			if err := s.receive(e.Link, e.To, e.Msg); err != nil {
+				return err
+			}
+		}
+
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+
+	return s.verify()
+}
+
+// Close stops the clients.
+func (s *simulator) Close() {
+	for _, client := range s.clients {
+		client.Conns.Close()
+	}
+}
+
+// record adds values to the fingerprint of the run.
+func (s *simulator) record(values ...uint64) {
+	var b [8]byte
+	for _, v := range values {
+		binary.LittleEndian.PutUint64(b[:], v)
+		_, _ = s.hash.Write(b[:])
+	}
+}
+
+func (s *simulator) peerID() wire.PeerID {
+	var id wire.PeerID
+	for i := range id {
+		id[i] = byte(s.rand.UintN(256))
+	}
+	return id
+}
+
+func (s *simulator) addLink(dialer, server *simEnd) {
+	s.links = append(s.links, &simLink{
+		ID:   len(s.links),
+		Ends: [2]*simEnd{dialer, server},
+	})
+}
+
+func (s *simulator) latency() time.Duration {
+	return time.Duration(s.rand.Int64N(int64(s.config.MaxLatency))) + 1
+}
+
+func (s *simulator) schedule(e *simEvent) {
+	s.seq++
+	e.Seq = s.seq
+	heap.Push(&s.queue, e)
+}
+
+// send schedules delivery of the items to the other end of the link.
+func (s *simulator) send(link *simLink, from int, items ...any) {
+	for _, item := range items {
+		t := max(s.now+s.latency(), link.lastDelivery[from])
+		link.lastDelivery[from] = t
+		s.schedule(&simEvent{
+			Time:       t,
+			Link:       link,
+			Generation: link.Generation,
+			To:         1 - from,
+			Msg:        item,
+		})
+	}
+}
+
+// step publishes revision, changes requests, drops or restores link. Once all the steps are done, links
+// are restored.
+func (s *simulator) step() error {
+	s.steps++
+	if s.steps > s.config.Steps {
+		for _, link := range s.links {
+			if !link.Connected {
+				s.connect(link)
+			}
+		}
+		return nil
+	}
+	defer s.schedule(&simEvent{Time: s.now + s.latency()})
+
+	client := s.clients[s.rand.IntN(len(s.clients))]
+	msgID := simulatorMessageID(s.rand.IntN(s.config.Messages))
+	switch r := s.rand.IntN(10); {
+	case r < 5:
+		s.publish(client, msgID)
+	case r < 6:
+		if _, exists := client.Requested[msgID]; exists {
+			s.unsubscribe(client, msgID)
+		} else {
+			s.subscribe(client, msgID)
+
+			// Known revisions are replayed by the client.
+			return s.checkDelivered(client)
+		}
+	case r < 8:
+		if link := s.randomLink(true); link != nil {
+			s.disconnect(link)
+		}
+	default:
+		if link := s.randomLink(false); link != nil {
+			s.connect(link)
+		}
+	}
+	return nil
+}
+
+func (s *simulator) randomLink(connected bool) *simLink {
+	var links []*simLink
+	for _, link := range s.links {
+		if link.Connected == connected {
+			links = append(links, link)
+		}
+	}
+	if len(links) == 0 {
+		return nil
+	}
+	return links[s.rand.IntN(len(links))]
+}
+
+func (s *simulator) publish(client *simClient, msgID wire.MessageID) {
+	header, err := client.Conns.Broadcast(&RawMessage{
+		ID:      msgID,
+		Content: binary.LittleEndian.AppendUint64(nil, s.rand.Uint64()),
+	}, RawMarshaller{Namespace: simulatorNamespace}, wire.TraceContext{})
+	if err != nil {
+		panic(err)
+	}
+
+	s.published[revDescriptor{
+		MessageDescriptor: header.Revision.Message,
+		Sender:            header.Sender,
+	}] = header.Revision.Index
+	s.record(uint64(msgID), uint64(header.Revision.Index))
+}
+
+func (s *simulator) subscribe(client *simClient, msgID wire.MessageID) {
+	client.Requested[msgID] = struct{}{}
+	client.Conns.Subscribe([]wire.NamespaceRequest{{
+		Namespace:  simulatorNamespace,
+		MessageIDs: []wire.MessageID{msgID},
+	}}, RawMarshaller{Namespace: simulatorNamespace}, nil)
+}
+
+func (s *simulator) unsubscribe(client *simClient, msgID wire.MessageID) {
+	delete(client.Requested, msgID)
+	client.Conns.Unsubscribe([]wire.NamespaceRequest{{
+		Namespace:  simulatorNamespace,
+		MessageIDs: []wire.MessageID{msgID},
+	}})
+
+	// Revisions are delivered again once message is requested again.
+	for revDesc := range client.Delivered {
+		if revDesc.MessageID == msgID {
+			delete(client.Delivered, revDesc)
+		}
+	}
+}
+
+// connect starts the connection. Both sides send hello first.
+func (s *simulator) connect(link *simLink) {
+	s.record(uint64(link.ID))
+
+	link.Connected = true
+	link.Generation++
+	link.lastDelivery = [2]time.Duration{s.now, s.now}
+
+	for i, end := range link.Ends {
+		s.send(link, i, end.Hello())
+	}
+}
+
+func (s *simulator) disconnect(link *simLink) {
+	s.record(uint64(link.ID))
+
+	link.Connected = false
+	for _, end := range link.Ends {
+		end.Close()
+	}
+}
+
+// receive passes the message to the end of the link, the way connection does.
+func (s *simulator) receive(link *simLink, to int, msg any) error {
+	end := link.Ends[to]
+
+	if hello, ok := msg.(*wire.Hello); ok {
+		return s.handshake(link, to, hello)
+	}
+
+	if end.ClientSession != nil {
+		switch msg := msg.(type) {
+		case revision:
+			s.record(uint64(msg.Header.Revision.Index))
+			if end.Client.Conns.Deliver(msg.Header, msg.Content) {
+				return s.checkDelivered(end.Client)
+			}
+			return nil
+		default:
+			item, err := end.ClientSession.Receive(msg)
+			if err != nil {
+				return err
+			}
+			s.send(link, to, end.ClientSession.Respond(item)...)
+			return nil
+		}
+	}
+
+	switch msg := msg.(type) {
+	case revision:
+		s.record(uint64(msg.Header.Revision.Index))
+		end.Server.Conns.Broadcast(msg)
+	case msgToSend:
+		if msg.Subscription != nil {
+			replayed, err := end.ServerSession.Subscribe(msg.Subscription)
+			if err != nil {
+				return err
+			}
+			s.send(link, to, end.ServerSession.Replay(replayed)...)
+			return nil
+		}
+		s.record(uint64(msg.Header.Revision.Index))
+		end.Server.Conns.Broadcast(revision{
+			Header:  msg.Header,
+			Content: msg.Content,
+		})
+	default:
+		item, err := end.ServerSession.Receive(msg)
+		if err != nil {
+			return err
+		}
+		s.send(link, to, end.ServerSession.Respond(item)...)
+	}
+	return nil
+}
+
+// handshake creates the session once hello is received from the other side, then sends the digest.
+func (s *simulator) handshake(link *simLink, to int, hello *wire.Hello) error {
+	end := link.Ends[to]
+
+	if end.Client != nil {
+		end.ClientSession = newClientSession(end.Client.Conns, end.RequestsVersion)
+		s.send(link, to, end.ClientSession.Digest())
+		return nil
+	}
+
+	peer := Peer{
+		ID:   hello.PeerID,
+		Role: RoleClient,
+	}
+	if hello.IsServer {
+		peer.Role = RoleServer
+	}
+
+	// Connection dialed by the server with lower ID is preferred, so both sides make the same decision.
+	dialed := to == 0
+	preferred := dialed == (bytes.Compare(end.Server.ID[:], hello.PeerID[:]) < 0)
+	session, err := newServerSession(end.Server.Conns, peer, hello, preferred)
+	if err != nil {
+		return err
+	}
+	end.ServerSession = session
+	s.send(link, to, session.Digest())
+	return nil
+}
+
+// flush sends revisions broadcast by the servers and messages queued by the clients, the way senders
+// of the connections do.
+func (s *simulator) flush() error {
+	for _, link := range s.links {
+		if !link.Connected {
+			continue
+		}
+		for i, end := range link.Ends {
+			if end.ServerSession != nil {
+				for sent := false; !sent; {
+					select {
+					case msgRev, ok := <-end.ServerSession.SendCh():
+						if !ok {
+							return errors.New("connection replaced")
+						}
+						s.send(link, i, end.ServerSession.Send(msgRev)...)
+					default:
+						sent = true
+					}
+				}
+			}
+			if end.ClientSession != nil {
+				items, ok := end.ClientSession.Pop()
+				if !ok {
+					return errors.New("client session closed")
+				}
+				s.send(link, i, items...)
+			}
+		}
+	}
+	return nil
+}
+
+// checkDelivered verifies messages passed to the receiver of the client.
+func (s *simulator) checkDelivered(client *simClient) error {
+	if err := client.Conns.Flush(s.ctx); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case msg := <-client.RecvCh:
+			envelope := msg.(*Envelope)
+			header := envelope.Header
+			revDesc := revDescriptor{
+				MessageDescriptor: header.Revision.Message,
+				Sender:            header.Sender,
+			}
+			if _, exists := client.Requested[revDesc.MessageID]; !exists {
+				return errors.Errorf("client %s received message %d it hasn't requested",
+					client.ID, revDesc.MessageID)
+			}
+			if index, exists := client.Delivered[revDesc]; exists && index >= header.Revision.Index {
+				return errors.Errorf("client %s received revision %d of message %d sent by %s after revision %d",
+					client.ID, header.Revision.Index, revDesc.MessageID, revDesc.Sender, index)
+			}
+			client.Delivered[revDesc] = header.Revision.Index
+		default:
+			return nil
+		}
+	}
+}
+
+// verify checks that servers store the latest revisions of all the messages and clients received the latest
+// revisions they requested.
+func (s *simulator) verify() error {
+	for _, server := range s.servers {
+		if stored := server.Conns.store.Indexes(); !maps.Equal(stored, s.published) {
+			return errors.Errorf("server %s stores %v, expected %v", server.ID, stored, s.published)
+		}
+	}
+
+	for _, client := range s.clients {
+		expected := map[revDescriptor]wire.Revision{}
+		for revDesc, index := range s.published {
+			// Client doesn't need to receive its own revisions.
+			if _, exists := client.Requested[revDesc.MessageID]; exists && revDesc.Sender != client.ID {
+				expected[revDesc] = index
+			}
+		}
+
+		delivered := map[revDescriptor]wire.Revision{}
+		for revDesc, index := range client.Delivered {
+			if revDesc.Sender != client.ID {
+				delivered[revDesc] = index
+			}
+		}
+		if !maps.Equal(delivered, expected) {
+			return errors.Errorf("client %s received %v, expected %v", client.ID, delivered, expected)
+		}
+	}
+
+	return nil
+}
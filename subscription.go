@@ -0,0 +1,162 @@
+package wave
+
+import (
+	"slices"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/wave/wire"
+)
+
+const subscriptionBufferSize = 10
+
+// SubscriptionConfig defines messages received by the subscription.
+type SubscriptionConfig struct {
+	Requests []RequestConfig
+
+	// BufferSize is the number of messages buffered in the channel. If zero, 10 is used. Revisions waiting
+	// for the full channel are replaced by the newer ones of the same message.
+	BufferSize int
+}
+
+// Subscription receives requested messages independently of the client and its other subscriptions.
+// Subscriber not keeping up receives only the latest revisions of the messages, it never blocks the others.
+type Subscription struct {
+	conns      *clientConns
+	subscriber *subscriber
+}
+
+// Messages returns channel delivering messages. It is closed when subscription is closed or client stops.
+func (s *Subscription) Messages() <-chan any {
+	return s.subscriber.Ch
+}
+
+// Close stops delivering messages to the subscription. Messages requested only by it are no longer sent by servers.
+func (s *Subscription) Close() {
+	s.conns.RemoveSubscriber(s.subscriber)
+}
+
+// NewSubscription creates subscription sharing connections of the client. Current values of the requested
+// messages known to the client are delivered first. Marshallers registered before for the same namespaces
+// are used to decode the messages.
+func (client *Client) NewSubscription(config SubscriptionConfig) (*Subscription, error) {
+	if len(config.Requests) == 0 {
+		return nil, errors.New("no requests specified")
+	}
+	if config.BufferSize == 0 {
+		config.BufferSize = subscriptionBufferSize
+	}
+
+	s, err := client.conns.AddSubscriber(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Subscription{
+		conns:      client.conns,
+		subscriber: s,
+	}, nil
+}
+
+type subscriber struct {
+	Requests []wire.NamespaceRequest
+	Reqs     map[wire.MessageDescriptor]struct{}
+	Ch       chan any
+
+	queue *deliveryQueue
+}
+
+func newSubscriber(requests []wire.NamespaceRequest, bufferSize int) *subscriber {
+	reqs, _ := requestedDescriptors(requests)
+	s := &subscriber{
+		Requests: requests,
+		Reqs:     reqs,
+		Ch:       make(chan any, bufferSize),
+		queue:    newDeliveryQueue(),
+	}
+	go s.queue.Run(s.Ch)
+	return s
+}
+
+// Deliver queues the message for the subscriber. It never waits for the subscriber.
+func (s *subscriber) Deliver(revDesc revDescriptor, msg any) {
+	s.queue.Push(revDesc, msg)
+}
+
+// Close stops delivering messages. Channel is closed once the pending message is delivered or dropped.
+func (s *subscriber) Close() {
+	s.queue.Close()
+}
+
+// AddSubscriber registers subscriber and sends its requests to the connected servers. Known revisions
+// requested by it are replayed first.
+func (c *clientConns) AddSubscriber(config SubscriptionConfig) (*subscriber, error) {
+	type marshallerRequests struct {
+		Requests     []wire.NamespaceRequest
+		Config       RequestConfig
+		Fingerprints map[wire.MessageID]uint64
+	}
+
+	registrations := make([]marshallerRequests, 0, len(config.Requests))
+	var requests []wire.NamespaceRequest
+	for _, r := range config.Requests {
+		nsRequests, fps, err := namespaceRequests(r)
+		if err != nil {
+			return nil, err
+		}
+		registrations = append(registrations, marshallerRequests{
+			Requests:     nsRequests,
+			Config:       r,
+			Fingerprints: fps,
+		})
+		requests = append(requests, nsRequests...)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopped {
+		return nil, errors.WithStack(ErrClientStopped)
+	}
+
+	for _, r := range registrations {
+		c.registerMarshaller(r.Requests, r.Config.Marshaller, r.Fingerprints)
+	}
+
+	s := newSubscriber(requests, config.BufferSize)
+
+	// Revisions applied later are delivered to the subscriber, the earlier ones are replayed.
+	var replayed []revDescriptor
+	for revDesc := range c.latest {
+		if requested(s.Reqs, revDesc.MessageDescriptor) {
+			replayed = append(replayed, revDesc)
+		}
+	}
+	sortRevDescriptors(replayed)
+	for _, revDesc := range replayed {
+		s.Deliver(revDesc, c.message(c.latest[revDesc]))
+	}
+
+	c.subscribers = append(slices.Clip(c.subscribers), s)
+	c.sendRequests()
+
+	return s, nil
+}
+
+// RemoveSubscriber unregisters subscriber and sends the remaining requests to the connected servers.
+func (c *clientConns) RemoveSubscriber(s *subscriber) {
+	c.removeSubscriber(s)
+	s.Close()
+}
+
+func (c *clientConns) removeSubscriber(s *subscriber) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := slices.Index(c.subscribers, s)
+	if i < 0 {
+		return
+	}
+	c.subscribers = slices.Delete(slices.Clone(c.subscribers), i, i+1)
+	c.sendRequests()
+	c.pruneLatest()
+}
@@ -0,0 +1,47 @@
+package wave
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/wave/wire"
+)
+
+func TestLatestMessagesArePrunedWhenNoLongerRequested(t *testing.T) {
+	requireT := require.New(t)
+
+	c := newClientConns(wire.PeerID{0x01}, make(chan any, 10), false, fuzzMaxMessageSize, newMetrics())
+	c.Subscribe([]wire.NamespaceRequest{{Namespace: "client"}}, RawMarshaller{Namespace: "client"}, nil)
+	s, err := c.AddSubscriber(SubscriptionConfig{
+		Requests: []RequestConfig{
+			{
+				Namespace:  "subscriber",
+				Marshaller: RawMarshaller{Namespace: "subscriber"},
+			},
+		},
+		BufferSize: 10,
+	})
+	requireT.NoError(err)
+
+	for _, namespace := range []wire.Namespace{"client", "subscriber"} {
+		requireT.True(c.Deliver(&wire.Header{
+			Sender: wire.PeerID{0x02},
+			Revision: wire.RevisionDescriptor{
+				Message: wire.MessageDescriptor{
+					Namespace: namespace,
+					MessageID: 1,
+				},
+			},
+		}, &RawMessage{ID: 1}))
+	}
+	requireT.Len(c.latest, 2)
+
+	c.Unsubscribe([]wire.NamespaceRequest{{Namespace: "client"}})
+	requireT.Len(c.latest, 1)
+	requireT.Len(c.receivedMsgs.Indexes(), 1)
+
+	c.RemoveSubscriber(s)
+	requireT.Empty(c.latest)
+	requireT.Empty(c.receivedMsgs.Indexes())
+}
@@ -0,0 +1,128 @@
+package wave
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/wave/wire"
+)
+
+// Names of the recorded spans.
+const (
+	SpanSend          = "wave.send"
+	SpanServerReceive = "wave.server.receive"
+	SpanClientReceive = "wave.client.receive"
+)
+
+// traceSampled is the W3C flag marking trace as sampled.
+const traceSampled = 0x01
+
+// Span describes processing of the traced revision by the peer.
+type Span struct {
+	Name string
+
+	// Trace is the context of this span, its SpanID identifies the span.
+	Trace wire.TraceContext
+
+	// ParentID is the ID of the span which caused this one. It is zero for the span starting the trace.
+	ParentID wire.SpanID
+
+	// PeerID is the ID of the peer recording the span.
+	PeerID wire.PeerID
+
+	Sender   wire.PeerID
+	Revision wire.RevisionDescriptor
+	Start    time.Time
+	End      time.Time
+}
+
+// SpanRecorder records spans. It is called concurrently from many connections.
+type SpanRecorder interface {
+	RecordSpan(span Span)
+}
+
+// Envelope is delivered by client configured to expose headers of received messages.
+type Envelope struct {
+	Header  wire.Header
+	Message any
+}
+
+// ParseTraceParent parses trace context from W3C traceparent format.
+func ParseTraceParent(traceParent string) (wire.TraceContext, error) {
+	parts := strings.Split(traceParent, "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return wire.TraceContext{}, errors.Errorf("invalid traceparent %q", traceParent)
+	}
+
+	var tc wire.TraceContext
+	var flags [1]byte
+	for _, f := range []struct {
+		Dst []byte
+		Src string
+	}{
+		{Dst: tc.TraceID[:], Src: parts[1]},
+		{Dst: tc.SpanID[:], Src: parts[2]},
+		{Dst: flags[:], Src: parts[3]},
+	} {
+		if hex.DecodedLen(len(f.Src)) != len(f.Dst) {
+			return wire.TraceContext{}, errors.Errorf("invalid traceparent %q", traceParent)
+		}
+		if _, err := hex.Decode(f.Dst, []byte(f.Src)); err != nil {
+			return wire.TraceContext{}, errors.WithStack(err)
+		}
+	}
+	tc.Flags = flags[0]
+
+	if tc.IsZero() {
+		return wire.TraceContext{}, errors.Errorf("invalid traceparent %q", traceParent)
+	}
+	return tc, nil
+}
+
+// childTrace returns context of the new span. If parent is zero, new trace is started.
+func childTrace(parent wire.TraceContext) (wire.TraceContext, error) {
+	tc := parent
+	if tc.IsZero() {
+		if _, err := rand.Read(tc.TraceID[:]); err != nil {
+			return wire.TraceContext{}, errors.WithStack(err)
+		}
+		tc.Flags = traceSampled
+	}
+	if _, err := rand.Read(tc.SpanID[:]); err != nil {
+		return wire.TraceContext{}, errors.WithStack(err)
+	}
+	return tc, nil
+}
+
+// recordSpan records span of the peer processing the traced revision received from the network.
+func recordSpan(
+	recorder SpanRecorder,
+	name string,
+	peerID wire.PeerID,
+	header *wire.Header,
+	start time.Time,
+) {
+	if recorder == nil || header.Trace.IsZero() {
+		return
+	}
+
+	tc, err := childTrace(header.Trace)
+	if err != nil {
+		return
+	}
+
+	recorder.RecordSpan(Span{
+		Name:     name,
+		Trace:    tc,
+		ParentID: header.Trace.SpanID,
+		PeerID:   peerID,
+		Sender:   header.Sender,
+		Revision: header.Revision,
+		Start:    start,
+		End:      time.Now(),
+	})
+}
@@ -0,0 +1,371 @@
+package wave
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/outofforest/logger"
+	"github.com/outofforest/parallel"
+	"github.com/outofforest/resonance"
+	"github.com/outofforest/varuint64"
+)
+
+const (
+	dialTimeout         = 5 * time.Second
+	httpShutdownTimeout = 5 * time.Second
+)
+
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// Dialer connects to the address. It is replaced in tests to inject network faults.
+type Dialer func(ctx context.Context, address string) (net.Conn, error)
+
+type transportConfig struct {
+	Connection resonance.Config
+	Metrics    *metrics
+	Dialer     Dialer
+}
+
+// connection counts frames and bytes exchanged with the peer.
+type connection struct {
+	*resonance.Connection
+
+	metrics *metrics
+}
+
+func (c *connection) SendProton(msg any, m resonance.ProtonMarshaller) (uint64, error) {
+	n, err := c.Connection.SendProton(msg, m)
+	if err == nil {
+		c.metrics.FrameSent(n)
+	}
+	return n, err
+}
+
+func (c *connection) ReceiveProton(m resonance.ProtonUnmarshaller) (any, uint64, error) {
+	msg, n, err := c.Connection.ReceiveProton(m)
+	if err == nil {
+		c.metrics.FrameReceived(n)
+	}
+	return msg, n, err
+}
+
+func (c *connection) SendRawBytes(msg []byte) (uint64, error) {
+	n, err := c.Connection.SendRawBytes(msg)
+	if err == nil {
+		c.metrics.FrameSent(n)
+	}
+	return n, err
+}
+
+func (c *connection) ReceiveRawBytes() ([]byte, uint64, error) {
+	msg, n, err := c.Connection.ReceiveRawBytes()
+	if err == nil {
+		c.metrics.FrameReceived(n)
+	}
+	return msg, n, err
+}
+
+// frameConn validates frames received from the peer before resonance decodes them, so malformed frame
+// disconnects the peer like any other protocol violation. Resonance reads message ID beyond the frame
+// if its varint is not terminated.
+type frameConn struct {
+	net.Conn
+
+	maxMessageSize uint64
+
+	// varint collects bytes of the frame length or message ID split between reads.
+	varint     [varuint64.MaxSize]byte
+	varintSize int
+
+	// remaining is the number of bytes of the current frame not received yet, zero while length is read.
+	remaining uint64
+
+	// idRead is set once message ID is read, while the rest of the frame is received.
+	idRead bool
+}
+
+func (c *frameConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if vErr := c.validate(b[:n]); vErr != nil {
+		return 0, vErr
+	}
+	return n, err
+}
+
+func (c *frameConn) validate(b []byte) error {
+	for len(b) > 0 {
+		if c.idRead {
+			n := min(c.remaining, uint64(len(b)))
+			c.remaining -= n
+			c.idRead = c.remaining > 0
+			b = b[n:]
+			continue
+		}
+
+		c.varint[c.varintSize] = b[0]
+		c.varintSize++
+		b = b[1:]
+
+		if c.remaining == 0 {
+			if !varuint64.Contains(c.varint[:c.varintSize]) {
+				continue
+			}
+
+			// Zero length is sent as ping.
+			size, _ := varuint64.Parse(c.varint[:c.varintSize])
+			c.varintSize = 0
+			if size > c.maxMessageSize+varuint64.MaxSize {
+				return errors.Errorf("frame size %d exceeds maximum %d", size, c.maxMessageSize+varuint64.MaxSize)
+			}
+			c.remaining = size
+			continue
+		}
+
+		c.remaining--
+		if varuint64.Contains(c.varint[:c.varintSize]) {
+			c.varintSize = 0
+			c.idRead = c.remaining > 0
+			continue
+		}
+		if c.remaining == 0 {
+			return errors.New("message ID exceeds the frame")
+		}
+	}
+	return nil
+}
+
+// dial connects to the address once and runs handler on the connection.
+// Unlike resonance.RunClient, it doesn't retry, so reconnection is driven by the policy.
+func dial(
+	ctx context.Context,
+	address string,
+	config transportConfig,
+	handler func(ctx context.Context, c *connection) error,
+) error {
+	dialer := config.Dialer
+	if dialer == nil {
+		dialer = Dial
+	}
+	conn, err := dialer(ctx, address)
+	if err != nil {
+		return err
+	}
+
+	return runConnection(ctx, conn, config, handler)
+}
+
+// Dial connects to the address. Besides host:port, URL-style addresses are accepted: tcp://host:port,
+// unix:///path/to/socket, unix://@name for abstract sockets on Linux and ws://host:port/path for WebSocket.
+func Dial(ctx context.Context, address string) (net.Conn, error) {
+	if strings.HasPrefix(address, "ws://") {
+		return dialWebSocket(ctx, address)
+	}
+
+	network, addr, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return conn, nil
+}
+
+// Listen creates listener for the address. Besides host:port, URL-style addresses are accepted:
+// tcp://host:port, unix:///path/to/socket and unix://@name for abstract sockets on Linux.
+func Listen(address string) (net.Listener, error) {
+	network, addr, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	ls, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return ls, nil
+}
+
+func parseAddress(address string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		return "tcp", strings.TrimPrefix(address, "tcp://"), nil
+	case strings.HasPrefix(address, "unix://"):
+		return "unix", strings.TrimPrefix(address, "unix://"), nil
+	case strings.Contains(address, "://"):
+		return "", "", errors.Errorf("unsupported address %q", address)
+	default:
+		return "tcp", address, nil
+	}
+}
+
+// listenerAddress returns the address peers use to connect to the listener. Unspecified IP is replaced
+// by the IP of the network interface, otherwise remote peers would connect to themselves.
+func listenerAddress(ls net.Listener) (string, error) {
+	if ls.Addr().Network() == "unix" {
+		return "unix://" + ls.Addr().String(), nil
+	}
+
+	addr, ok := ls.Addr().(*net.TCPAddr)
+	if !ok || !addr.IP.IsUnspecified() {
+		return ls.Addr().String(), nil
+	}
+
+	ip, err := interfaceIP()
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(addr.Port)), nil
+}
+
+// interfaceIP returns the first routable IP assigned to the network interfaces, IPv4 is preferred.
+func interfaceIP() (net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var ipv6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP, nil
+		}
+		if ipv6 == nil {
+			ipv6 = ipNet.IP
+		}
+	}
+	if ipv6 == nil {
+		return nil, errors.New("no routable IP found on network interfaces, advertise address must be set")
+	}
+	return ipv6, nil
+}
+
+// serve accepts connections and runs handler on each of them. Unlike resonance.RunServer, it passes
+// the remote address to the handler.
+func serve(
+	ctx context.Context,
+	ls net.Listener,
+	config transportConfig,
+	handler func(ctx context.Context, c *connection, remoteAddress string) error,
+) error {
+	return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
+		spawn("listener", parallel.Fail, func(ctx context.Context) error {
+			for {
+				conn, err := ls.Accept()
+				if err != nil {
+					if ctx.Err() != nil {
+						// Deadline set by the watchdog is cleared, so listener might be used again.
+						if dl, ok := ls.(deadliner); ok {
+							_ = dl.SetDeadline(time.Time{})
+						}
+						return errors.WithStack(ctx.Err())
+					}
+					return errors.WithStack(err)
+				}
+				if ctx.Err() != nil {
+					_ = conn.Close()
+					return errors.WithStack(ctx.Err())
+				}
+
+				spawn("conn", parallel.Continue, func(ctx context.Context) error {
+					remoteAddress := conn.RemoteAddr().String()
+					err := runConnection(ctx, conn, config,
+						func(ctx context.Context, c *connection) error {
+							return handler(ctx, c, remoteAddress)
+						})
+					if err != nil && ctx.Err() == nil {
+						logger.Get(ctx).Warn("Wave connection failed",
+							zap.String("peer", remoteAddress), zap.Error(err))
+					}
+					return nil
+				})
+			}
+		})
+		spawn("watchdog", parallel.Fail, func(ctx context.Context) error {
+			<-ctx.Done()
+
+			// Listener is owned by the caller, so instead of closing it, pending accept is interrupted.
+			if dl, ok := ls.(deadliner); ok {
+				if err := dl.SetDeadline(time.Now()); err == nil {
+					return errors.WithStack(ctx.Err())
+				}
+			}
+			_ = ls.Close()
+			return errors.WithStack(ctx.Err())
+		})
+
+		return nil
+	})
+}
+
+func runConnection(
+	ctx context.Context,
+	conn net.Conn,
+	config transportConfig,
+	handler func(ctx context.Context, c *connection) error,
+) error {
+	c := &connection{
+		Connection: resonance.NewConnection(&frameConn{
+			Conn:           conn,
+			maxMessageSize: config.Connection.MaxMessageSize,
+		}, config.Connection),
+		metrics: config.Metrics,
+	}
+	return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
+		spawn("connection", parallel.Fail, c.Run)
+		spawn("handler", parallel.Exit, func(ctx context.Context) error {
+			return handler(ctx, c)
+		})
+		return nil
+	})
+}
+
+// serveHTTP serves HTTP handler on the address until context is canceled.
+func serveHTTP(ctx context.Context, address string, handler http.Handler) error {
+	ls, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
+		spawn("server", parallel.Fail, func(ctx context.Context) error {
+			if err := server.Serve(ls); !errors.Is(err, http.ErrServerClosed) {
+				return errors.WithStack(err)
+			}
+			return errors.WithStack(ctx.Err())
+		})
+		spawn("watchdog", parallel.Fail, func(ctx context.Context) error {
+			<-ctx.Done()
+
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+			defer cancel()
+
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				return errors.WithStack(err)
+			}
+			return errors.WithStack(ctx.Err())
+		})
+		return nil
+	})
+}
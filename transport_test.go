@@ -0,0 +1,63 @@
+package wave
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameConnAcceptsValidFrames(t *testing.T) {
+	requireT := require.New(t)
+
+	// Ping, frame with content and frame with two-byte message ID and no content.
+	frames := []byte{0x00, 0x04, 0x01, 'a', 'b', 'c', 0x02, 0x80, 0x01}
+
+	for split := range len(frames) + 1 {
+		c := &frameConn{maxMessageSize: 10}
+		requireT.NoError(c.validate(frames[:split]), "split %d", split)
+		requireT.NoError(c.validate(frames[split:]), "split %d", split)
+		requireT.Zero(c.remaining)
+		requireT.Zero(c.varintSize)
+		requireT.False(c.idRead)
+	}
+}
+
+func TestFrameConnRejectsUnterminatedMessageID(t *testing.T) {
+	c := &frameConn{maxMessageSize: 10}
+	require.ErrorContains(t, c.validate([]byte{0x02, 0xff, 0xfc}), "message ID exceeds the frame")
+}
+
+func TestFrameConnRejectsOversizedFrame(t *testing.T) {
+	c := &frameConn{maxMessageSize: 10}
+	require.ErrorContains(t, c.validate([]byte{0x80, 0x01}), "exceeds maximum")
+}
+
+func TestListenerAddressReplacesUnspecifiedIP(t *testing.T) {
+	requireT := require.New(t)
+
+	ls, err := Listen("127.0.0.1:0")
+	requireT.NoError(err)
+	defer ls.Close()
+
+	address, err := listenerAddress(ls)
+	requireT.NoError(err)
+	requireT.Equal(ls.Addr().String(), address)
+
+	ls2, err := Listen(":0")
+	requireT.NoError(err)
+	defer ls2.Close()
+
+	address, err = listenerAddress(ls2)
+	if _, ipErr := interfaceIP(); ipErr != nil {
+		requireT.ErrorContains(err, "advertise address must be set")
+		return
+	}
+	requireT.NoError(err)
+
+	host, port, err := net.SplitHostPort(address)
+	requireT.NoError(err)
+	requireT.False(net.ParseIP(host).IsUnspecified())
+	requireT.Equal(strconv.Itoa(ls2.Addr().(*net.TCPAddr).Port), port)
+}
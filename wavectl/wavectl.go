@@ -0,0 +1,436 @@
+// Package wavectl implements command-line tool connecting to wave servers as a client. Content of messages is
+// decoded for the namespaces whose marshallers are passed to Run, other ones are printed as hex.
+package wavectl
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+
+	"github.com/outofforest/logger"
+	"github.com/outofforest/parallel"
+	"github.com/outofforest/proton"
+	"github.com/outofforest/wave"
+	"github.com/outofforest/wave/wire"
+)
+
+const (
+	defaultMaxMessageSize = 1024 * 1024
+	defaultTimeout        = 10 * time.Second
+)
+
+const usage = `Usage: wavectl <command> [flags]
+
+Commands:
+  watch    prints revisions of the namespace as they arrive
+  dump     prints current revisions and exits
+  publish  publishes raw content of the message
+`
+
+type commonFlags struct {
+	Servers        []string
+	MaxMessageSize uint64
+	Hex            bool
+	Timeout        time.Duration
+}
+
+func newFlagSet(name string, common *commonFlags, out io.Writer) *pflag.FlagSet {
+	flags := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.StringSliceVar(&common.Servers, "servers", nil, "Addresses of servers to connect to")
+	flags.Uint64Var(&common.MaxMessageSize, "max-message-size", defaultMaxMessageSize, "Maximum size of the message")
+	flags.BoolVar(&common.Hex, "hex", false, "Print content as hex even if marshaller is compiled in")
+	flags.DurationVar(&common.Timeout, "timeout", defaultTimeout, "Time to wait for the servers")
+	logger.AddFlags(logger.DefaultConfig, flags)
+	return flags
+}
+
+// parseFlags parses args. If they are invalid, error and defaults of the flags are printed to the output of
+// the flag set, help is printed by the flag set itself.
+func parseFlags(flags *pflag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		if !errors.Is(err, pflag.ErrHelp) {
+			_, _ = fmt.Fprintln(flags.Output(), err)
+			flags.PrintDefaults()
+		}
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Run runs the command specified by args and prints the results to out. Usage and flag errors are printed
+// to out too.
+func Run(ctx context.Context, args []string, out io.Writer, marshallers ...proton.Marshaller) error {
+	if len(args) == 0 {
+		_, _ = fmt.Fprint(out, usage)
+		return errors.New("command not specified")
+	}
+
+	ms := map[wire.Namespace]proton.Marshaller{}
+	for _, m := range marshallers {
+		ms[wave.NamespaceOf(m)] = m
+	}
+
+	switch args[0] {
+	case "watch":
+		return runWatch(ctx, args[1:], out, ms)
+	case "dump":
+		return runDump(ctx, args[1:], out, ms)
+	case "publish":
+		return runPublish(ctx, args[1:], out)
+	default:
+		_, _ = fmt.Fprint(out, usage)
+		return errors.Errorf("unknown command %q", args[0])
+	}
+}
+
+func runWatch(ctx context.Context, args []string, out io.Writer, ms map[wire.Namespace]proton.Marshaller) error {
+	var common commonFlags
+	var namespace string
+	var messageIDs []uint
+
+	flags := newFlagSet("watch", &common, out)
+	flags.StringVar(&namespace, "namespace", "", "Namespace to watch")
+	flags.UintSliceVar(&messageIDs, "message", nil,
+		"IDs of messages to watch, all messages of compiled-in namespace are watched if not set")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if namespace == "" {
+		return errors.New("namespace not specified")
+	}
+
+	ids := make([]wire.MessageID, 0, len(messageIDs))
+	for _, id := range messageIDs {
+		ids = append(ids, wire.MessageID(id))
+	}
+	req, err := request(wire.Namespace(namespace), ids, common.Hex, ms)
+	if err != nil {
+		return err
+	}
+
+	return runClient(ctx, common, []wave.RequestConfig{req},
+		func(ctx context.Context, client *wave.Client, recvCh <-chan any) error {
+			for {
+				select {
+				case <-ctx.Done():
+					return errors.WithStack(ctx.Err())
+				case msg, ok := <-recvCh:
+					if !ok {
+						return errors.WithStack(ctx.Err())
+					}
+					if err := printEnvelope(out, msg.(*wave.Envelope)); err != nil {
+						return err
+					}
+				}
+			}
+		})
+}
+
+func runDump(ctx context.Context, args []string, out io.Writer, ms map[wire.Namespace]proton.Marshaller) error {
+	var common commonFlags
+	var adminAddress string
+
+	flags := newFlagSet("dump", &common, out)
+	flags.StringVar(&adminAddress, "admin", "",
+		"Address of the server admin API used to discover stored messages, if not set, "+
+			"messages of all namespaces are dumped")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	descriptors := map[wire.Namespace][]wire.MessageID{}
+	if adminAddress != "" {
+		var err error
+		descriptors, err = storedDescriptors(ctx, adminAddress, common.Timeout)
+		if err != nil {
+			return err
+		}
+	} else {
+		for ns := range ms {
+			descriptors[ns] = nil
+		}
+	}
+
+	namespaces := make([]wire.Namespace, 0, len(descriptors))
+	for ns := range descriptors {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i] < namespaces[j]
+	})
+
+	reqs := make([]wave.RequestConfig, 0, len(descriptors)+1)
+	for _, ns := range namespaces {
+		req, err := request(ns, descriptors[ns], common.Hex, ms)
+		if err != nil {
+			return err
+		}
+		reqs = append(reqs, req)
+	}
+	if adminAddress == "" {
+		// Messages of namespaces which are not compiled in are printed as hex.
+		reqs = append(reqs, wave.RequestConfig{Marshaller: wave.RawMarshaller{Namespace: wire.AnyNamespace}})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, common.Timeout)
+	defer cancel()
+
+	return runClient(ctx, common, reqs,
+		func(ctx context.Context, client *wave.Client, recvCh <-chan any) error {
+			for {
+				select {
+				case <-ctx.Done():
+					return errors.Wrap(ctx.Err(), "waiting for synchronization")
+				case msg, ok := <-recvCh:
+					if !ok {
+						return errors.Wrap(ctx.Err(), "waiting for synchronization")
+					}
+					if err := printEnvelope(out, msg.(*wave.Envelope)); err != nil {
+						return err
+					}
+				case e, ok := <-client.Events():
+					if !ok {
+						return errors.Wrap(ctx.Err(), "waiting for synchronization")
+					}
+					if e.Type != wave.EventSyncCompleted {
+						continue
+					}
+
+					// All the revisions known to the server have been delivered by now.
+					for len(recvCh) > 0 {
+						if err := printEnvelope(out, (<-recvCh).(*wave.Envelope)); err != nil {
+							return err
+						}
+					}
+					return nil
+				}
+			}
+		})
+}
+
+func runPublish(ctx context.Context, args []string, out io.Writer) error {
+	var common commonFlags
+	var namespace, content string
+	var messageID uint64
+
+	flags := newFlagSet("publish", &common, out)
+	flags.StringVar(&namespace, "namespace", "", "Namespace of the message")
+	flags.Uint64Var(&messageID, "message", 0, "ID of the message")
+	flags.StringVar(&content, "content", "", "Hex-encoded content of the message, read from stdin if not set")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if namespace == "" {
+		return errors.New("namespace not specified")
+	}
+	if !flags.Changed("message") {
+		return errors.New("message ID not specified")
+	}
+
+	var msgContent []byte
+	var err error
+	if content != "" {
+		msgContent, err = hex.DecodeString(content)
+	} else {
+		msgContent, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	m := wave.RawMarshaller{Namespace: wire.Namespace(namespace)}
+	msg := &wave.RawMessage{
+		ID:      wire.MessageID(messageID),
+		Content: msgContent,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, common.Timeout)
+	defer cancel()
+
+	// Message is requested too, so it is known it has been stored once server sends it back.
+	return runClient(ctx, common, []wave.RequestConfig{{Marshaller: m, Messages: []any{msg}}},
+		func(ctx context.Context, client *wave.Client, recvCh <-chan any) error {
+			if err := client.Send(ctx, msg, m); err != nil {
+				return err
+			}
+
+			for {
+				select {
+				case <-ctx.Done():
+					return errors.Wrap(ctx.Err(), "waiting for the message to be stored")
+				case msg, ok := <-recvCh:
+					if !ok {
+						return errors.Wrap(ctx.Err(), "waiting for the message to be stored")
+					}
+					envelope := msg.(*wave.Envelope)
+					if envelope.Header.Sender == client.ID() {
+						return printEnvelope(out, envelope)
+					}
+				}
+			}
+		})
+}
+
+func runClient(
+	ctx context.Context,
+	common commonFlags,
+	reqs []wave.RequestConfig,
+	fn func(ctx context.Context, client *wave.Client, recvCh <-chan any) error,
+) error {
+	if len(common.Servers) == 0 {
+		return errors.New("servers not specified")
+	}
+
+	client, recvCh, err := wave.NewClient(wave.ClientConfig{
+		Servers:        common.Servers,
+		MaxMessageSize: common.MaxMessageSize,
+		Requests:       reqs,
+		Connections:    1,
+		Envelopes:      true,
+	})
+	if err != nil {
+		return err
+	}
+
+	return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
+		spawn("client", parallel.Fail, client.Run)
+		spawn("command", parallel.Exit, func(ctx context.Context) error {
+			return fn(ctx, client, recvCh)
+		})
+		return nil
+	})
+}
+
+// request returns request for messages of the namespace. If message IDs are not specified, all the messages
+// of compiled-in namespace are requested.
+func request(
+	namespace wire.Namespace,
+	ids []wire.MessageID,
+	useHex bool,
+	ms map[wire.Namespace]proton.Marshaller,
+) (wave.RequestConfig, error) {
+	m, compiled := ms[namespace]
+	if !compiled && len(ids) == 0 {
+		return wave.RequestConfig{}, errors.Errorf(
+			"message IDs must be specified for namespace %q because its marshaller is not compiled in", namespace)
+	}
+
+	msgs := map[wire.MessageID]any{}
+	if compiled {
+		for _, msgType := range m.Messages() {
+			msg := reflect.New(reflect.TypeOf(msgType)).Interface()
+			id, err := m.ID(msg)
+			if err != nil {
+				return wave.RequestConfig{}, err
+			}
+			msgs[wire.MessageID(id)] = msg
+		}
+	}
+
+	if len(ids) == 0 {
+		for id := range msgs {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			return ids[i] < ids[j]
+		})
+	}
+
+	if !compiled || useHex {
+		req := wave.RequestConfig{
+			Marshaller: wave.RawMarshaller{Namespace: namespace},
+			Messages:   make([]any, 0, len(ids)),
+		}
+		for _, id := range ids {
+			req.Messages = append(req.Messages, &wave.RawMessage{ID: id})
+		}
+		return req, nil
+	}
+
+	req := wave.RequestConfig{
+		Marshaller: m,
+		Messages:   make([]any, 0, len(ids)),
+	}
+	for _, id := range ids {
+		msg, exists := msgs[id]
+		if !exists {
+			return wave.RequestConfig{}, errors.Errorf("unknown message ID %d in namespace %q", id, namespace)
+		}
+		req.Messages = append(req.Messages, msg)
+	}
+	return req, nil
+}
+
+// storedDescriptors returns descriptors of messages stored by the server.
+func storedDescriptors(
+	ctx context.Context,
+	adminAddress string,
+	timeout time.Duration,
+) (map[wire.Namespace][]wire.MessageID, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+adminAddress+"/revisions", nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var revs map[wire.Namespace][]wave.StoredRevision
+	if err := json.NewDecoder(resp.Body).Decode(&revs); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	descriptors := map[wire.Namespace][]wire.MessageID{}
+	for ns, nsRevs := range revs {
+		seen := map[wire.MessageID]struct{}{}
+		for _, rev := range nsRevs {
+			if _, exists := seen[rev.MessageID]; !exists {
+				seen[rev.MessageID] = struct{}{}
+				descriptors[ns] = append(descriptors[ns], rev.MessageID)
+			}
+		}
+	}
+	return descriptors, nil
+}
+
+// printEnvelope prints header of the revision followed by its content.
+func printEnvelope(out io.Writer, envelope *wave.Envelope) error {
+	header := envelope.Header
+	prefix := fmt.Sprintf("namespace=%s message=%d sender=%s revision=%d",
+		header.Revision.Message.Namespace, header.Revision.Message.MessageID, header.Sender, header.Revision.Index)
+	if !header.Trace.IsZero() {
+		prefix += " trace=" + header.Trace.String()
+	}
+
+	if rawMsg, ok := envelope.Message.(*wave.RawMessage); ok {
+		_, err := fmt.Fprintf(out, "%s hex=%s\n", prefix, hex.EncodeToString(rawMsg.Content))
+		return errors.WithStack(err)
+	}
+
+	content, err := json.Marshal(envelope.Message)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = fmt.Fprintf(out, "%s type=%T %s\n", prefix, envelope.Message, content)
+	return errors.WithStack(err)
+}
@@ -0,0 +1,321 @@
+package wavetest
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/wave"
+)
+
+const chunkQueueSize = 1024
+
+// Network injects faults into connections between nodes. Nodes are identified by names, server is named
+// by its address, so faults apply to the connection between two servers no matter which one has dialed.
+// Faults are applied to the data in both directions.
+type Network struct {
+	mu        sync.Mutex
+	links     map[link]*linkState
+	changedCh chan struct{}
+}
+
+// NewNetwork creates network without faults.
+func NewNetwork() *Network {
+	return &Network{
+		links:     map[link]*linkState{},
+		changedCh: make(chan struct{}),
+	}
+}
+
+type link struct {
+	A, B string
+}
+
+func newLink(a, b string) link {
+	nodes := []string{a, b}
+	sort.Strings(nodes)
+	return link{A: nodes[0], B: nodes[1]}
+}
+
+type linkState struct {
+	Partitioned bool
+	Delay       time.Duration
+
+	// Bandwidth is the number of bytes per second sent in each direction, zero means no limit.
+	Bandwidth uint64
+
+	// Sent is the number of bytes delivered from the node.
+	Sent map[string]uint64
+
+	Conns map[*faultConn]struct{}
+}
+
+// Dialer returns dialer used by the node.
+func (n *Network) Dialer(node string) wave.Dialer {
+	return func(ctx context.Context, address string) (net.Conn, error) {
+		l := newLink(node, address)
+		if n.state(l).Partitioned {
+			return nil, errors.Errorf("network between %s and %s is partitioned", node, address)
+		}
+
+		conn, err := wave.Dial(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+
+		local, remote := net.Pipe()
+		c := &faultConn{
+			Conn:     local,
+			remote:   remote,
+			peer:     conn,
+			network:  n,
+			link:     l,
+			closedCh: make(chan struct{}),
+		}
+
+		n.mu.Lock()
+		n.link(l).Conns[c] = struct{}{}
+		n.mu.Unlock()
+
+		go c.pump(remote, conn, node)
+		go c.pump(conn, remote, address)
+
+		return c, nil
+	}
+}
+
+// Partition stalls the data sent between nodes and refuses new connections until Heal is called. Stalled
+// connections behave like half-open ones, so peers close them if partition lasts long enough to miss pings.
+func (n *Network) Partition(a, b string) {
+	n.update(a, b, func(state *linkState) {
+		state.Partitioned = true
+	})
+}
+
+// Heal ends the partition between nodes.
+func (n *Network) Heal(a, b string) {
+	n.update(a, b, func(state *linkState) {
+		state.Partitioned = false
+	})
+}
+
+// SetDelay sets the delay of the data sent between nodes.
+func (n *Network) SetDelay(a, b string, delay time.Duration) {
+	n.update(a, b, func(state *linkState) {
+		state.Delay = delay
+	})
+}
+
+// SetBandwidth limits the number of bytes per second sent between nodes in each direction. Zero means no limit.
+func (n *Network) SetBandwidth(a, b string, bytesPerSecond uint64) {
+	n.update(a, b, func(state *linkState) {
+		state.Bandwidth = bytesPerSecond
+	})
+}
+
+// Cut closes the connections between nodes.
+func (n *Network) Cut(a, b string) {
+	for _, c := range n.conns(a, b) {
+		c.Close()
+	}
+}
+
+// CutMidFrame closes the connections between nodes after sending the half of the next chunk of data.
+func (n *Network) CutMidFrame(a, b string) {
+	for _, c := range n.conns(a, b) {
+		c.cutMidFrame.Store(true)
+	}
+}
+
+// BytesSent returns the number of bytes delivered from one node to the other.
+func (n *Network) BytesSent(from, to string) uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.link(newLink(from, to)).Sent[from]
+}
+
+func (n *Network) conns(a, b string) []*faultConn {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	conns := []*faultConn{}
+	for c := range n.link(newLink(a, b)).Conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+func (n *Network) update(a, b string, fn func(state *linkState)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	fn(n.link(newLink(a, b)))
+
+	// Pumps waiting for the change are woken up.
+	close(n.changedCh)
+	n.changedCh = make(chan struct{})
+}
+
+// state returns copy of the link state together with the channel closed on the next change.
+func (n *Network) state(l link) linkState {
+	state, _ := n.stateWithChange(l)
+	return state
+}
+
+func (n *Network) stateWithChange(l link) (linkState, <-chan struct{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return *n.link(l), n.changedCh
+}
+
+func (n *Network) sent(l link, from string, size int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.link(l).Sent[from] += uint64(size)
+}
+
+func (n *Network) remove(c *faultConn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.link(c.link).Conns, c)
+}
+
+func (n *Network) link(l link) *linkState {
+	state, exists := n.links[l]
+	if !exists {
+		state = &linkState{
+			Sent:  map[string]uint64{},
+			Conns: map[*faultConn]struct{}{},
+		}
+		n.links[l] = state
+	}
+	return state
+}
+
+type chunk struct {
+	Data []byte
+	Time time.Time
+}
+
+// faultConn is returned to the dialer. Data are pumped between the pipe and the real connection,
+// so faults might be applied to both directions.
+type faultConn struct {
+	net.Conn
+
+	remote      net.Conn
+	peer        net.Conn
+	network     *Network
+	link        link
+	cutMidFrame atomic.Bool
+
+	closeOnce sync.Once
+	closedCh  chan struct{}
+}
+
+func (c *faultConn) RemoteAddr() net.Addr {
+	return c.peer.RemoteAddr()
+}
+
+func (c *faultConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closedCh)
+		_ = c.Conn.Close()
+		_ = c.remote.Close()
+		_ = c.peer.Close()
+		c.network.remove(c)
+	})
+	return nil
+}
+
+// pump copies data sent by the node from src to dst applying the faults.
+func (c *faultConn) pump(src, dst net.Conn, from string) {
+	defer c.Close()
+
+	chunkCh := make(chan chunk, chunkQueueSize)
+	go func() {
+		defer close(chunkCh)
+
+		for {
+			buf := make([]byte, 32*1024)
+			n, err := src.Read(buf)
+			if n > 0 {
+				select {
+				case chunkCh <- chunk{Data: buf[:n], Time: time.Now()}:
+				case <-c.closedCh:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for ch := range chunkCh {
+		if !c.wait(ch.Time) {
+			return
+		}
+
+		data := ch.Data
+		cut := c.cutMidFrame.Load()
+		if cut {
+			data = data[:len(data)/2]
+		}
+
+		// Data are delivered once their transmission time passes.
+		if bandwidth := c.network.state(c.link).Bandwidth; bandwidth > 0 {
+			select {
+			case <-c.closedCh:
+				return
+			case <-time.After(time.Duration(uint64(len(data)) * uint64(time.Second) / bandwidth)):
+			}
+		}
+
+		n, err := dst.Write(data)
+		c.network.sent(c.link, from, n)
+		if err != nil || cut {
+			return
+		}
+	}
+}
+
+// wait waits until link is not partitioned and delay of the chunk sent at the time passes.
+func (c *faultConn) wait(sent time.Time) bool {
+	for {
+		state, changedCh := c.network.stateWithChange(c.link)
+
+		var timer *time.Timer
+		var timerCh <-chan time.Time
+		switch {
+		case state.Partitioned:
+		case time.Since(sent) >= state.Delay:
+			return true
+		default:
+			timer = time.NewTimer(state.Delay - time.Since(sent))
+			timerCh = timer.C
+		}
+
+		select {
+		case <-c.closedCh:
+		case <-changedCh:
+		case <-timerCh:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		select {
+		case <-c.closedCh:
+			return false
+		default:
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Package wavetest runs wave clusters inside the test process.
+package wavetest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/parallel"
+	"github.com/outofforest/qa"
+	"github.com/outofforest/wave"
+)
+
+const (
+	defaultMaxMessageSize = 1024 * 1024
+	defaultTimeout        = 5 * time.Second
+)
+
+// Config is the config of the cluster.
+type Config struct {
+	// Servers is the number of servers in the cluster. If zero, one server is started.
+	Servers        int
+	MaxMessageSize uint64
+
+	// Timeout is the time ExpectMessages waits for messages. If zero, it is 5 seconds.
+	Timeout time.Duration
+
+	// Server modifies the config of the server with the index before it is started.
+	Server func(i int, config *wave.ServerConfig)
+}
+
+// Cluster runs servers and clients until the end of the test. All the connections are dialed through
+// the network, so faults might be injected.
+type Cluster struct {
+	t         *testing.T
+	config    Config
+	group     *parallel.Group
+	network   *Network
+	addresses []string
+	servers   []*wave.Server
+	stops     []context.CancelFunc
+	doneChs   []chan struct{}
+	clients   int
+}
+
+// NewCluster starts servers of the cluster. All of them are stopped when test ends.
+func NewCluster(t *testing.T, config Config) *Cluster {
+	if config.Servers == 0 {
+		config.Servers = 1
+	}
+	if config.MaxMessageSize == 0 {
+		config.MaxMessageSize = defaultMaxMessageSize
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+
+	requireT := require.New(t)
+	ctx := qa.NewContext(t)
+
+	c := &Cluster{
+		t:         t,
+		config:    config,
+		group:     qa.NewGroup(ctx, t),
+		network:   NewNetwork(),
+		addresses: make([]string, 0, config.Servers),
+		servers:   make([]*wave.Server, config.Servers),
+		stops:     make([]context.CancelFunc, config.Servers),
+		doneChs:   make([]chan struct{}, config.Servers),
+	}
+
+	listeners := make([]net.Listener, 0, config.Servers)
+	for range config.Servers {
+		ls, err := net.Listen("tcp", "localhost:0")
+		requireT.NoError(err)
+		listeners = append(listeners, ls)
+		c.addresses = append(c.addresses, ls.Addr().String())
+	}
+
+	for i, ls := range listeners {
+		c.startServer(i, ls)
+	}
+
+	return c
+}
+
+// Server returns the server with the index.
+func (c *Cluster) Server(i int) *wave.Server {
+	return c.servers[i]
+}
+
+// Address returns the address of the server with the index.
+func (c *Cluster) Address(i int) string {
+	return c.addresses[i]
+}
+
+// StopServer stops the server with the index and waits until its address is released.
+func (c *Cluster) StopServer(i int) {
+	c.stops[i]()
+	<-c.doneChs[i]
+}
+
+// StartServer starts new server with the index on the address of the stopped one. State of the stopped server
+// is lost, so the new one synchronizes with the cluster.
+func (c *Cluster) StartServer(i int) {
+	ls, err := net.Listen("tcp", c.addresses[i])
+	require.NoError(c.t, err)
+	c.startServer(i, ls)
+}
+
+// Network returns the network connecting servers and clients.
+func (c *Cluster) Network() *Network {
+	return c.network
+}
+
+// Partition partitions the network between servers with the indexes.
+func (c *Cluster) Partition(i, j int) {
+	c.network.Partition(c.addresses[i], c.addresses[j])
+}
+
+// Heal ends the partition between servers with the indexes.
+func (c *Cluster) Heal(i, j int) {
+	c.network.Heal(c.addresses[i], c.addresses[j])
+}
+
+// Client is the client running in the cluster.
+type Client struct {
+	*wave.Client
+
+	// Name identifies client in the network.
+	Name string
+
+	// Messages delivers received messages.
+	Messages <-chan any
+
+	t       *testing.T
+	timeout time.Duration
+	stop    context.CancelFunc
+}
+
+// NewClient starts client connected only to the servers with the indexes, other servers are not discovered.
+// If no indexes are passed, all the servers are used as seeds. Servers and MaxMessageSize are filled in if they
+// are not set in the config.
+func (c *Cluster) NewClient(config wave.ClientConfig, servers ...int) *Client {
+	c.clients++
+	name := fmt.Sprintf("client-%d", c.clients)
+
+	if len(servers) > 0 {
+		config.DisableDiscovery = true
+	}
+	if len(config.Servers) == 0 {
+		if len(servers) == 0 {
+			config.Servers = c.addresses
+		}
+		for _, i := range servers {
+			config.Servers = append(config.Servers, c.addresses[i])
+		}
+	}
+	if config.MaxMessageSize == 0 {
+		config.MaxMessageSize = c.config.MaxMessageSize
+	}
+	if config.Dialer == nil {
+		config.Dialer = c.network.Dialer(name)
+	}
+
+	client, recvCh, err := wave.NewClient(config)
+	require.NoError(c.t, err)
+
+	return &Client{
+		Client:   client,
+		Name:     name,
+		Messages: recvCh,
+		t:        c.t,
+		timeout:  c.config.Timeout,
+		stop:     c.spawn("client", client.Run),
+	}
+}
+
+// Stop stops the client.
+func (c *Client) Stop() {
+	c.stop()
+}
+
+// ExpectMessages waits for the messages and fails the test if different or additional ones are received.
+// Order of messages doesn't matter.
+func (c *Client) ExpectMessages(msgs ...any) {
+	c.t.Helper()
+
+	expectMessages(c.t, c.Messages, c.timeout, msgs...)
+}
+
+// ExpectMessages waits for the messages delivered to the channel, like Client.ExpectMessages does. It is used
+// for subscriptions and clients not started by the cluster.
+func ExpectMessages(t *testing.T, recvCh <-chan any, msgs ...any) {
+	t.Helper()
+
+	expectMessages(t, recvCh, defaultTimeout, msgs...)
+}
+
+func expectMessages(t *testing.T, recvCh <-chan any, timeout time.Duration, msgs ...any) {
+	t.Helper()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	received := make([]any, 0, len(msgs))
+	for range msgs {
+		select {
+		case <-timer.C:
+			require.FailNow(t, "timeout waiting for messages", "received: %v, expected: %v", received, msgs)
+		case msg, ok := <-recvCh:
+			if !ok {
+				require.FailNow(t, "channel closed", "received: %v, expected: %v", received, msgs)
+			}
+			received = append(received, msg)
+		}
+	}
+
+	require.ElementsMatch(t, msgs, received)
+	require.Empty(t, recvCh)
+}
+
+func (c *Cluster) startServer(i int, ls net.Listener) {
+	config := wave.ServerConfig{
+		Servers:        c.addresses,
+		MaxMessageSize: c.config.MaxMessageSize,
+		Dialer:         c.network.Dialer(c.addresses[i]),
+	}
+	if c.config.Server != nil {
+		c.config.Server(i, &config)
+	}
+
+	server, err := wave.NewServer(config)
+	require.NoError(c.t, err)
+
+	doneCh := make(chan struct{})
+	c.servers[i] = server
+	c.doneChs[i] = doneCh
+	c.stops[i] = c.spawn("server", func(ctx context.Context) error {
+		defer close(doneCh)
+		defer ls.Close()
+
+		return server.Run(ctx, ls)
+	})
+}
+
+// spawn runs the task until test ends or returned function is called.
+func (c *Cluster) spawn(name string, task parallel.Task) context.CancelFunc {
+	ctx, cancel := context.WithCancel(c.group.Context())
+	c.group.Spawn(name, parallel.Continue, func(_ context.Context) error {
+		if err := task(ctx); ctx.Err() == nil {
+			return err
+		}
+		// Task has been stopped on purpose or test has ended.
+		return nil
+	})
+	return cancel
+}
@@ -0,0 +1,394 @@
+package wave
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // SHA-1 is required by WebSocket handshake.
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+
+	wsMaxControlPayload = 125
+)
+
+// wsConn exchanges the byte stream of the wave protocol as binary WebSocket messages, so the same framing
+// is used as on TCP connections.
+type wsConn struct {
+	net.Conn
+
+	reader *bufio.Reader
+	client bool
+
+	// remaining is the number of payload bytes of the current data frame not read yet.
+	remaining uint64
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+
+	mu        sync.Mutex
+	closeOnce sync.Once
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if err := c.readHeader(); err != nil {
+			return 0, err
+		}
+	}
+
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	c.remaining -= uint64(n)
+	if c.masked {
+		c.unmask(p[:n])
+	}
+	return n, errors.WithStack(err)
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		// Close frame is sent only if it doesn't wait for pending write, which might be blocked forever.
+		if c.mu.TryLock() {
+			_, _ = c.Conn.Write(c.frame(wsOpClose, nil))
+			c.mu.Unlock()
+		}
+		err = errors.WithStack(c.Conn.Close())
+	})
+	return err
+}
+
+// readHeader reads the header of the next data frame. Control frames are handled on the way.
+func (c *wsConn) readHeader() error {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return errors.WithStack(err)
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	size := uint64(header[1] & 0x7f)
+
+	if header[0]&0x70 != 0 {
+		return errors.New("websocket extensions are not supported")
+	}
+	// Frames sent by the client must be masked and the ones sent by the server must not.
+	if masked == c.client {
+		return errors.New("invalid websocket frame masking")
+	}
+
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return errors.WithStack(err)
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return errors.WithStack(err)
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+
+	c.masked = masked
+	c.maskPos = 0
+	if masked {
+		if _, err := io.ReadFull(c.reader, c.mask[:]); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	switch opcode {
+	case wsOpContinuation, wsOpBinary:
+		c.remaining = size
+		return nil
+	case wsOpText:
+		return errors.New("text websocket frames are not supported")
+	case wsOpClose, wsOpPing, wsOpPong:
+		if !fin || size > wsMaxControlPayload {
+			return errors.New("invalid websocket control frame")
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			return errors.WithStack(err)
+		}
+		if masked {
+			c.unmask(payload)
+		}
+
+		switch opcode {
+		case wsOpClose:
+			_ = c.Close()
+			return errors.WithStack(io.EOF)
+		case wsOpPing:
+			return c.writeFrame(wsOpPong, payload)
+		default:
+			return nil
+		}
+	default:
+		return errors.Errorf("unknown websocket opcode %d", opcode)
+	}
+}
+
+func (c *wsConn) unmask(p []byte) {
+	for i := range p {
+		p[i] ^= c.mask[c.maskPos%4]
+		c.maskPos++
+	}
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.Conn.Write(c.frame(opcode, payload))
+	return errors.WithStack(err)
+}
+
+func (c *wsConn) frame(opcode byte, payload []byte) []byte {
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode)
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch size := len(payload); {
+	case size <= wsMaxControlPayload:
+		frame = append(frame, maskBit|byte(size))
+	case size <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(size))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(size))
+	}
+
+	if !c.client {
+		return append(frame, payload...)
+	}
+
+	// Reading from crypto/rand never fails, it crashes the program instead.
+	var mask [4]byte
+	_, _ = rand.Read(mask[:])
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func wsAccept(key string) string {
+	//nolint:gosec // SHA-1 is required by WebSocket handshake.
+	hash := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func headerContains(header http.Header, name, token string) bool {
+	for _, v := range header.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dialWebSocket connects to the server accepting WebSocket connections.
+func dialWebSocket(ctx context.Context, address string) (net.Conn, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if u.Scheme != "ws" {
+		return nil, errors.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	c, err := wsHandshake(ctx, conn, u)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func wsHandshake(ctx context.Context, conn net.Conn, u *url.URL) (net.Conn, error) {
+	var keyBytes [16]byte
+	if _, err := rand.Read(keyBytes[:]); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	key := base64.StdEncoding.EncodeToString(keyBytes[:])
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	if err := conn.SetDeadline(time.Now().Add(dialTimeout)); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	_ = resp.Body.Close()
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, errors.Errorf("websocket upgrade failed with status %s", resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		return nil, errors.New("invalid websocket accept key")
+	}
+
+	return &wsConn{
+		Conn:   conn,
+		reader: reader,
+		client: true,
+	}, nil
+}
+
+// wsAddr is the address of the HTTP listener accepting WebSocket connections.
+type wsAddr string
+
+func (a wsAddr) Network() string {
+	return "ws"
+}
+
+func (a wsAddr) String() string {
+	return string(a)
+}
+
+// wsListener accepts connections upgraded to WebSocket by its HTTP handler.
+type wsListener struct {
+	addr    wsAddr
+	connCh  chan net.Conn
+	closeCh chan struct{}
+
+	closeOnce sync.Once
+}
+
+func newWSListener(address string) *wsListener {
+	return &wsListener{
+		addr:    wsAddr(address),
+		connCh:  make(chan net.Conn),
+		closeCh: make(chan struct{}),
+	}
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case <-l.closeCh:
+		return nil, errors.WithStack(net.ErrClosed)
+	case conn := <-l.connCh:
+		return conn, nil
+	}
+}
+
+func (l *wsListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closeCh)
+	})
+	return nil
+}
+
+func (l *wsListener) Addr() net.Addr {
+	return l.addr
+}
+
+// ServeHTTP upgrades the request to WebSocket connection and passes it to the listener.
+func (l *wsListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "websocket upgrade expected", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket is not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+
+	_, err = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n\r\n")
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	select {
+	case <-l.closeCh:
+		_ = conn.Close()
+	case l.connCh <- &wsConn{
+		Conn:   conn,
+		reader: rw.Reader,
+	}:
+	}
+}
@@ -0,0 +1,104 @@
+package wire
+
+import (
+	"github.com/outofforest/proton/helpers"
+	"github.com/pkg/errors"
+)
+
+// IDs of the messages containing slices are taken from the generated marshaller, so they follow
+// the changes in the order of types.
+var (
+	helloID        = messageID(&Hello{})
+	membersID      = messageID(&Members{})
+	subscriptionID = messageID(&Subscription{})
+)
+
+// helloRequestsOffset is the offset of the requests in Hello. Hello starts with the byte of flags followed
+// by the peer ID.
+const helloRequestsOffset = 1 + uint64(len(PeerID{}))
+
+func messageID(msg any) uint64 {
+	id, err := NewMarshaller().ID(msg)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// BoundedMarshaller unmarshals messages received from untrusted peers. Generated code allocates slices
+// of the length declared in the message, so declared lengths are verified against the size of the message first.
+type BoundedMarshaller struct {
+	Marshaller
+}
+
+// NewBoundedMarshaller creates bounded marshaller.
+func NewBoundedMarshaller() BoundedMarshaller {
+	return BoundedMarshaller{
+		Marshaller: NewMarshaller(),
+	}
+}
+
+// Unmarshal unmarshals message.
+func (m BoundedMarshaller) Unmarshal(id uint64, buf []byte) (any, uint64, error) {
+	if err := checkLengths(id, buf); err != nil {
+		return nil, 0, err
+	}
+	return m.Marshaller.Unmarshal(id, buf)
+}
+
+// checkLengths walks the slices of the message following the layout of the generated code.
+// Only Hello, Members and Subscription contain slices.
+func checkLengths(id uint64, b []byte) (retErr error) {
+	defer helpers.RecoverUnmarshal(&retErr)
+
+	switch id {
+	case helloID:
+		o := helloRequestsOffset
+		return checkRequests(b, &o)
+	case membersID:
+		var o uint64
+		if _, err := readLength(b, &o); err != nil {
+			return err
+		}
+	case subscriptionID:
+		var o uint64
+		return checkRequests(b, &o)
+	}
+
+	return nil
+}
+
+func checkRequests(b []byte, o *uint64) error {
+	requests, err := readLength(b, o)
+	if err != nil {
+		return err
+	}
+	for range requests {
+		namespace, err := readLength(b, o)
+		if err != nil {
+			return err
+		}
+		*o += namespace
+
+		messageIDs, err := readLength(b, o)
+		if err != nil {
+			return err
+		}
+		for range messageIDs {
+			var messageID MessageID
+			helpers.UInt64Unmarshal(&messageID, b, o)
+		}
+	}
+	return nil
+}
+
+// readLength reads the length of slice or string. Each element takes at least one byte, so it can't exceed
+// the number of remaining bytes.
+func readLength(b []byte, o *uint64) (uint64, error) {
+	var l uint64
+	helpers.UInt64Unmarshal(&l, b, o)
+	if l > uint64(len(b))-*o {
+		return 0, errors.Errorf("length %d exceeds the remaining size %d of the message", l, uint64(len(b))-*o)
+	}
+	return l, nil
+}
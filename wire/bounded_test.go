@@ -0,0 +1,138 @@
+package wire
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// peerID is filled with bytes which would be read as huge lengths if layout of Hello was not followed.
+var peerID = PeerID{
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+}
+
+func TestCheckedMessagesMatchGeneratedIDs(t *testing.T) {
+	requireT := require.New(t)
+
+	m := NewMarshaller()
+	checked := map[uint64]reflect.Type{
+		helloID:        reflect.TypeOf(Hello{}),
+		membersID:      reflect.TypeOf(Members{}),
+		subscriptionID: reflect.TypeOf(Subscription{}),
+	}
+	requireT.Len(checked, 3)
+
+	// Every message containing slices must be checked before it is unmarshalled.
+	for _, msg := range m.Messages() {
+		msgType := reflect.TypeOf(msg)
+		id, err := m.ID(reflect.New(msgType).Interface())
+		requireT.NoError(err)
+
+		if expectedType, exists := checked[id]; exists {
+			requireT.Equal(expectedType, msgType)
+			continue
+		}
+		requireT.False(containsSlice(msgType), "message %s contains slices but it is not checked", msgType)
+	}
+}
+
+func TestHelloLayout(t *testing.T) {
+	requireT := require.New(t)
+
+	_, buf := marshal(t, &Hello{
+		PeerID:   peerID,
+		IsServer: true,
+		Probe:    true,
+		Requests: []NamespaceRequest{{Namespace: "namespace"}},
+	})
+
+	// Peer ID is followed by the number of requests and the length of the namespace.
+	requireT.Equal(peerID[:], buf[helloRequestsOffset-uint64(len(peerID)):helloRequestsOffset])
+	requireT.Equal([]byte{1, byte(len("namespace"))}, buf[helloRequestsOffset:helloRequestsOffset+2])
+}
+
+func TestBoundedMarshallerAcceptsValidMessages(t *testing.T) {
+	requests := []NamespaceRequest{
+		{
+			Namespace:  "namespace",
+			MessageIDs: []MessageID{1, 1000, 1 << 40},
+		},
+		{
+			Namespace: AnyNamespace,
+		},
+		{
+			Namespace:  Namespace(strings.Repeat("a", 300)),
+			MessageIDs: []MessageID{2},
+		},
+	}
+
+	for _, msg := range []any{
+		&Hello{PeerID: peerID},
+		&Hello{PeerID: peerID, IsServer: true, Probe: true},
+		&Hello{PeerID: peerID, Requests: requests},
+		&Members{},
+		&Members{
+			Members: []Member{
+				{PeerID: peerID, Address: "localhost:1234", Heartbeat: 10},
+				{PeerID: peerID, Address: "localhost:1235", Heartbeat: 1 << 40},
+			},
+		},
+		&Subscription{},
+		&Subscription{Requests: requests},
+	} {
+		requireT := require.New(t)
+
+		id, buf := marshal(t, msg)
+		msg2, size, err := NewBoundedMarshaller().Unmarshal(id, buf)
+		requireT.NoError(err)
+		requireT.Equal(uint64(len(buf)), size)
+		requireT.Equal(msg, msg2)
+	}
+}
+
+func TestBoundedMarshallerRejectsExcessiveLengths(t *testing.T) {
+	for _, msg := range []any{
+		&Hello{PeerID: peerID, Requests: make([]NamespaceRequest, 1000)},
+		&Hello{PeerID: peerID, Requests: []NamespaceRequest{{MessageIDs: make([]MessageID, 1000)}}},
+		&Members{Members: make([]Member, 1000)},
+		&Subscription{Requests: make([]NamespaceRequest, 1000)},
+		&Subscription{Requests: []NamespaceRequest{{Namespace: Namespace(strings.Repeat("a", 1000))}}},
+	} {
+		requireT := require.New(t)
+
+		// Declared lengths stay untouched, but elements are cut off, so there are fewer bytes than elements.
+		id, buf := marshal(t, msg)
+		_, _, err := NewBoundedMarshaller().Unmarshal(id, buf[:100])
+		requireT.ErrorContains(err, "exceeds the remaining size")
+	}
+}
+
+func marshal(t *testing.T, msg any) (uint64, []byte) {
+	m := NewMarshaller()
+	size, err := m.Size(msg)
+	require.NoError(t, err)
+	buf := make([]byte, size)
+	id, n, err := m.Marshal(msg, buf)
+	require.NoError(t, err)
+	require.Equal(t, size, n)
+	return id, buf
+}
+
+func containsSlice(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Slice:
+		return true
+	case reflect.Array:
+		return containsSlice(t.Elem())
+	case reflect.Struct:
+		for i := range t.NumField() {
+			if containsSlice(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
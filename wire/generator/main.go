@@ -10,5 +10,11 @@ func main() {
 	proton.Generate("../types.proton.go",
 		proton.Message[wire.Hello](),
 		proton.Message[wire.Header](),
+		proton.Message[wire.Digest](),
+		proton.Message[wire.DigestEntry](),
+		proton.Message[wire.DigestEnd](),
+		proton.Message[wire.SyncEnd](),
+		proton.Message[wire.Members](),
+		proton.Message[wire.Subscription](),
 	)
 }
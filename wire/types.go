@@ -1,5 +1,11 @@
 package wire
 
+import (
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
 type (
 	// PeerID defines peer ID.
 	PeerID [32]byte
@@ -12,9 +18,36 @@ type (
 
 	// Revision is the revision of the message used for deduplication.
 	Revision uint64
+
+	// TraceID identifies the trace.
+	TraceID [16]byte
+
+	// SpanID identifies the span within the trace.
+	SpanID [8]byte
 )
 
-// NamespaceRequest defines messages to receive.
+func (id PeerID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// MarshalText encodes peer ID as hex string.
+func (id PeerID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText decodes peer ID from hex string.
+func (id *PeerID) UnmarshalText(text []byte) error {
+	if hex.DecodedLen(len(text)) != len(id) {
+		return errors.Errorf("invalid peer ID length %d", len(text))
+	}
+	_, err := hex.Decode(id[:], text)
+	return errors.WithStack(err)
+}
+
+// AnyNamespace is the namespace used in requests to receive messages of all namespaces.
+const AnyNamespace Namespace = "*"
+
+// NamespaceRequest defines messages to receive. If MessageIDs is empty, all messages of the namespace are requested.
 type NamespaceRequest struct {
 	Namespace  Namespace
 	MessageIDs []MessageID
@@ -25,12 +58,24 @@ type Hello struct {
 	PeerID   PeerID
 	IsServer bool
 	Requests []NamespaceRequest
+
+	// Probe is set by client measuring latency. Connection is closed right after exchanging hello messages.
+	Probe bool
+}
+
+// Subscription is sent by client to replace the requests sent in hello.
+type Subscription struct {
+	Requests []NamespaceRequest
 }
 
 // MessageDescriptor uniquely identifies type of exchanged message.
 type MessageDescriptor struct {
 	Namespace Namespace
 	MessageID MessageID
+
+	// Fingerprint is derived from the field layout of the message type, so receivers detect messages
+	// defined differently on the sender. Zero means layout is not known.
+	Fingerprint uint64
 }
 
 // RevisionDescriptor uniquely identifies revision of message.
@@ -39,8 +84,61 @@ type RevisionDescriptor struct {
 	Index   Revision
 }
 
+// TraceContext is the trace context in the style of W3C traceparent. Zero value means revision is not traced.
+type TraceContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   uint8
+}
+
+// IsZero returns true if trace context is not set.
+func (tc TraceContext) IsZero() bool {
+	return tc.TraceID == TraceID{}
+}
+
+// String returns trace context in W3C traceparent format.
+func (tc TraceContext) String() string {
+	return "00-" + hex.EncodeToString(tc.TraceID[:]) + "-" + hex.EncodeToString(tc.SpanID[:]) + "-" +
+		hex.EncodeToString([]byte{tc.Flags})
+}
+
 // Header describes the following message.
 type Header struct {
 	Sender   PeerID
 	Revision RevisionDescriptor
+
+	// Trace is set by the publisher and propagated unchanged by servers.
+	Trace TraceContext
+}
+
+// DigestBuckets is the number of buckets revisions are hashed into when peers compare their state.
+const DigestBuckets = 32
+
+// Digest summarizes revisions known to the peer.
+type Digest struct {
+	Buckets [DigestBuckets]uint64
+}
+
+// DigestEntry announces revision known to the peer in a bucket which differs between peers.
+type DigestEntry struct {
+	Sender   PeerID
+	Revision RevisionDescriptor
+}
+
+// DigestEnd marks the end of digest entries.
+type DigestEnd struct{}
+
+// SyncEnd is sent after all the revisions missing on the peer, so it knows it has caught up.
+type SyncEnd struct{}
+
+// Member describes server being a member of the cluster.
+type Member struct {
+	PeerID    PeerID
+	Address   string
+	Heartbeat uint64
+}
+
+// Members is the list of cluster members gossiped between servers.
+type Members struct {
+	Members []Member
 }
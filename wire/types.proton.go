@@ -10,7 +10,13 @@ import (
 )
 
 const (
-	id4 uint64 = iota + 1
+	id12 uint64 = iota + 1
+	id11
+	id9
+	id7
+	id5
+	id4
+	id3
 	id1
 )
 
@@ -30,6 +36,12 @@ func (m Marshaller) Messages() []any {
 	return []any {
 		Hello{},
 		Header{},
+		Digest{},
+		DigestEntry{},
+		DigestEnd{},
+		SyncEnd{},
+		Members{},
+		Subscription{},
 	}
 }
 
@@ -37,8 +49,20 @@ func (m Marshaller) Messages() []any {
 func (m Marshaller) ID(msg any) (uint64, error) {
 	switch msg.(type) {
 	case *Hello:
-		return id4, nil
+		return id12, nil
 	case *Header:
+		return id11, nil
+	case *Digest:
+		return id9, nil
+	case *DigestEntry:
+		return id7, nil
+	case *DigestEnd:
+		return id5, nil
+	case *SyncEnd:
+		return id4, nil
+	case *Members:
+		return id3, nil
+	case *Subscription:
 		return id1, nil
 	default:
 		return 0, errors.Errorf("unknown message type %T", msg)
@@ -49,8 +73,20 @@ func (m Marshaller) ID(msg any) (uint64, error) {
 func (m Marshaller) Size(msg any) (uint64, error) {
 	switch msg2 := msg.(type) {
 	case *Hello:
-		return size4(msg2), nil
+		return size12(msg2), nil
 	case *Header:
+		return size11(msg2), nil
+	case *Digest:
+		return size9(msg2), nil
+	case *DigestEntry:
+		return size7(msg2), nil
+	case *DigestEnd:
+		return size5(msg2), nil
+	case *SyncEnd:
+		return size4(msg2), nil
+	case *Members:
+		return size3(msg2), nil
+	case *Subscription:
 		return size1(msg2), nil
 	default:
 		return 0, errors.Errorf("unknown message type %T", msg)
@@ -63,81 +99,891 @@ func (m Marshaller) Marshal(msg any, buf []byte) (retID, retSize uint64, retErr
 
 	switch msg2 := msg.(type) {
 	case *Hello:
-		return id4, marshal4(msg2, buf), nil
+		return id12, marshal12(msg2, buf), nil
 	case *Header:
+		return id11, marshal11(msg2, buf), nil
+	case *Digest:
+		return id9, marshal9(msg2, buf), nil
+	case *DigestEntry:
+		return id7, marshal7(msg2, buf), nil
+	case *DigestEnd:
+		return id5, marshal5(msg2, buf), nil
+	case *SyncEnd:
+		return id4, marshal4(msg2, buf), nil
+	case *Members:
+		return id3, marshal3(msg2, buf), nil
+	case *Subscription:
 		return id1, marshal1(msg2, buf), nil
 	default:
 		return 0, 0, errors.Errorf("unknown message type %T", msg)
 	}
 }
 
-// Unmarshal unmarshals message.
-func (m Marshaller) Unmarshal(id uint64, buf []byte) (retMsg any, retSize uint64, retErr error) {
-	defer helpers.RecoverUnmarshal(&retErr)
+// Unmarshal unmarshals message.
+func (m Marshaller) Unmarshal(id uint64, buf []byte) (retMsg any, retSize uint64, retErr error) {
+	defer helpers.RecoverUnmarshal(&retErr)
+
+	switch id {
+	case id12:
+		msg := &Hello{}
+		return msg, unmarshal12(msg, buf), nil
+	case id11:
+		msg := &Header{}
+		return msg, unmarshal11(msg, buf), nil
+	case id9:
+		msg := &Digest{}
+		return msg, unmarshal9(msg, buf), nil
+	case id7:
+		msg := &DigestEntry{}
+		return msg, unmarshal7(msg, buf), nil
+	case id5:
+		msg := &DigestEnd{}
+		return msg, unmarshal5(msg, buf), nil
+	case id4:
+		msg := &SyncEnd{}
+		return msg, unmarshal4(msg, buf), nil
+	case id3:
+		msg := &Members{}
+		return msg, unmarshal3(msg, buf), nil
+	case id1:
+		msg := &Subscription{}
+		return msg, unmarshal1(msg, buf), nil
+	default:
+		return nil, 0, errors.Errorf("unknown ID %d", id)
+	}
+}
+
+// IsPatchNeeded checks if non-empty patch exists.
+func (m Marshaller) IsPatchNeeded(msgDst, msgSrc any) (bool, error) {
+	switch msg2 := msgDst.(type) {
+	case *Hello:
+		return isPatchNeeded12(msg2, msgSrc.(*Hello)), nil
+	case *Header:
+		return isPatchNeeded11(msg2, msgSrc.(*Header)), nil
+	case *Digest:
+		return isPatchNeeded9(msg2, msgSrc.(*Digest)), nil
+	case *DigestEntry:
+		return isPatchNeeded7(msg2, msgSrc.(*DigestEntry)), nil
+	case *DigestEnd:
+		return isPatchNeeded5(msg2, msgSrc.(*DigestEnd)), nil
+	case *SyncEnd:
+		return isPatchNeeded4(msg2, msgSrc.(*SyncEnd)), nil
+	case *Members:
+		return isPatchNeeded3(msg2, msgSrc.(*Members)), nil
+	case *Subscription:
+		return isPatchNeeded1(msg2, msgSrc.(*Subscription)), nil
+	default:
+		return false, errors.Errorf("unknown message type %T", msgDst)
+	}
+}
+
+// MakePatch creates a patch.
+func (m Marshaller) MakePatch(msgDst, msgSrc any, buf []byte) (retID, retSize uint64, retErr error) {
+	defer helpers.RecoverMakePatch(&retErr)
+
+	switch msg2 := msgDst.(type) {
+	case *Hello:
+		return id12, makePatch12(msg2, msgSrc.(*Hello), buf), nil
+	case *Header:
+		return id11, makePatch11(msg2, msgSrc.(*Header), buf), nil
+	case *Digest:
+		return id9, makePatch9(msg2, msgSrc.(*Digest), buf), nil
+	case *DigestEntry:
+		return id7, makePatch7(msg2, msgSrc.(*DigestEntry), buf), nil
+	case *DigestEnd:
+		return id5, makePatch5(msg2, msgSrc.(*DigestEnd), buf), nil
+	case *SyncEnd:
+		return id4, makePatch4(msg2, msgSrc.(*SyncEnd), buf), nil
+	case *Members:
+		return id3, makePatch3(msg2, msgSrc.(*Members), buf), nil
+	case *Subscription:
+		return id1, makePatch1(msg2, msgSrc.(*Subscription), buf), nil
+	default:
+		return 0, 0, errors.Errorf("unknown message type %T", msgDst)
+	}
+}
+
+// ApplyPatch applies patch.
+func (m Marshaller) ApplyPatch(msg any, buf []byte) (retSize uint64, retErr error) {
+	defer helpers.RecoverApplyPatch(&retErr)
+
+	switch msg2 := msg.(type) {
+	case *Hello:
+		return applyPatch12(msg2, buf), nil
+	case *Header:
+		return applyPatch11(msg2, buf), nil
+	case *Digest:
+		return applyPatch9(msg2, buf), nil
+	case *DigestEntry:
+		return applyPatch7(msg2, buf), nil
+	case *DigestEnd:
+		return applyPatch5(msg2, buf), nil
+	case *SyncEnd:
+		return applyPatch4(msg2, buf), nil
+	case *Members:
+		return applyPatch3(msg2, buf), nil
+	case *Subscription:
+		return applyPatch1(msg2, buf), nil
+	default:
+		return 0, errors.Errorf("unknown message type %T", msg)
+	}
+}
+
+func size1(m *Subscription) uint64 {
+	var n uint64 = 1
+	{
+		// Requests
+
+		l := uint64(len(m.Requests))
+		helpers.UInt64Size(l, &n)
+		for _, sv1 := range m.Requests {
+			n += size0(&sv1)
+		}
+	}
+	return n
+}
+
+func marshal1(m *Subscription, b []byte) uint64 {
+	var o uint64
+	{
+		// Requests
+
+		helpers.UInt64Marshal(uint64(len(m.Requests)), b, &o)
+		for _, sv1 := range m.Requests {
+			o += marshal0(&sv1, b[o:])
+		}
+	}
+
+	return o
+}
+
+func unmarshal1(m *Subscription, b []byte) uint64 {
+	var o uint64
+	{
+		// Requests
+
+		var l uint64
+		helpers.UInt64Unmarshal(&l, b, &o)
+		if l > 0 {
+			m.Requests = make([]NamespaceRequest, l)
+			for i1 := range l {
+				o += unmarshal0(&m.Requests[i1], b[o:])
+			}
+		}
+	}
+
+	return o
+}
+
+func isPatchNeeded1(m, mSrc *Subscription) bool {
+	{
+		// Requests
+
+		if !reflect.DeepEqual(m.Requests, mSrc.Requests) {
+			return true
+		}
+
+	}
+
+	return false
+}
+
+func makePatch1(m, mSrc *Subscription, b []byte) uint64 {
+	var o uint64 = 1
+	{
+		// Requests
+
+		if reflect.DeepEqual(m.Requests, mSrc.Requests) {
+			b[0] &= 0xFE
+		} else {
+			b[0] |= 0x01
+			helpers.UInt64Marshal(uint64(len(m.Requests)), b, &o)
+			for _, sv1 := range m.Requests {
+				o += marshal0(&sv1, b[o:])
+			}
+		}
+	}
+
+	return o
+}
+
+func applyPatch1(m *Subscription, b []byte) uint64 {
+	var o uint64 = 1
+	{
+		// Requests
+
+		if b[0]&0x01 != 0 {
+			var l uint64
+			helpers.UInt64Unmarshal(&l, b, &o)
+			if l > 0 {
+				m.Requests = make([]NamespaceRequest, l)
+				for i1 := range l {
+					o += unmarshal0(&m.Requests[i1], b[o:])
+				}
+			}
+		}
+	}
+
+	return o
+}
+
+func size0(m *NamespaceRequest) uint64 {
+	var n uint64 = 2
+	{
+		// Namespace
+
+		{
+			l := uint64(len(m.Namespace))
+			helpers.UInt64Size(l, &n)
+			n += l
+		}
+	}
+	{
+		// MessageIDs
+
+		l := uint64(len(m.MessageIDs))
+		helpers.UInt64Size(l, &n)
+		n += l
+		for _, sv1 := range m.MessageIDs {
+			helpers.UInt64Size(sv1, &n)
+		}
+	}
+	return n
+}
+
+func marshal0(m *NamespaceRequest, b []byte) uint64 {
+	var o uint64
+	{
+		// Namespace
+
+		{
+			l := uint64(len(m.Namespace))
+			helpers.UInt64Marshal(l, b, &o)
+			copy(b[o:o+l], m.Namespace)
+			o += l
+		}
+	}
+	{
+		// MessageIDs
+
+		helpers.UInt64Marshal(uint64(len(m.MessageIDs)), b, &o)
+		for _, sv1 := range m.MessageIDs {
+			helpers.UInt64Marshal(sv1, b, &o)
+		}
+	}
+
+	return o
+}
+
+func unmarshal0(m *NamespaceRequest, b []byte) uint64 {
+	var o uint64
+	{
+		// Namespace
+
+		{
+			var l uint64
+			helpers.UInt64Unmarshal(&l, b, &o)
+			if l > 0 {
+				m.Namespace = Namespace(b[o:o+l])
+				o += l
+			}
+		}
+	}
+	{
+		// MessageIDs
+
+		var l uint64
+		helpers.UInt64Unmarshal(&l, b, &o)
+		if l > 0 {
+			m.MessageIDs = make([]MessageID, l)
+			for i1 := range l {
+				helpers.UInt64Unmarshal(&m.MessageIDs[i1], b, &o)
+			}
+		}
+	}
+
+	return o
+}
+
+func size3(m *Members) uint64 {
+	var n uint64 = 1
+	{
+		// Members
+
+		l := uint64(len(m.Members))
+		helpers.UInt64Size(l, &n)
+		for _, sv1 := range m.Members {
+			n += size2(&sv1)
+		}
+	}
+	return n
+}
+
+func marshal3(m *Members, b []byte) uint64 {
+	var o uint64
+	{
+		// Members
+
+		helpers.UInt64Marshal(uint64(len(m.Members)), b, &o)
+		for _, sv1 := range m.Members {
+			o += marshal2(&sv1, b[o:])
+		}
+	}
+
+	return o
+}
+
+func unmarshal3(m *Members, b []byte) uint64 {
+	var o uint64
+	{
+		// Members
+
+		var l uint64
+		helpers.UInt64Unmarshal(&l, b, &o)
+		if l > 0 {
+			m.Members = make([]Member, l)
+			for i1 := range l {
+				o += unmarshal2(&m.Members[i1], b[o:])
+			}
+		}
+	}
+
+	return o
+}
+
+func isPatchNeeded3(m, mSrc *Members) bool {
+	{
+		// Members
+
+		if !reflect.DeepEqual(m.Members, mSrc.Members) {
+			return true
+		}
+
+	}
+
+	return false
+}
+
+func makePatch3(m, mSrc *Members, b []byte) uint64 {
+	var o uint64 = 1
+	{
+		// Members
+
+		if reflect.DeepEqual(m.Members, mSrc.Members) {
+			b[0] &= 0xFE
+		} else {
+			b[0] |= 0x01
+			helpers.UInt64Marshal(uint64(len(m.Members)), b, &o)
+			for _, sv1 := range m.Members {
+				o += marshal2(&sv1, b[o:])
+			}
+		}
+	}
+
+	return o
+}
+
+func applyPatch3(m *Members, b []byte) uint64 {
+	var o uint64 = 1
+	{
+		// Members
+
+		if b[0]&0x01 != 0 {
+			var l uint64
+			helpers.UInt64Unmarshal(&l, b, &o)
+			if l > 0 {
+				m.Members = make([]Member, l)
+				for i1 := range l {
+					o += unmarshal2(&m.Members[i1], b[o:])
+				}
+			}
+		}
+	}
+
+	return o
+}
+
+func size2(m *Member) uint64 {
+	var n uint64 = 34
+	{
+		// Address
+
+		{
+			l := uint64(len(m.Address))
+			helpers.UInt64Size(l, &n)
+			n += l
+		}
+	}
+	{
+		// Heartbeat
+
+		helpers.UInt64Size(m.Heartbeat, &n)
+	}
+	return n
+}
+
+func marshal2(m *Member, b []byte) uint64 {
+	var o uint64
+	{
+		// PeerID
+
+		copy(b[o:o+32], unsafe.Slice(&m.PeerID[0], 32))
+		o += 32
+	}
+	{
+		// Address
+
+		{
+			l := uint64(len(m.Address))
+			helpers.UInt64Marshal(l, b, &o)
+			copy(b[o:o+l], m.Address)
+			o += l
+		}
+	}
+	{
+		// Heartbeat
+
+		helpers.UInt64Marshal(m.Heartbeat, b, &o)
+	}
+
+	return o
+}
+
+func unmarshal2(m *Member, b []byte) uint64 {
+	var o uint64
+	{
+		// PeerID
+
+		copy(unsafe.Slice(&m.PeerID[0], 32), b[o:o+32])
+		o += 32
+	}
+	{
+		// Address
+
+		{
+			var l uint64
+			helpers.UInt64Unmarshal(&l, b, &o)
+			if l > 0 {
+				m.Address = string(b[o:o+l])
+				o += l
+			}
+		}
+	}
+	{
+		// Heartbeat
+
+		helpers.UInt64Unmarshal(&m.Heartbeat, b, &o)
+	}
+
+	return o
+}
+
+func size4(m *SyncEnd) uint64 {
+	var n uint64
+	return n
+}
+
+func marshal4(m *SyncEnd, b []byte) uint64 {
+	var o uint64
+
+	return o
+}
+
+func unmarshal4(m *SyncEnd, b []byte) uint64 {
+	var o uint64
+
+	return o
+}
+
+func isPatchNeeded4(m, mSrc *SyncEnd) bool {
+
+	return false
+}
+
+func makePatch4(m, mSrc *SyncEnd, b []byte) uint64 {
+	var o uint64
+
+	return o
+}
+
+func applyPatch4(m *SyncEnd, b []byte) uint64 {
+	var o uint64
+
+	return o
+}
+
+func size5(m *DigestEnd) uint64 {
+	var n uint64
+	return n
+}
+
+func marshal5(m *DigestEnd, b []byte) uint64 {
+	var o uint64
+
+	return o
+}
+
+func unmarshal5(m *DigestEnd, b []byte) uint64 {
+	var o uint64
+
+	return o
+}
+
+func isPatchNeeded5(m, mSrc *DigestEnd) bool {
+
+	return false
+}
+
+func makePatch5(m, mSrc *DigestEnd, b []byte) uint64 {
+	var o uint64
+
+	return o
+}
+
+func applyPatch5(m *DigestEnd, b []byte) uint64 {
+	var o uint64
+
+	return o
+}
+
+func size7(m *DigestEntry) uint64 {
+	var n uint64 = 32
+	{
+		// Revision
+
+		n += size6(&m.Revision)
+	}
+	return n
+}
+
+func marshal7(m *DigestEntry, b []byte) uint64 {
+	var o uint64
+	{
+		// Sender
+
+		copy(b[o:o+32], unsafe.Slice(&m.Sender[0], 32))
+		o += 32
+	}
+	{
+		// Revision
+
+		o += marshal6(&m.Revision, b[o:])
+	}
+
+	return o
+}
+
+func unmarshal7(m *DigestEntry, b []byte) uint64 {
+	var o uint64
+	{
+		// Sender
+
+		copy(unsafe.Slice(&m.Sender[0], 32), b[o:o+32])
+		o += 32
+	}
+	{
+		// Revision
+
+		o += unmarshal6(&m.Revision, b[o:])
+	}
+
+	return o
+}
+
+func isPatchNeeded7(m, mSrc *DigestEntry) bool {
+	{
+		// Sender
+
+		if !reflect.DeepEqual(m.Sender, mSrc.Sender) {
+			return true
+		}
+
+	}
+	{
+		// Revision
+
+		if !reflect.DeepEqual(m.Revision, mSrc.Revision) {
+			return true
+		}
+
+	}
+
+	return false
+}
+
+func makePatch7(m, mSrc *DigestEntry, b []byte) uint64 {
+	var o uint64 = 1
+	{
+		// Sender
+
+		if reflect.DeepEqual(m.Sender, mSrc.Sender) {
+			b[0] &= 0xFE
+		} else {
+			b[0] |= 0x01
+			copy(b[o:o+32], unsafe.Slice(&m.Sender[0], 32))
+			o += 32
+		}
+	}
+	{
+		// Revision
+
+		if reflect.DeepEqual(m.Revision, mSrc.Revision) {
+			b[0] &= 0xFD
+		} else {
+			b[0] |= 0x02
+			o += marshal6(&m.Revision, b[o:])
+		}
+	}
+
+	return o
+}
+
+func applyPatch7(m *DigestEntry, b []byte) uint64 {
+	var o uint64 = 1
+	{
+		// Sender
+
+		if b[0]&0x01 != 0 {
+			copy(unsafe.Slice(&m.Sender[0], 32), b[o:o+32])
+			o += 32
+		}
+	}
+	{
+		// Revision
+
+		if b[0]&0x02 != 0 {
+			o += unmarshal6(&m.Revision, b[o:])
+		}
+	}
+
+	return o
+}
+
+func size6(m *RevisionDescriptor) uint64 {
+	var n uint64 = 1
+	{
+		// Message
+
+		n += size8(&m.Message)
+	}
+	{
+		// Index
+
+		helpers.UInt64Size(m.Index, &n)
+	}
+	return n
+}
+
+func marshal6(m *RevisionDescriptor, b []byte) uint64 {
+	var o uint64
+	{
+		// Message
+
+		o += marshal8(&m.Message, b[o:])
+	}
+	{
+		// Index
+
+		helpers.UInt64Marshal(m.Index, b, &o)
+	}
+
+	return o
+}
+
+func unmarshal6(m *RevisionDescriptor, b []byte) uint64 {
+	var o uint64
+	{
+		// Message
+
+		o += unmarshal8(&m.Message, b[o:])
+	}
+	{
+		// Index
+
+		helpers.UInt64Unmarshal(&m.Index, b, &o)
+	}
+
+	return o
+}
+
+func size8(m *MessageDescriptor) uint64 {
+	var n uint64 = 3
+	{
+		// Namespace
+
+		{
+			l := uint64(len(m.Namespace))
+			helpers.UInt64Size(l, &n)
+			n += l
+		}
+	}
+	{
+		// MessageID
+
+		helpers.UInt64Size(m.MessageID, &n)
+	}
+	{
+		// Fingerprint
+
+		helpers.UInt64Size(m.Fingerprint, &n)
+	}
+	return n
+}
+
+func marshal8(m *MessageDescriptor, b []byte) uint64 {
+	var o uint64
+	{
+		// Namespace
+
+		{
+			l := uint64(len(m.Namespace))
+			helpers.UInt64Marshal(l, b, &o)
+			copy(b[o:o+l], m.Namespace)
+			o += l
+		}
+	}
+	{
+		// MessageID
+
+		helpers.UInt64Marshal(m.MessageID, b, &o)
+	}
+	{
+		// Fingerprint
+
+		helpers.UInt64Marshal(m.Fingerprint, b, &o)
+	}
+
+	return o
+}
+
+func unmarshal8(m *MessageDescriptor, b []byte) uint64 {
+	var o uint64
+	{
+		// Namespace
+
+		{
+			var l uint64
+			helpers.UInt64Unmarshal(&l, b, &o)
+			if l > 0 {
+				m.Namespace = Namespace(b[o:o+l])
+				o += l
+			}
+		}
+	}
+	{
+		// MessageID
+
+		helpers.UInt64Unmarshal(&m.MessageID, b, &o)
+	}
+	{
+		// Fingerprint
+
+		helpers.UInt64Unmarshal(&m.Fingerprint, b, &o)
+	}
+
+	return o
+}
+
+func size9(m *Digest) uint64 {
+	var n uint64 = 32
+	{
+		// Buckets
+
+		for _, av1 := range m.Buckets {
+			helpers.UInt64Size(av1, &n)
+		}
+	}
+	return n
+}
+
+func marshal9(m *Digest, b []byte) uint64 {
+	var o uint64
+	{
+		// Buckets
+
+		for _, av1 := range m.Buckets {
+			helpers.UInt64Marshal(av1, b, &o)
+		}
+	}
+
+	return o
+}
+
+func unmarshal9(m *Digest, b []byte) uint64 {
+	var o uint64
+	{
+		// Buckets
 
-	switch id {
-	case id4:
-		msg := &Hello{}
-		return msg, unmarshal4(msg, buf), nil
-	case id1:
-		msg := &Header{}
-		return msg, unmarshal1(msg, buf), nil
-	default:
-		return nil, 0, errors.Errorf("unknown ID %d", id)
+		for i1 := range 32 {
+			helpers.UInt64Unmarshal(&m.Buckets[i1], b, &o)
+		}
 	}
+
+	return o
 }
 
-// IsPatchNeeded checks if non-empty patch exists.
-func (m Marshaller) IsPatchNeeded(msgDst, msgSrc any) (bool, error) {
-	switch msg2 := msgDst.(type) {
-	case *Hello:
-		return isPatchNeeded4(msg2, msgSrc.(*Hello)), nil
-	case *Header:
-		return isPatchNeeded1(msg2, msgSrc.(*Header)), nil
-	default:
-		return false, errors.Errorf("unknown message type %T", msgDst)
+func isPatchNeeded9(m, mSrc *Digest) bool {
+	{
+		// Buckets
+
+		if !reflect.DeepEqual(m.Buckets, mSrc.Buckets) {
+			return true
+		}
+
 	}
+
+	return false
 }
 
-// MakePatch creates a patch.
-func (m Marshaller) MakePatch(msgDst, msgSrc any, buf []byte) (retID, retSize uint64, retErr error) {
-	defer helpers.RecoverMakePatch(&retErr)
+func makePatch9(m, mSrc *Digest, b []byte) uint64 {
+	var o uint64 = 1
+	{
+		// Buckets
 
-	switch msg2 := msgDst.(type) {
-	case *Hello:
-		return id4, makePatch4(msg2, msgSrc.(*Hello), buf), nil
-	case *Header:
-		return id1, makePatch1(msg2, msgSrc.(*Header), buf), nil
-	default:
-		return 0, 0, errors.Errorf("unknown message type %T", msgDst)
+		if reflect.DeepEqual(m.Buckets, mSrc.Buckets) {
+			b[0] &= 0xFE
+		} else {
+			b[0] |= 0x01
+			for _, av1 := range m.Buckets {
+				helpers.UInt64Marshal(av1, b, &o)
+			}
+		}
 	}
+
+	return o
 }
 
-// ApplyPatch applies patch.
-func (m Marshaller) ApplyPatch(msg any, buf []byte) (retSize uint64, retErr error) {
-	defer helpers.RecoverApplyPatch(&retErr)
+func applyPatch9(m *Digest, b []byte) uint64 {
+	var o uint64 = 1
+	{
+		// Buckets
 
-	switch msg2 := msg.(type) {
-	case *Hello:
-		return applyPatch4(msg2, buf), nil
-	case *Header:
-		return applyPatch1(msg2, buf), nil
-	default:
-		return 0, errors.Errorf("unknown message type %T", msg)
+		if b[0]&0x01 != 0 {
+			for i1 := range 32 {
+				helpers.UInt64Unmarshal(&m.Buckets[i1], b, &o)
+			}
+		}
 	}
+
+	return o
 }
 
-func size1(m *Header) uint64 {
+func size11(m *Header) uint64 {
 	var n uint64 = 32
 	{
 		// Revision
 
-		n += size0(&m.Revision)
+		n += size6(&m.Revision)
+	}
+	{
+		// Trace
+
+		n += size10(&m.Trace)
 	}
 	return n
 }
 
-func marshal1(m *Header, b []byte) uint64 {
+func marshal11(m *Header, b []byte) uint64 {
 	var o uint64
 	{
 		// Sender
@@ -148,13 +994,18 @@ func marshal1(m *Header, b []byte) uint64 {
 	{
 		// Revision
 
-		o += marshal0(&m.Revision, b[o:])
+		o += marshal6(&m.Revision, b[o:])
+	}
+	{
+		// Trace
+
+		o += marshal10(&m.Trace, b[o:])
 	}
 
 	return o
 }
 
-func unmarshal1(m *Header, b []byte) uint64 {
+func unmarshal11(m *Header, b []byte) uint64 {
 	var o uint64
 	{
 		// Sender
@@ -165,13 +1016,18 @@ func unmarshal1(m *Header, b []byte) uint64 {
 	{
 		// Revision
 
-		o += unmarshal0(&m.Revision, b[o:])
+		o += unmarshal6(&m.Revision, b[o:])
+	}
+	{
+		// Trace
+
+		o += unmarshal10(&m.Trace, b[o:])
 	}
 
 	return o
 }
 
-func isPatchNeeded1(m, mSrc *Header) bool {
+func isPatchNeeded11(m, mSrc *Header) bool {
 	{
 		// Sender
 
@@ -187,12 +1043,20 @@ func isPatchNeeded1(m, mSrc *Header) bool {
 			return true
 		}
 
+	}
+	{
+		// Trace
+
+		if !reflect.DeepEqual(m.Trace, mSrc.Trace) {
+			return true
+		}
+
 	}
 
 	return false
 }
 
-func makePatch1(m, mSrc *Header, b []byte) uint64 {
+func makePatch11(m, mSrc *Header, b []byte) uint64 {
 	var o uint64 = 1
 	{
 		// Sender
@@ -212,14 +1076,24 @@ func makePatch1(m, mSrc *Header, b []byte) uint64 {
 			b[0] &= 0xFD
 		} else {
 			b[0] |= 0x02
-			o += marshal0(&m.Revision, b[o:])
+			o += marshal6(&m.Revision, b[o:])
+		}
+	}
+	{
+		// Trace
+
+		if reflect.DeepEqual(m.Trace, mSrc.Trace) {
+			b[0] &= 0xFB
+		} else {
+			b[0] |= 0x04
+			o += marshal10(&m.Trace, b[o:])
 		}
 	}
 
 	return o
 }
 
-func applyPatch1(m *Header, b []byte) uint64 {
+func applyPatch11(m *Header, b []byte) uint64 {
 	var o uint64 = 1
 	{
 		// Sender
@@ -233,124 +1107,74 @@ func applyPatch1(m *Header, b []byte) uint64 {
 		// Revision
 
 		if b[0]&0x02 != 0 {
-			o += unmarshal0(&m.Revision, b[o:])
+			o += unmarshal6(&m.Revision, b[o:])
 		}
 	}
-
-	return o
-}
-
-func size0(m *RevisionDescriptor) uint64 {
-	var n uint64 = 1
 	{
-		// Message
+		// Trace
 
-		n += size2(&m.Message)
+		if b[0]&0x04 != 0 {
+			o += unmarshal10(&m.Trace, b[o:])
+		}
 	}
-	{
-		// Index
 
-		helpers.UInt64Size(m.Index, &n)
-	}
-	return n
+	return o
 }
 
-func marshal0(m *RevisionDescriptor, b []byte) uint64 {
-	var o uint64
-	{
-		// Message
-
-		o += marshal2(&m.Message, b[o:])
-	}
-	{
-		// Index
-
-		helpers.UInt64Marshal(m.Index, b, &o)
-	}
-
-	return o
+func size10(m *TraceContext) uint64 {
+	var n uint64 = 25
+	return n
 }
 
-func unmarshal0(m *RevisionDescriptor, b []byte) uint64 {
+func marshal10(m *TraceContext, b []byte) uint64 {
 	var o uint64
 	{
-		// Message
+		// TraceID
 
-		o += unmarshal2(&m.Message, b[o:])
+		copy(b[o:o+16], unsafe.Slice(&m.TraceID[0], 16))
+		o += 16
 	}
 	{
-		// Index
+		// SpanID
 
-		helpers.UInt64Unmarshal(&m.Index, b, &o)
+		copy(b[o:o+8], unsafe.Slice(&m.SpanID[0], 8))
+		o += 8
 	}
-
-	return o
-}
-
-func size2(m *MessageDescriptor) uint64 {
-	var n uint64 = 2
 	{
-		// Namespace
+		// Flags
 
-		{
-			l := uint64(len(m.Namespace))
-			helpers.UInt64Size(l, &n)
-			n += l
-		}
+		b[o] = m.Flags
+		o++
 	}
-	{
-		// MessageID
 
-		helpers.UInt64Size(m.MessageID, &n)
-	}
-	return n
+	return o
 }
 
-func marshal2(m *MessageDescriptor, b []byte) uint64 {
+func unmarshal10(m *TraceContext, b []byte) uint64 {
 	var o uint64
 	{
-		// Namespace
-
-		{
-			l := uint64(len(m.Namespace))
-			helpers.UInt64Marshal(l, b, &o)
-			copy(b[o:o+l], m.Namespace)
-			o += l
-		}
-	}
-	{
-		// MessageID
+		// TraceID
 
-		helpers.UInt64Marshal(m.MessageID, b, &o)
+		copy(unsafe.Slice(&m.TraceID[0], 16), b[o:o+16])
+		o += 16
 	}
-
-	return o
-}
-
-func unmarshal2(m *MessageDescriptor, b []byte) uint64 {
-	var o uint64
 	{
-		// Namespace
+		// SpanID
 
-		{
-			var l uint64
-			helpers.UInt64Unmarshal(&l, b, &o)
-			if l > 0 {
-				m.Namespace = Namespace(b[o:o+l])
-				o += l
-			}
-		}
+		copy(unsafe.Slice(&m.SpanID[0], 8), b[o:o+8])
+		o += 8
 	}
 	{
-		// MessageID
+		// Flags
 
-		helpers.UInt64Unmarshal(&m.MessageID, b, &o)
+		m.Flags = b[o]
+		o++
 	}
 
 	return o
 }
 
-func size4(m *Hello) uint64 {
+func size12(m *Hello) uint64 {
 	var n uint64 = 34
 	{
 		// Requests
@@ -358,13 +1182,13 @@ func size4(m *Hello) uint64 {
 		l := uint64(len(m.Requests))
 		helpers.UInt64Size(l, &n)
 		for _, sv1 := range m.Requests {
-			n += size3(&sv1)
+			n += size0(&sv1)
 		}
 	}
 	return n
 }
 
-func marshal4(m *Hello, b []byte) uint64 {
+func marshal12(m *Hello, b []byte) uint64 {
 	var o uint64 = 1
 	{
 		// PeerID
@@ -386,14 +1210,23 @@ func marshal4(m *Hello, b []byte) uint64 {
 
 		helpers.UInt64Marshal(uint64(len(m.Requests)), b, &o)
 		for _, sv1 := range m.Requests {
-			o += marshal3(&sv1, b[o:])
+			o += marshal0(&sv1, b[o:])
+		}
+	}
+	{
+		// Probe
+
+		if m.Probe {
+			b[0] |= 0x02
+		} else {
+			b[0] &= 0xFD
 		}
 	}
 
 	return o
 }
 
-func unmarshal4(m *Hello, b []byte) uint64 {
+func unmarshal12(m *Hello, b []byte) uint64 {
 	var o uint64 = 1
 	{
 		// PeerID
@@ -414,15 +1247,20 @@ func unmarshal4(m *Hello, b []byte) uint64 {
 		if l > 0 {
 			m.Requests = make([]NamespaceRequest, l)
 			for i1 := range l {
-				o += unmarshal3(&m.Requests[i1], b[o:])
+				o += unmarshal0(&m.Requests[i1], b[o:])
 			}
 		}
 	}
+	{
+		// Probe
+
+		m.Probe = b[0]&0x02 != 0
+	}
 
 	return o
 }
 
-func isPatchNeeded4(m, mSrc *Hello) bool {
+func isPatchNeeded12(m, mSrc *Hello) bool {
 	{
 		// PeerID
 
@@ -446,11 +1284,18 @@ func isPatchNeeded4(m, mSrc *Hello) bool {
 		}
 
 	}
+	{
+		// Probe
+
+		if m.Probe != mSrc.Probe {
+			return true
+		}
+	}
 
 	return false
 }
 
-func makePatch4(m, mSrc *Hello, b []byte) uint64 {
+func makePatch12(m, mSrc *Hello, b []byte) uint64 {
 	var o uint64 = 2
 	{
 		// PeerID
@@ -481,15 +1326,24 @@ func makePatch4(m, mSrc *Hello, b []byte) uint64 {
 			b[0] |= 0x02
 			helpers.UInt64Marshal(uint64(len(m.Requests)), b, &o)
 			for _, sv1 := range m.Requests {
-				o += marshal3(&sv1, b[o:])
+				o += marshal0(&sv1, b[o:])
 			}
 		}
 	}
+	{
+		// Probe
+
+		if m.Probe == mSrc.Probe {
+			b[1] &= 0xFD
+		} else {
+			b[1] |= 0x02
+		}
+	}
 
 	return o
 }
 
-func applyPatch4(m *Hello, b []byte) uint64 {
+func applyPatch12(m *Hello, b []byte) uint64 {
 	var o uint64 = 2
 	{
 		// PeerID
@@ -515,87 +1369,16 @@ func applyPatch4(m *Hello, b []byte) uint64 {
 			if l > 0 {
 				m.Requests = make([]NamespaceRequest, l)
 				for i1 := range l {
-					o += unmarshal3(&m.Requests[i1], b[o:])
+					o += unmarshal0(&m.Requests[i1], b[o:])
 				}
 			}
 		}
 	}
-
-	return o
-}
-
-func size3(m *NamespaceRequest) uint64 {
-	var n uint64 = 2
-	{
-		// Namespace
-
-		{
-			l := uint64(len(m.Namespace))
-			helpers.UInt64Size(l, &n)
-			n += l
-		}
-	}
-	{
-		// MessageIDs
-
-		l := uint64(len(m.MessageIDs))
-		helpers.UInt64Size(l, &n)
-		n += l
-		for _, sv1 := range m.MessageIDs {
-			helpers.UInt64Size(sv1, &n)
-		}
-	}
-	return n
-}
-
-func marshal3(m *NamespaceRequest, b []byte) uint64 {
-	var o uint64
-	{
-		// Namespace
-
-		{
-			l := uint64(len(m.Namespace))
-			helpers.UInt64Marshal(l, b, &o)
-			copy(b[o:o+l], m.Namespace)
-			o += l
-		}
-	}
-	{
-		// MessageIDs
-
-		helpers.UInt64Marshal(uint64(len(m.MessageIDs)), b, &o)
-		for _, sv1 := range m.MessageIDs {
-			helpers.UInt64Marshal(sv1, b, &o)
-		}
-	}
-
-	return o
-}
-
-func unmarshal3(m *NamespaceRequest, b []byte) uint64 {
-	var o uint64
-	{
-		// Namespace
-
-		{
-			var l uint64
-			helpers.UInt64Unmarshal(&l, b, &o)
-			if l > 0 {
-				m.Namespace = Namespace(b[o:o+l])
-				o += l
-			}
-		}
-	}
 	{
-		// MessageIDs
+		// Probe
 
-		var l uint64
-		helpers.UInt64Unmarshal(&l, b, &o)
-		if l > 0 {
-			m.MessageIDs = make([]MessageID, l)
-			for i1 := range l {
-				helpers.UInt64Unmarshal(&m.MessageIDs[i1], b, &o)
-			}
+		if b[1]&0x02 != 0 {
+			m.Probe = !m.Probe
 		}
 	}
 